@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,8 +12,10 @@ import (
 
 	_ "net/http/pprof"
 
-	"github.com/caarlos0/env/v6"
 	"github.com/evildead81/metrics-and-alerts/internal/agent"
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
 )
 
 var (
@@ -40,133 +41,101 @@ func printBuildParams() {
 }
 
 func main() {
-	var endpointParam = flag.String("a", "localhost:8080", "Server endpoint")
-	var reportIntervalParam = flag.Int64("r", 10, "Report interval")
-	var pollIntervalParam = flag.Int64("p", 2, "Poll interval")
-	var keyParam = flag.String("k", "", "Secret key")
-	var rateLimitParam = flag.Int("l", 0, "Parallels sends cound")
-	var cryptoKeyPathParam = flag.String("crypto-key", "", "Public key")
-	var configPathParam = flag.String("c", "", "Config path")
+	defaults := agent.DefaultConfig()
+	loader := configloader.New(flag.CommandLine, &defaults, "ConfigPath")
 	flag.Parse()
+
 	var cfg agent.AgentConfig
-	err := env.Parse(&cfg)
-
-	var endpoint *string
-	var reportInterval *int64
-	var pollInterval *int64
-	var key *string
-	var rateLimit *int
-	var cryptoKeyPath *string
-	var configPath *string
-	switch {
-	case err == nil:
-		{
-			if len(cfg.Address) != 0 {
-				endpoint = &cfg.Address
-			} else {
-				endpoint = endpointParam
-			}
-			if cfg.ReportInterval != 0 {
-				reportInterval = &cfg.ReportInterval
-			} else {
-				reportInterval = reportIntervalParam
-			}
-			if cfg.PollInterval != 0 {
-				pollInterval = &cfg.PollInterval
-			} else {
-				pollInterval = pollIntervalParam
-			}
-			if len(cfg.Key) != 0 {
-				key = &cfg.Key
-			} else {
-				key = keyParam
-			}
-			if cfg.RateLimit != 0 {
-				rateLimit = &cfg.RateLimit
-			} else {
-				rateLimit = rateLimitParam
-			}
-			if cfg.CryptoKey != "" {
-				cryptoKeyPath = &cfg.CryptoKey
-			} else {
-				cryptoKeyPath = cryptoKeyPathParam
-			}
-			if cfg.ConfigPath != "" {
-				configPath = &cfg.ConfigPath
-			} else {
-				configPath = configPathParam
-			}
-		}
-	default:
-		log.Fatal("Agent env params parse error")
-		endpoint = endpointParam
-		reportInterval = reportIntervalParam
-		pollInterval = pollIntervalParam
+	sources, err := loader.Load(&cfg)
+	err = errors.Join(err, cfg.Validate())
+	if err != nil {
+		logger.Logger.Fatalw("Invalid agent config", "error", err.Error())
 	}
+	if err := logger.Configure(cfg.Logging()); err != nil {
+		logger.Logger.Fatalw("Failed to configure logger", "error", err.Error())
+	}
+	defer logger.Logger.Sync()
+	warnUnknownKeys(loader)
 
-	if len(*configPath) != 0 {
-		content, err := os.ReadFile(*configPath)
-		if err != nil {
-			panic(err)
+	shutdownTracing, err := tracing.Setup(cfg.Tracing())
+	if err != nil {
+		logger.Logger.Fatalw("Failed to set up tracing", "error", err.Error())
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Logger.Errorw("Failed to flush traces", "error", err.Error())
 		}
+	}()
 
-		var fConfig agent.AgentConfig
-		err = json.Unmarshal(content, &fConfig)
-		if err != nil {
-			panic(err)
-		}
+	printBuildParams()
+	logger.Logger.Debugw("Effective config", "config", configloader.Describe(cfg, sources))
 
-		if len(*endpoint) == 0 {
-			endpoint = &fConfig.Address
-		}
-		if *reportInterval == 0 {
-			reportInterval = &fConfig.ReportInterval
-		}
-		if *pollInterval == 0 {
-			pollInterval = &fConfig.PollInterval
-		}
-		if len(*key) == 0 {
-			key = &fConfig.Key
-		}
-		if *rateLimit == 0 {
-			rateLimit = &fConfig.RateLimit
-		}
-		if len(*cryptoKeyPath) == 0 {
-			cryptoKeyPath = &fConfig.CryptoKey
-		}
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	printBuildParams()
+	a, err := agent.NewWithConfig(ctx, cfg)
+	if err != nil {
+		logger.Logger.Fatalw("Error while creating agent", "error", err.Error())
+	}
 
 	srvErrs := make(chan error, 1)
 	go func() {
-		srvErrs <- agent.New(
-			*endpoint,
-			time.Duration(*pollInterval)*time.Second,
-			time.Duration(*reportInterval)*time.Second,
-			context.Background(),
-			*key,
-			*rateLimit,
-			*cryptoKeyPath,
-		).Run()
+		srvErrs <- a.Run()
 	}()
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	shutdown := gracefulShutdown()
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
-	select {
-	case err := <-srvErrs:
-		shutdown(err)
-	case sig := <-quit:
-		shutdown(sig)
+	shutdown := gracefulShutdown(cancel, srvErrs)
+
+	for {
+		select {
+		case err := <-srvErrs:
+			if err != nil {
+				logger.Logger.Fatalw("Agent stopped with error", "error", err.Error())
+			}
+			return
+		case sig := <-quit:
+			shutdown(sig)
+			return
+		case <-reload:
+			var cfg agent.AgentConfig
+			_, err := loader.Load(&cfg)
+			warnUnknownKeys(loader)
+			if err == nil {
+				err = a.Reload(cfg)
+			}
+			if err != nil {
+				logger.Logger.Errorw("Agent config reload rejected", "error", err.Error())
+				continue
+			}
+			logger.Logger.Info("Agent config reloaded")
+		}
 	}
 }
 
-func gracefulShutdown() func(reason interface{}) {
+// gracefulShutdown останавливает агента и ждет, пока он отправит оставшиеся метрики.
+// Время на отправку ограничено параметром shutdown_timeout агента.
+func gracefulShutdown(cancel context.CancelFunc, srvErrs <-chan error) func(reason interface{}) {
 	return func(reason interface{}) {
-		_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		logger.Logger.Infow("Agent is shutting down", "reason", reason)
+		cancel()
+		if err := <-srvErrs; err != nil {
+			logger.Logger.Errorw("Agent stopped with error", "error", err.Error())
+			return
+		}
+		logger.Logger.Info("Agent stopped")
+	}
+}
+
+// warnUnknownKeys предупреждает о ключах файла конфигурации, которые не были применены.
+func warnUnknownKeys(loader *configloader.Loader) {
+	if keys := loader.UnknownKeys(); len(keys) != 0 {
+		logger.Logger.Warnw("Unknown config keys are ignored", "keys", keys)
 	}
 }
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/caarlos0/env"
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
 	"github.com/evildead81/metrics-and-alerts/internal/server/config"
 	"github.com/evildead81/metrics-and-alerts/internal/server/instance"
-	"github.com/evildead81/metrics-and-alerts/internal/server/logger"
 	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	boltstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/bolt-storage"
 	dbstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/db-storage"
 	memstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/mem-storage"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
 	"github.com/jackc/pgerrcode"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -57,128 +64,105 @@ func printBuildParams() {
 }
 
 func main() {
-	var endpointParam = flag.String("a", "localhost:8080", "Server endpoint")
-	var storeIntervalParam = flag.Int64("i", 300, "Save metrics into file interval")
-	var fileStoragePathParam = flag.String("f", "./metrics.json", "File storage path")
-	var restoreParam = flag.Bool("r", true, "Restore from file flag")
-	var connStrParam = flag.String("d", "", "DB connection string")
-	var keyParam = flag.String("k", "", "Secret key")
-	var cryptoKeyPathParam = flag.String("crypto-key", "", "Public key")
-	var configPathParam = flag.String("c", "", "Config path")
+	defaults := config.DefaultConfig()
+	loader := configloader.New(flag.CommandLine, &defaults, "ConfigPath")
+	checkConfig := flag.Bool("check-config", false, "Validate config and exit")
 	flag.Parse()
+
 	var cfg config.ServerConfig
-	err := env.Parse(&cfg)
-
-	var endpoint *string
-	var storeInterval *int64
-	var fileStoragePath *string
-	var restore *bool
-	var connStr *string
-	var key *string
-	var cryptoKeyPath *string
-	var configPath *string
-	switch {
-	case err == nil:
-		if len(cfg.Address) != 0 {
-			endpoint = &cfg.Address
-		} else {
-			endpoint = endpointParam
-		}
-		if cfg.StoreInterval != 0 {
-			storeInterval = &cfg.StoreInterval
-		} else {
-			storeInterval = storeIntervalParam
-		}
-		if len(cfg.FileStoragePath) != 0 {
-			fileStoragePath = &cfg.FileStoragePath
-		} else {
-			fileStoragePath = fileStoragePathParam
-		}
-		if cfg.Restore {
-			restore = &cfg.Restore
-		} else {
-			restore = restoreParam
-		}
-		if len(cfg.DatabaseDSN) != 0 {
-			connStr = &cfg.DatabaseDSN
-		} else {
-			connStr = connStrParam
-		}
-		if len(cfg.Key) != 0 {
-			key = &cfg.Key
-		} else {
-			key = keyParam
-		}
-		if cfg.CryptoKey != "" {
-			cryptoKeyPath = &cfg.CryptoKey
-		} else {
-			cryptoKeyPath = cryptoKeyPathParam
-		}
-		if cfg.ConfigPath != "" {
-			configPath = &cfg.ConfigPath
-		} else {
-			configPath = configPathParam
-		}
-	default:
-		logger.Logger.Fatalw("Server env params parse error", "error", err.Error())
-		endpoint = endpointParam
-	}
+	sources, err := loader.Load(&cfg)
+	err = errors.Join(err, cfg.Validate())
 
-	if len(*configPath) != 0 {
-		content, err := os.ReadFile(*configPath)
-		if err != nil {
-			panic(err)
+	if *checkConfig {
+		fmt.Println(configloader.Describe(cfg, sources))
+		if keys := loader.UnknownKeys(); len(keys) != 0 {
+			fmt.Fprintf(os.Stderr, "Unknown config keys are ignored: %s\n", strings.Join(keys, ", "))
 		}
-
-		var fConfig config.ServerConfig
-		err = json.Unmarshal(content, &fConfig)
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			log.Fatal("Config check failed")
 		}
+		fmt.Println("Config OK")
+		return
+	}
 
-		if len(*endpoint) == 0 {
-			endpoint = &fConfig.Address
-		}
-		if *storeInterval == 0 {
-			storeInterval = &fConfig.StoreInterval
-		}
-		if len(*fileStoragePath) == 0 {
-			fileStoragePath = &fConfig.FileStoragePath
-		}
-		if !*restore {
-			restore = &fConfig.Restore
-		}
-		if len(*cryptoKeyPath) == 0 {
-			cryptoKeyPath = &fConfig.CryptoKey
-		}
-		if len(*connStr) == 0 {
-			connStr = &fConfig.DatabaseDSN
-		}
-		if len(*key) == 0 {
-			key = &fConfig.Key
-		}
+	if err != nil {
+		logger.Logger.Fatalw("Invalid server config", "error", err.Error())
+	}
+	if err := logger.Configure(cfg.Logging()); err != nil {
+		logger.Logger.Fatalw("Failed to configure logger", "error", err.Error())
+	}
+	defer logger.Logger.Sync()
+	warnUnknownKeys(loader)
 
+	shutdownTracing, err := tracing.Setup(cfg.Tracing())
+	if err != nil {
+		logger.Logger.Fatalw("Failed to set up tracing", "error", err.Error())
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Logger.Errorw("Failed to flush traces", "error", err.Error())
+		}
+	}()
 
 	var storage storages.Storage
-	if len(*connStr) != 0 {
-		db, err := tryOpenDB(*connStr, 0)
+	if len(cfg.DatabaseDSN) != 0 {
+		db, err := tryOpenDB(cfg.DatabaseDSN, 0)
 		if err != nil {
 			logger.Logger.Fatalw("Error while connect to DB", "error", err.Error())
 		}
 		defer db.Close()
 		storage = dbstorage.New(db)
+	} else if len(cfg.BoltPath) != 0 {
+		boltStorage, err := boltstorage.New(cfg.BoltPath)
+		if err != nil {
+			logger.Logger.Fatalw("Error while opening bolt storage", "error", err.Error())
+		}
+		defer boltStorage.Close()
+		storage = boltStorage
 	} else {
-		storage = memstorage.New(*fileStoragePath, *restore)
+		memStorage, err := memstorage.NewWithOptions(cfg.FileStoragePath, cfg.Restore, cfg.MemStorage())
+		if err != nil {
+			logger.Logger.Fatalw("Error while opening file storage", "error", err.Error())
+		}
+		defer memStorage.Close()
+		storage = memStorage
 	}
 
 	printBuildParams()
+	logger.Logger.Debugw("Effective config", "config", configloader.Describe(cfg, sources))
 
-	instance.New(
-		*endpoint,
-		&storage,
-		time.Duration(*storeInterval)*time.Second,
-		*key,
-		*cryptoKeyPath,
-	).Run()
+	srv, err := instance.NewWithConfig(&storage, cfg)
+	if err != nil {
+		logger.Logger.Fatalw("Error while creating server", "error", err.Error())
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			var cfg config.ServerConfig
+			_, err := loader.Load(&cfg)
+			warnUnknownKeys(loader)
+			if err == nil {
+				err = srv.Reload(cfg)
+			}
+			if err != nil {
+				logger.Logger.Errorw("Server config reload rejected", "error", err.Error())
+				continue
+			}
+			logger.Logger.Info("Server config reloaded")
+		}
+	}()
+
+	srv.Run()
+}
+
+// warnUnknownKeys предупреждает о ключах файла конфигурации, которые не были применены.
+func warnUnknownKeys(loader *configloader.Loader) {
+	if keys := loader.UnknownKeys(); len(keys) != 0 {
+		logger.Logger.Warnw("Unknown config keys are ignored", "keys", keys)
+	}
 }
@@ -2,43 +2,68 @@ package agent
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	cryproRand "crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
-	"math/rand"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"runtime"
-	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/evildead81/metrics-and-alerts/internal/agent/procfs"
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
 	"github.com/evildead81/metrics-and-alerts/internal/contracts"
 	hash "github.com/evildead81/metrics-and-alerts/internal/hash"
-	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Agent struct {
-	gaugeMetrics   map[string]float64
-	counterMetrics map[string]int64
-	counter        int64
-	host           string
-	pollInterval   time.Duration
-	reportInterval time.Duration
-	mutex          *sync.Mutex
-	ctx            context.Context
-	sendAttempts   uint8
-	key            string
-	rateLimit      int
-	publicKey      *rsa.PublicKey
+	ctx           context.Context
+	settings      settings
+	settingsMutex *sync.RWMutex
+	registry      *Registry
+	aggregator    *aggregator
+	telemetry     *telemetry
+	// outboxes - очереди получателей по именам, senders - их отправители после запуска Run.
+	outboxes      map[string]*outbox
+	outboxesMutex *sync.Mutex
+	senders       *sync.WaitGroup
+	sendersCtx    context.Context
+	// pushAddress и pushSocket - адреса локального приемника метрик. Меняются только перезапуском.
+	pushAddress string
+	pushSocket  string
+	// outboxPath - файл, куда сохраняются неотправленные пачки при остановке.
+	outboxPath string
+	// agentID - идентификатор агента, передается серверу в заголовке запроса.
+	agentID string
+	// codings - кодировки тел запросов, выбранные по ответам получателей, по именам получателей.
+	codings *sync.Map
+}
+
+// settings - параметры агента, которые можно изменить без перезапуска.
+type settings struct {
+	destinations    []destination
+	destinationMode string
+	pollInterval    time.Duration
+	reportInterval  time.Duration
+	rateLimit       int
+	batchSize       int
+	gaugeFuncs      []string
+	shutdownTimeout time.Duration
+	procfs          procfsSettings
+	// compression - настроенное сжатие тел запросов, compressionThreshold - минимальный
+	// размер тела в байтах, с которого оно сжимается.
+	compression          string
+	compressionThreshold int
 }
 
 // New создает инстанс агента.
@@ -51,203 +76,290 @@ func New(
 	rateLimit int,
 	cryptoKeyPath string,
 ) *Agent {
-	agent := &Agent{
-		gaugeMetrics:   make(map[string]float64),
-		counterMetrics: make(map[string]int64),
-		counter:        0,
-		host:           "http://" + host,
-		pollInterval:   pollInterval,
-		reportInterval: reportInterval,
-		mutex:          &sync.Mutex{},
-		ctx:            ctx,
-		key:            key,
-		rateLimit:      rateLimit,
-	}
-
-	if len(cryptoKeyPath) != 0 {
-		publicKeyPEM, err := os.ReadFile(cryptoKeyPath)
-		if err != nil {
-			panic(err)
-		}
-		publicKeyBlock, _ := pem.Decode(publicKeyPEM)
-		publicKey, err := x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
-		if err != nil {
-			panic(err)
-		}
-
-		agent.publicKey = publicKey
+	agent, err := NewWithConfig(ctx, AgentConfig{
+		Address:        host,
+		PollInterval:   configloader.Duration{Duration: pollInterval},
+		ReportInterval: configloader.Duration{Duration: reportInterval},
+		Key:            key,
+		RateLimit:      rateLimit,
+		CryptoKey:      cryptoKeyPath,
+	})
+	if err != nil {
+		panic(err)
 	}
 
 	return agent
 }
 
-// Run запускает процесс отправки метрик на указаннй эндпоинт.
-func (t Agent) Run() error {
-	if t.rateLimit == 0 {
-		go func() error {
-			for {
-				time.Sleep(t.reportInterval)
-				t.sendMeticList()
-			}
-		}()
-
-		for {
-			select {
-			case <-t.ctx.Done():
-				return nil
-			default:
-				t.refreshMetrics()
-				time.Sleep(t.pollInterval)
-			}
-		}
-	} else {
-		jobs := make(chan contracts.Metrics, 100)
-
-		var wg sync.WaitGroup
+// NewWithConfig создает инстанс агента по конфигурации.
+func NewWithConfig(ctx context.Context, cfg AgentConfig) (*Agent, error) {
+	s, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-		for i := 1; i <= t.rateLimit; i++ {
-			wg.Add(1)
-			go t.worker(jobs, &wg)
+	agent := &Agent{
+		ctx:           ctx,
+		settings:      s,
+		settingsMutex: &sync.RWMutex{},
+		registry:      NewRegistry(),
+		aggregator:    newAggregator(),
+		telemetry:     &telemetry{},
+		outboxes:      make(map[string]*outbox),
+		outboxesMutex: &sync.Mutex{},
+		pushAddress:   cfg.PushAddress,
+		pushSocket:    cfg.PushSocket,
+		outboxPath:    cfg.OutboxPath,
+		codings:       &sync.Map{},
+		agentID:       cfg.AgentID,
+	}
+	if len(agent.agentID) == 0 {
+		agent.agentID, _ = os.Hostname()
+	}
+	if len(agent.outboxPath) != 0 {
+		if err := agent.loadOutboxes(agent.outboxPath); err != nil {
+			return nil, err
 		}
+	}
 
-		go t.readMetircs(jobs)
-		go t.readAdditionalMetrics(jobs)
-		time.Sleep(t.reportInterval)
-
-		close(jobs)
-		wg.Wait()
-
+	builtin := []Collector{
+		runtimeCollector{},
+		memoryCollector{},
+		cpuCollector{},
+		newSystemCollector(func() procfsSettings { return agent.currentSettings().procfs }),
+	}
+	for _, c := range builtin {
+		if err := agent.Register(c, collectorOptions(cfg, c.Name())); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SelfTelemetry {
+		prefix := cfg.SelfTelemetryPrefix
+		if len(prefix) == 0 {
+			prefix = DefaultTelemetryPrefix
+		}
+		builtin := &telemetryCollector{
+			prefix:     prefix,
+			telemetry:  agent.telemetry,
+			queueDepth: func() int { return agent.aggregator.size() + agent.pending() },
+		}
+		if err := agent.Register(builtin, collectorOptions(cfg, builtin.Name())); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range cfg.ScrapeTargets {
+		c, err := newScrapeCollector(target)
+		if err != nil {
+			return nil, err
+		}
+		if err := agent.Register(c, collectorOptions(cfg, c.Name())); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range cfg.Exec {
+		options := CollectorOptions{Interval: e.Interval.Duration, Timeout: e.Timeout.Duration}
+		if err := agent.Register(newExecCollector(e.Name, e.Command, e.Format), options); err != nil {
+			return nil, err
+		}
 	}
 
-	return nil
+	return agent, nil
 }
 
-func (t *Agent) worker(jobs <-chan contracts.Metrics, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	for j := range jobs {
-		t.serializeMetricAndPost(&j)
+// collectorOptions возвращает расписание сборщика из секции collectors конфигурации.
+func collectorOptions(cfg AgentConfig, name string) CollectorOptions {
+	cc := cfg.Collectors[name]
+	return CollectorOptions{
+		Interval: cc.Interval.Duration,
+		Timeout:  cc.Timeout.Duration,
 	}
 }
 
-func (t *Agent) sendGauge(name string, value float64) {
-	metric := contracts.Metrics{
-		ID:    name,
-		Value: &value,
-		MType: consts.Gauge,
+func newSettings(cfg AgentConfig) (settings, error) {
+	s := settings{
+		destinationMode:      cfg.DestinationMode,
+		pollInterval:         cfg.PollInterval.Duration,
+		reportInterval:       cfg.ReportInterval.Duration,
+		rateLimit:            cfg.RateLimit,
+		batchSize:            cfg.BatchSize,
+		gaugeFuncs:           cfg.GaugeAggregation,
+		shutdownTimeout:      cfg.ShutdownTimeout.Duration,
+		compression:          cfg.Compression,
+		compressionThreshold: cfg.CompressionThreshold,
+		procfs: procfsSettings{
+			root:            cfg.ProcfsRoot,
+			load:            cfg.CollectLoad,
+			network:         cfg.CollectNetwork,
+			disk:            cfg.CollectDisk,
+			filesystem:      cfg.CollectFilesystem,
+			fileDescriptors: cfg.CollectFileDescriptors,
+			contextSwitches: cfg.CollectContextSwitches,
+		},
+	}
+	if len(s.procfs.root) == 0 {
+		s.procfs.root = procfs.DefaultRoot
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultBatchSize
+	}
+	if len(s.gaugeFuncs) == 0 {
+		s.gaugeFuncs = []string{AggregateLast}
 	}
-	t.serializeMetricAndPost(&metric)
-}
 
-func (t *Agent) sendCounter(name string, delta int64) {
-	metric := contracts.Metrics{
-		ID:    name,
-		Delta: &delta,
-		MType: consts.Counter,
+	if s.shutdownTimeout <= 0 {
+		s.shutdownTimeout = DefaultShutdownTimeout
+	}
+	if len(s.destinationMode) == 0 {
+		s.destinationMode = DestinationFanout
+	}
+	if len(s.compression) == 0 {
+		s.compression = CompressionGzip
 	}
-	t.serializeMetricAndPost(&metric)
-}
 
-func (t *Agent) sendMetricsByOne() error {
-	for name, value := range t.gaugeMetrics {
-		t.sendGauge(name, value)
+	destinations := cfg.Destinations
+	if len(destinations) == 0 {
+		destinations = []DestinationConfig{{Address: cfg.Address, Key: cfg.Key, CryptoKey: cfg.CryptoKey}}
 	}
-	for name, value := range t.counterMetrics {
-		t.sendCounter(name, value)
+	for _, dc := range destinations {
+		d, err := newDestination(dc)
+		if err != nil {
+			return s, err
+		}
+		s.destinations = append(s.destinations, d)
 	}
-	return nil
+
+	return s, nil
 }
 
-func (t *Agent) sendMeticList() error {
-	metrics := make([]contracts.Metrics, 0)
-	for name, value := range t.gaugeMetrics {
-		metrics = append(metrics, contracts.Metrics{
-			ID:    name,
-			Value: &value,
-			MType: consts.Gauge,
-		})
-	}
-	for name, value := range t.counterMetrics {
-		metrics = append(metrics, contracts.Metrics{
-			ID:    name,
-			Delta: &value,
-			MType: consts.Counter,
-		})
+// Reload применяет новую конфигурацию к работающему агенту.
+// Собранные метрики сохраняются. При ошибке текущие параметры не меняются.
+func (t *Agent) Reload(cfg AgentConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
 	}
 
-	err := t.serializeMetricsAndPost(&metrics)
+	s, err := newSettings(cfg)
 	if err != nil {
-		if errors.Is(err, syscall.ECONNREFUSED) {
-			if t.sendAttempts < 3 {
-				t.sendAttempts += 1
-				time.Sleep(time.Duration(t.sendAttempts*2-1) * time.Second)
-				t.sendMeticList()
-			}
-		}
 		return err
 	}
+
+	// Уровень логирования применяется первым: если он не подошел,
+	// параметры агента еще не заменены.
+	if len(cfg.LogLevel) != 0 {
+		if err := logger.SetLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+
+	t.settingsMutex.Lock()
+	t.settings = s
+	t.settingsMutex.Unlock()
 	return nil
 }
 
-func (t *Agent) serializeMetricAndPost(metric *contracts.Metrics) error {
-	url := t.host + "/update/"
-	serialized, serErr := json.Marshal(metric)
-	if serErr != nil {
-		return serErr
+func (t *Agent) currentSettings() settings {
+	t.settingsMutex.RLock()
+	defer t.settingsMutex.RUnlock()
+	return t.settings
+}
+
+// Run запускает сбор метрик и их отправку на сервер раз в интервал отправки.
+// Метрики за интервал агрегируются и уходят пачками по batchSize; число
+// параллельных запросов к каждому получателю ограничено rateLimit.
+//
+// После отмены контекста агента Run останавливает прием, сборщики и отправителей,
+// затем в пределах shutdownTimeout собирает и отправляет оставшиеся метрики.
+func (t *Agent) Run() error {
+	push, err := t.startPush(t.collected)
+	if err != nil {
+		return err
 	}
+	collectors := t.runCollectors(t.ctx, t.collected)
+	senders := t.startSenders(t.ctx)
 
-	var encryptedData []byte
-	if t.publicKey != nil {
-		encryptedData, serErr = rsa.EncryptPKCS1v15(cryproRand.Reader, t.publicKey, serialized)
-		if serErr != nil {
-			return serErr
+	for {
+		select {
+		case <-t.ctx.Done():
+			t.stopPush(push)
+			collectors.Wait()
+			senders.Wait()
+
+			ctx, cancel := context.WithTimeout(context.Background(), t.currentSettings().shutdownTimeout)
+			defer cancel()
+			return t.finalFlush(ctx)
+		case <-time.After(t.currentSettings().reportInterval):
+			t.report()
 		}
-	} else {
-		encryptedData = serialized
 	}
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(encryptedData))
+// startPush запускает локальный приемник метрик, если он включен в конфигурации.
+func (t *Agent) startPush(sink func([]contracts.Metrics)) (*pushServer, error) {
+	if len(t.pushAddress) == 0 && len(t.pushSocket) == 0 {
+		return nil, nil
+	}
+	push, err := newPushServer(t.pushAddress, t.pushSocket, sink)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	push.start()
+	return push, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	zb := gzip.NewWriter(buf)
-	_, zipErr := zb.Write(encryptedData)
-	if zipErr != nil {
-		return zipErr
+func (t *Agent) stopPush(push *pushServer) {
+	if push == nil {
+		return
 	}
-	defer zb.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	push.shutdown(ctx)
+}
 
-	if len(t.key) != 0 {
-		hashStr, err := hash.Hash(encryptedData, t.key)
-		if err != nil {
+// collected передает метрики от сборщиков и приложений в агрегатор.
+func (t *Agent) collected(metrics []contracts.Metrics) {
+	t.telemetry.samples.Add(int64(len(metrics)))
+	t.aggregator.add(metrics)
+}
+
+// report передает итоги интервала пачками в очереди получателей.
+func (t *Agent) report() {
+	s := t.currentSettings()
+	t.enqueue(s, batches(t.aggregator.flush(s.gaugeFuncs), s.batchSize))
+}
+
+// postWithRetry отправляет пачку, повторяя попытку, пока получатель недоступен.
+// Повторы прекращаются при отмене ctx.
+func (t *Agent) postWithRetry(ctx context.Context, d destination, batch []contracts.Metrics) error {
+	err := t.serializeMetricsAndPost(ctx, d, &batch)
+	for attempt := 1; attempt <= 3 && errors.Is(err, syscall.ECONNREFUSED); attempt++ {
+		select {
+		case <-ctx.Done():
 			return err
+		case <-time.After(time.Duration(attempt*2-1) * time.Second):
 		}
-		req.Header.Set(hash.HashHeaderKey, hashStr)
-	}
-
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	req.Header.Set("Accept-Encoding", "gzip")
-	response, reqErr := http.DefaultClient.Do(req)
-	if reqErr != nil {
-		return reqErr
+		t.telemetry.retries.Add(1)
+		err = t.serializeMetricsAndPost(ctx, d, &batch)
 	}
-	defer response.Body.Close()
-
-	return nil
+	return err
 }
 
-func (t *Agent) serializeMetricsAndPost(metrics *[]contracts.Metrics) error {
-	url := t.host + "/updates/"
+// serializeMetricsAndPost сериализует, шифрует и отправляет пачку. Отправка
+// записывается в трассу, контекст которой передается серверу в заголовке traceparent.
+func (t *Agent) serializeMetricsAndPost(ctx context.Context, d destination, metrics *[]contracts.Metrics) (err error) {
+	ctx, span := tracing.Start(ctx, "send batch", trace.WithAttributes(
+		attribute.String("destination", d.name),
+		attribute.Int("metrics.count", len(*metrics)),
+	))
+	defer func() { tracing.End(span, err) }()
+
 	serialized, err := json.Marshal(metrics)
 	if err != nil {
 		return err
 	}
 
 	var encryptedData []byte
-	if t.publicKey != nil {
-		encryptedData, err = rsa.EncryptPKCS1v15(cryproRand.Reader, t.publicKey, serialized)
+	if d.publicKey != nil {
+		encryptedData, err = rsa.EncryptPKCS1v15(cryproRand.Reader, d.publicKey, serialized)
 		if err != nil {
 			return err
 		}
@@ -255,182 +367,81 @@ func (t *Agent) serializeMetricsAndPost(metrics *[]contracts.Metrics) error {
 		encryptedData = serialized
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(encryptedData))
+	s := t.currentSettings()
+	coding := t.requestCoding(s, d, len(encryptedData))
+	response, err := t.post(ctx, d, encryptedData, coding)
 	if err != nil {
 		return err
 	}
-
-	buf := bytes.NewBuffer(nil)
-	zb := gzip.NewWriter(buf)
-	_, zipErr := zb.Write(encryptedData)
-	if zipErr != nil {
-		return zipErr
-	}
-	defer zb.Close()
-
-	if len(t.key) != 0 {
-		hashStr, err := hash.Hash(encryptedData, t.key)
+	if response.StatusCode == http.StatusUnsupportedMediaType && coding != identity {
+		// Сервер не понимает кодировку: запоминаем ту, что он предложил, и повторяем.
+		fallback := preferredCoding(response.Header)
+		if fallback == coding {
+			fallback = identity
+		}
+		logger.Logger.Warnf("Destination %s rejected %s request body, switching to %s", d.name, coding, fallback)
+		t.codings.Store(d.name, fallback)
+		response, err = t.post(ctx, d, encryptedData, fallback)
 		if err != nil {
 			return err
 		}
-		req.Header.Set(hash.HashHeaderKey, hashStr)
+	} else if s.compression == CompressionAuto && len(response.Header.Values("Accept-Encoding")) != 0 {
+		t.codings.Store(d.name, preferredCoding(response.Header))
 	}
-
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	req.Header.Set("Accept-Encoding", "gzip")
-	response, reqErr := http.DefaultClient.Do(req)
-	if reqErr != nil {
-		return reqErr
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", d.url, response.Status)
 	}
-	defer response.Body.Close()
 	return nil
 }
 
-func (t *Agent) readMetircs(runtimeMetricsChan chan contracts.Metrics) {
-	for {
-		var stats runtime.MemStats
-		runtime.ReadMemStats(&stats)
-		alloc := float64(stats.Alloc)
-		runtimeMetricsChan <- contracts.Metrics{ID: "Alloc", Value: &alloc, MType: consts.Gauge}
-
-		frees := float64(stats.Frees)
-		runtimeMetricsChan <- contracts.Metrics{ID: "Frees", Value: &frees, MType: consts.Gauge}
-
-		gccgpufraction := float64(stats.GCCPUFraction)
-		runtimeMetricsChan <- contracts.Metrics{ID: "GCCPUFraction", Value: &gccgpufraction, MType: consts.Gauge}
-
-		gcsys := float64(stats.GCSys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "GCSys", Value: &gcsys, MType: consts.Gauge}
-
-		heapalloc := float64(stats.HeapAlloc)
-		runtimeMetricsChan <- contracts.Metrics{ID: "HeapAlloc", Value: &heapalloc, MType: consts.Gauge}
-
-		heapidle := float64(stats.HeapIdle)
-		runtimeMetricsChan <- contracts.Metrics{ID: "HeapIdle", Value: &heapidle, MType: consts.Gauge}
-
-		heapinuse := float64(stats.HeapInuse)
-		runtimeMetricsChan <- contracts.Metrics{ID: "HeapInuse", Value: &heapinuse, MType: consts.Gauge}
-
-		heapobjects := float64(stats.HeapObjects)
-		runtimeMetricsChan <- contracts.Metrics{ID: "HeapObjects", Value: &heapobjects, MType: consts.Gauge}
-
-		headreleased := float64(stats.HeapReleased)
-		runtimeMetricsChan <- contracts.Metrics{ID: "HeapReleased", Value: &headreleased, MType: consts.Gauge}
-
-		heapsys := float64(stats.HeapSys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "HeapSys", Value: &heapsys, MType: consts.Gauge}
-
-		lastgc := float64(stats.LastGC)
-		runtimeMetricsChan <- contracts.Metrics{ID: "LastGC", Value: &lastgc, MType: consts.Gauge}
-
-		lookups := float64(stats.Lookups)
-		runtimeMetricsChan <- contracts.Metrics{ID: "Lookups", Value: &lookups, MType: consts.Gauge}
-
-		mcacheinuse := float64(stats.MCacheInuse)
-		runtimeMetricsChan <- contracts.Metrics{ID: "MCacheInuse", Value: &mcacheinuse, MType: consts.Gauge}
-
-		mcachesys := float64(stats.MCacheSys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "MCacheSys", Value: &mcachesys, MType: consts.Gauge}
-
-		mspaninuse := float64(stats.MSpanInuse)
-		runtimeMetricsChan <- contracts.Metrics{ID: "MSpanInuse", Value: &mspaninuse, MType: consts.Gauge}
-
-		mspansys := float64(stats.MSpanSys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "MSpanSys", Value: &mspansys, MType: consts.Gauge}
-
-		mallocs := float64(stats.Mallocs)
-		runtimeMetricsChan <- contracts.Metrics{ID: "Mallocs", Value: &mallocs, MType: consts.Gauge}
-
-		nextgc := float64(stats.NextGC)
-		runtimeMetricsChan <- contracts.Metrics{ID: "NextGC", Value: &nextgc, MType: consts.Gauge}
-
-		numforgedgc := float64(stats.NumForcedGC)
-		runtimeMetricsChan <- contracts.Metrics{ID: "NumForcedGC", Value: &numforgedgc, MType: consts.Gauge}
-
-		othersys := float64(stats.OtherSys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "OtherSys", Value: &othersys, MType: consts.Gauge}
-
-		pausetotalns := float64(stats.PauseTotalNs)
-		runtimeMetricsChan <- contracts.Metrics{ID: "PauseTotalNs", Value: &pausetotalns, MType: consts.Gauge}
-
-		stackinuse := float64(stats.StackInuse)
-		runtimeMetricsChan <- contracts.Metrics{ID: "StackInuse", Value: &stackinuse, MType: consts.Gauge}
-
-		stacksys := float64(stats.StackSys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "StackSys", Value: &stacksys, MType: consts.Gauge}
-
-		sys := float64(stats.Sys)
-		runtimeMetricsChan <- contracts.Metrics{ID: "Sys", Value: &sys, MType: consts.Gauge}
-
-		totalalloc := float64(stats.TotalAlloc)
-		runtimeMetricsChan <- contracts.Metrics{ID: "TotalAlloc", Value: &totalalloc, MType: consts.Gauge}
-
-		randomvalue := rand.Float64()
-		runtimeMetricsChan <- contracts.Metrics{ID: "RandomValue", Value: &randomvalue, MType: consts.Gauge}
-
-		t.counterMetrics["PollCount"] += 1
-		pollcount := t.counterMetrics["PollCount"]
-		runtimeMetricsChan <- contracts.Metrics{ID: "PollCount", Delta: &pollcount, MType: consts.Counter}
+// post отправляет пачку, сжатую в указанной кодировке. Подпись считается
+// по несжатым данным: сервер проверяет ее после распаковки.
+func (t *Agent) post(ctx context.Context, d destination, data []byte, coding string) (response *http.Response, err error) {
+	ctx, span := tracing.Start(ctx, "POST /updates/", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("content_encoding", coding)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+			if response.StatusCode >= http.StatusBadRequest {
+				span.SetStatus(codes.Error, response.Status)
+			}
+		}
+		tracing.End(span, err)
+	}()
 
-		time.Sleep(t.pollInterval)
+	body, err := compress(coding, data)
+	if err != nil {
+		return nil, err
 	}
+	t.telemetry.bytesUncompressed.Add(int64(len(data)))
+	t.telemetry.bytesCompressed.Add(int64(len(body)))
 
-}
-
-func (t *Agent) readAdditionalMetrics(additMetrics chan contracts.Metrics) error {
-	for {
-		v, _ := mem.VirtualMemory()
-		totalmemory := float64(v.Total)
-		additMetrics <- contracts.Metrics{ID: "TotalMemory", Value: &totalmemory, MType: consts.Gauge}
-
-		freemomry := float64(v.Free)
-		additMetrics <- contracts.Metrics{ID: "FreeMemory", Value: &freemomry, MType: consts.Gauge}
-
-		utilization, err := cpu.Percent(t.pollInterval, true)
+	req, err := http.NewRequestWithContext(ctx, "POST", d.url+"/updates/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(d.key) != 0 {
+		hashStr, err := hash.Hash(data, d.key)
 		if err != nil {
-			for i := 0; i < len(utilization); i++ {
-				cpuutil := utilization[i]
-				additMetrics <- contracts.Metrics{ID: "CPUutilization" + strconv.FormatInt(int64(i), 10), Value: &cpuutil, MType: consts.Gauge}
-			}
+			return nil, err
 		}
-
-		time.Sleep(t.pollInterval)
+		req.Header.Set(hash.HashHeaderKey, hashStr)
 	}
+	if coding != identity {
+		req.Header.Set("Content-Encoding", coding)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Accept-Encoding", "gzip")
+	if len(t.agentID) != 0 {
+		req.Header.Set(contracts.AgentIDHeader, t.agentID)
+	}
+	tracing.Inject(ctx, req.Header)
 
-}
-
-func (t *Agent) refreshMetrics() {
-	var stats runtime.MemStats
-	runtime.ReadMemStats(&stats)
-	t.mutex.Lock()
-	t.gaugeMetrics["Alloc"] = float64(stats.Alloc)
-	t.gaugeMetrics["BuckHashSys"] = float64(stats.BuckHashSys)
-	t.gaugeMetrics["Frees"] = float64(stats.Frees)
-	t.gaugeMetrics["GCCPUFraction"] = float64(stats.GCCPUFraction)
-	t.gaugeMetrics["GCSys"] = float64(stats.GCSys)
-	t.gaugeMetrics["HeapAlloc"] = float64(stats.HeapAlloc)
-	t.gaugeMetrics["HeapIdle"] = float64(stats.HeapIdle)
-	t.gaugeMetrics["HeapInuse"] = float64(stats.HeapInuse)
-	t.gaugeMetrics["HeapObjects"] = float64(stats.HeapObjects)
-	t.gaugeMetrics["HeapReleased"] = float64(stats.HeapReleased)
-	t.gaugeMetrics["HeapSys"] = float64(stats.HeapSys)
-	t.gaugeMetrics["LastGC"] = float64(stats.LastGC)
-	t.gaugeMetrics["Lookups"] = float64(stats.Lookups)
-	t.gaugeMetrics["MCacheInuse"] = float64(stats.MCacheInuse)
-	t.gaugeMetrics["MCacheSys"] = float64(stats.MCacheSys)
-	t.gaugeMetrics["MSpanInuse"] = float64(stats.MSpanInuse)
-	t.gaugeMetrics["MSpanSys"] = float64(stats.MSpanSys)
-	t.gaugeMetrics["Mallocs"] = float64(stats.Mallocs)
-	t.gaugeMetrics["NextGC"] = float64(stats.NextGC)
-	t.gaugeMetrics["NumForcedGC"] = float64(stats.NumForcedGC)
-	t.gaugeMetrics["NumGC"] = float64(stats.NumGC)
-	t.gaugeMetrics["OtherSys"] = float64(stats.OtherSys)
-	t.gaugeMetrics["PauseTotalNs"] = float64(stats.PauseTotalNs)
-	t.gaugeMetrics["StackInuse"] = float64(stats.StackInuse)
-	t.gaugeMetrics["StackSys"] = float64(stats.StackSys)
-	t.gaugeMetrics["Sys"] = float64(stats.Sys)
-	t.gaugeMetrics["TotalAlloc"] = float64(stats.TotalAlloc)
-	t.gaugeMetrics["RandomValue"] = rand.Float64()
-	t.counterMetrics["PollCount"] += 1
-	t.mutex.Unlock()
+	response, err = d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	return response, nil
 }
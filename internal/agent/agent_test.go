@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentReload(t *testing.T) {
+	a := New("localhost:8080", 2*time.Second, 10*time.Second, context.Background(), "", 2, "")
+	metrics, err := runtimeCollector{}.Collect(context.Background())
+	require.NoError(t, err)
+	a.aggregator.add(metrics)
+
+	err = a.Reload(AgentConfig{
+		Address:        "localhost:9090",
+		PollInterval:   configloader.Seconds(1),
+		ReportInterval: configloader.Seconds(5),
+		Key:            "new-key",
+		RateLimit:      4,
+	})
+	require.NoError(t, err)
+
+	s := a.currentSettings()
+	assert.Equal(t, "http://localhost:9090", s.destinations[0].url)
+	assert.Equal(t, time.Second, s.pollInterval)
+	assert.Equal(t, 5*time.Second, s.reportInterval)
+	assert.Equal(t, "new-key", s.destinations[0].key)
+	assert.Equal(t, 4, s.rateLimit)
+	assert.Equal(t, int64(1), a.aggregator.counters["PollCount"], "collected metrics must survive reload")
+}
+
+func TestAgentReloadRateLimit(t *testing.T) {
+	a := New("localhost:8080", 2*time.Second, 10*time.Second, context.Background(), "key", 2, "")
+
+	// Без ограничения отправка идет по одному запросу к получателю, перезапуск не нужен.
+	require.NoError(t, a.Reload(AgentConfig{Address: "localhost:8080", PollInterval: configloader.Seconds(1), ReportInterval: configloader.Seconds(1)}))
+	assert.Equal(t, 0, a.currentSettings().rateLimit)
+
+	require.NoError(t, a.Reload(AgentConfig{Address: "localhost:8080", PollInterval: configloader.Seconds(1), ReportInterval: configloader.Seconds(1), RateLimit: 3}))
+	assert.Equal(t, 3, a.currentSettings().rateLimit)
+}
+
+func TestAgentReloadRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AgentConfig
+	}{
+		{name: "empty address", cfg: AgentConfig{PollInterval: configloader.Seconds(1), ReportInterval: configloader.Seconds(1), RateLimit: 2}},
+		{name: "zero poll interval", cfg: AgentConfig{Address: "localhost:8080", ReportInterval: configloader.Seconds(1), RateLimit: 2}},
+		{name: "invalid log level", cfg: AgentConfig{Address: "localhost:9090", PollInterval: configloader.Seconds(1), ReportInterval: configloader.Seconds(1), Key: "new-key", LogLevel: "loud"}},
+		{name: "missing crypto key", cfg: AgentConfig{Address: "localhost:8080", PollInterval: configloader.Seconds(1), ReportInterval: configloader.Seconds(1), RateLimit: 2, CryptoKey: "/not/exists.pem"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := New("localhost:8080", 2*time.Second, 10*time.Second, context.Background(), "key", 2, "")
+
+			require.Error(t, a.Reload(test.cfg))
+
+			s := a.currentSettings()
+			assert.Equal(t, "http://localhost:8080", s.destinations[0].url)
+			assert.Equal(t, "key", s.destinations[0].key)
+			assert.Equal(t, 2*time.Second, s.pollInterval)
+		})
+	}
+}
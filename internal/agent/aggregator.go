@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
+// Функции агрегации gauge за интервал отправки.
+const (
+	AggregateLast = "last"
+	AggregateMin  = "min"
+	AggregateMax  = "max"
+	AggregateAvg  = "avg"
+)
+
+// gaugeStats - значения gauge, собранные за интервал отправки.
+type gaugeStats struct {
+	last  float64
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+// aggregator объединяет метрики от сборщиков и приложений между отправками:
+// приращения counter суммируются, по gauge считаются last, min, max и avg.
+// Так на сервер за интервал уходит одно значение каждой метрики.
+type aggregator struct {
+	mutex    *sync.Mutex
+	gauges   map[string]*gaugeStats
+	counters map[string]int64
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		mutex:    &sync.Mutex{},
+		gauges:   make(map[string]*gaugeStats),
+		counters: make(map[string]int64),
+	}
+}
+
+// add добавляет метрики в текущий интервал.
+func (a *aggregator) add(metrics []contracts.Metrics) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, metric := range metrics {
+		switch metric.MType {
+		case consts.Gauge:
+			if metric.Value == nil {
+				continue
+			}
+			v := *metric.Value
+			st, ok := a.gauges[metric.ID]
+			if !ok {
+				a.gauges[metric.ID] = &gaugeStats{last: v, min: v, max: v, sum: v, count: 1}
+				continue
+			}
+			st.last = v
+			st.min = math.Min(st.min, v)
+			st.max = math.Max(st.max, v)
+			st.sum += v
+			st.count++
+		case consts.Counter:
+			if metric.Delta != nil {
+				a.counters[metric.ID] += *metric.Delta
+			}
+		}
+	}
+}
+
+// size возвращает число метрик, ожидающих отправки.
+func (a *aggregator) size() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return len(a.gauges) + len(a.counters)
+}
+
+// flush возвращает итоги интервала и начинает новый интервал. Для каждой функции
+// агрегации gauge, кроме last, к имени метрики добавляется суффикс _<функция>.
+func (a *aggregator) flush(funcs []string) []contracts.Metrics {
+	a.mutex.Lock()
+	gauges, counters := a.gauges, a.counters
+	a.gauges = make(map[string]*gaugeStats)
+	a.counters = make(map[string]int64)
+	a.mutex.Unlock()
+
+	metrics := make([]contracts.Metrics, 0, len(gauges)*len(funcs)+len(counters))
+	for id, st := range gauges {
+		for _, fn := range funcs {
+			var metric contracts.Metrics
+			switch fn {
+			case AggregateLast:
+				metric = gauge(id, st.last)
+			case AggregateMin:
+				metric = gauge(suffixedID(id, "_min"), st.min)
+			case AggregateMax:
+				metric = gauge(suffixedID(id, "_max"), st.max)
+			case AggregateAvg:
+				metric = gauge(suffixedID(id, "_avg"), st.sum/float64(st.count))
+			default:
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	for id, delta := range counters {
+		if delta == 0 {
+			continue
+		}
+		metrics = append(metrics, contracts.Metrics{ID: id, MType: consts.Counter, Delta: &delta})
+	}
+
+	return metrics
+}
+
+// suffixedID добавляет суффикс к имени метрики перед метками.
+func suffixedID(id, suffix string) string {
+	if i := strings.IndexByte(id, '{'); i >= 0 {
+		return id[:i] + suffix + id[i:]
+	}
+	return id + suffix
+}
+
+// batches делит метрики на пачки не больше size.
+func batches(metrics []contracts.Metrics, size int) [][]contracts.Metrics {
+	var result [][]contracts.Metrics
+	for len(metrics) > size {
+		result = append(result, metrics[:size:size])
+		metrics = metrics[size:]
+	}
+	if len(metrics) != 0 {
+		result = append(result, metrics)
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/middlewares"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func counter(id string, delta int64) contracts.Metrics {
+	return contracts.Metrics{ID: id, MType: "counter", Delta: &delta}
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	a := newAggregator()
+	a.add([]contracts.Metrics{gauge(`Temp{room="a"}`, 3), counter("Jobs", 2)})
+	a.add([]contracts.Metrics{gauge(`Temp{room="a"}`, 1), counter("Jobs", 3)})
+	a.add([]contracts.Metrics{gauge(`Temp{room="a"}`, 5), counter("Idle", 0)})
+
+	byID := metricsByID(a.flush([]string{AggregateLast, AggregateMin, AggregateMax, AggregateAvg}))
+	assert.Len(t, byID, 5)
+	assert.Equal(t, 5.0, *byID[`Temp{room="a"}`].Value)
+	assert.Equal(t, 1.0, *byID[`Temp_min{room="a"}`].Value)
+	assert.Equal(t, 5.0, *byID[`Temp_max{room="a"}`].Value)
+	assert.Equal(t, 3.0, *byID[`Temp_avg{room="a"}`].Value)
+	assert.Equal(t, int64(5), *byID["Jobs"].Delta)
+
+	assert.Empty(t, a.flush([]string{AggregateLast}), "flush starts a new interval")
+}
+
+func TestBatches(t *testing.T) {
+	metrics := make([]contracts.Metrics, 5)
+	assert.Len(t, batches(metrics, 2), 3)
+	assert.Len(t, batches(metrics, 5), 1)
+	assert.Empty(t, batches(nil, 5))
+}
+
+func TestReportSendsBatches(t *testing.T) {
+	var mutex sync.Mutex
+	var sizes []int
+	fail := true
+	srv := httptest.NewServer(middlewares.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []contracts.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		mutex.Lock()
+		defer mutex.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sizes = append(sizes, len(batch))
+	})))
+	defer srv.Close()
+
+	a, err := NewWithConfig(context.Background(), AgentConfig{
+		Address:        strings.TrimPrefix(srv.URL, "http://"),
+		PollInterval:   configloader.Seconds(1),
+		ReportInterval: configloader.Seconds(1),
+		RateLimit:      2,
+		BatchSize:      2,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		a.aggregator.add([]contracts.Metrics{counter("Jobs", 1), gauge("Temp", float64(i)), gauge("Load", 1)})
+	}
+
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 3, a.pending(), "failed batches stay in the outbox")
+
+	mutex.Lock()
+	fail = false
+	mutex.Unlock()
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	drainAll(a)
+	assert.ElementsMatch(t, []int{2, 1, 1}, sizes)
+	assert.Zero(t, a.pending())
+}
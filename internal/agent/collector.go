@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
+// Collector - источник метрик агента.
+type Collector interface {
+	// Name возвращает уникальное имя сборщика. Оно попадает в метки самодиагностики.
+	Name() string
+	// Collect возвращает текущие значения метрик. Сборщик должен уважать отмену ctx.
+	Collect(ctx context.Context) ([]contracts.Metrics, error)
+}
+
+// CollectorOptions - расписание запуска сборщика.
+type CollectorOptions struct {
+	// Interval - интервал сбора. Если не задан, используется интервал опроса агента.
+	Interval time.Duration
+	// Timeout - предельное время одного сбора. Если не задан, равен интервалу.
+	Timeout time.Duration
+}
+
+// CollectorConfig - настройки сборщика в файле конфигурации.
+type CollectorConfig struct {
+	Interval configloader.Duration `json:"interval"`
+	Timeout  configloader.Duration `json:"timeout"`
+}
+
+type registeredCollector struct {
+	collector Collector
+	options   CollectorOptions
+}
+
+// Registry - набор сборщиков агента.
+type Registry struct {
+	mutex      *sync.Mutex
+	collectors []registeredCollector
+}
+
+// NewRegistry создает пустой набор сборщиков.
+func NewRegistry() *Registry {
+	return &Registry{mutex: &sync.Mutex{}}
+}
+
+// Register добавляет сборщик. Имена сборщиков должны быть уникальными.
+func (r *Registry) Register(c Collector, options CollectorOptions) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, rc := range r.collectors {
+		if rc.collector.Name() == c.Name() {
+			return errors.New("collector already registered: " + c.Name())
+		}
+	}
+	r.collectors = append(r.collectors, registeredCollector{collector: c, options: options})
+
+	return nil
+}
+
+func (r *Registry) list() []registeredCollector {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]registeredCollector(nil), r.collectors...)
+}
+
+// Register добавляет сборщик к агенту. Вызывается до Run.
+func (t *Agent) Register(c Collector, options CollectorOptions) error {
+	return t.registry.Register(c, options)
+}
+
+// runCollectors запускает каждый сборщик по своему расписанию и передает
+// результаты в sink, пока не отменен ctx.
+func (t *Agent) runCollectors(ctx context.Context, sink func([]contracts.Metrics)) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for _, rc := range t.registry.list() {
+		wg.Add(1)
+		go func(rc registeredCollector) {
+			defer wg.Done()
+			for {
+				sink(t.collect(ctx, rc))
+
+				interval := rc.options.Interval
+				if interval == 0 {
+					interval = t.currentSettings().pollInterval
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+		}(rc)
+	}
+	return &wg
+}
+
+// collect выполняет один сбор с таймаутом и добавляет к результату метрики
+// самодиагностики: CollectorUp (1 - успешно, 0 - ошибка) и счетчик CollectorErrors.
+// Ошибки сбора также пишутся в лог.
+func (t *Agent) collect(ctx context.Context, rc registeredCollector) []contracts.Metrics {
+	timeout := rc.options.Timeout
+	if timeout == 0 {
+		timeout = rc.options.Interval
+	}
+	if timeout == 0 {
+		timeout = t.currentSettings().pollInterval
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		metrics []contracts.Metrics
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		metrics, err := rc.collector.Collect(cctx)
+		done <- result{metrics: metrics, err: err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-cctx.Done():
+		res.err = cctx.Err()
+	}
+
+	name := rc.collector.Name()
+	up := 1.0
+	if res.err != nil {
+		logger.Logger.Errorf("Collector %s failed: %v", name, res.err)
+		up = 0
+		errorsCount := int64(1)
+		res.metrics = append(res.metrics, contracts.Metrics{
+			ID:    contracts.LabelledID("CollectorErrors", "collector", name),
+			Delta: &errorsCount,
+			MType: consts.Counter,
+		})
+	}
+	res.metrics = append(res.metrics, gauge(contracts.LabelledID("CollectorUp", "collector", name), up))
+
+	return res.metrics
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubCollector struct {
+	name    string
+	metrics []contracts.Metrics
+	err     error
+	delay   time.Duration
+}
+
+func (c stubCollector) Name() string {
+	return c.name
+}
+
+func (c stubCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	select {
+	case <-time.After(c.delay):
+		return c.metrics, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register(stubCollector{name: "a"}, CollectorOptions{}))
+	require.Error(t, r.Register(stubCollector{name: "a"}, CollectorOptions{}))
+	require.NoError(t, r.Register(stubCollector{name: "b"}, CollectorOptions{}))
+	assert.Len(t, r.list(), 2)
+}
+
+func TestBuiltinCollectorsRegistered(t *testing.T) {
+	a := New("localhost:8080", time.Second, time.Second, context.Background(), "", 0, "")
+
+	names := make([]string, 0)
+	for _, rc := range a.registry.list() {
+		names = append(names, rc.collector.Name())
+	}
+	assert.Equal(t, []string{"runtime", "memory", "cpu", "procfs"}, names)
+}
+
+func TestRuntimeCollectorReportsAllMemStats(t *testing.T) {
+	metrics, err := runtimeCollector{}.Collect(context.Background())
+	require.NoError(t, err)
+
+	byID := metricsByID(metrics)
+	for _, id := range []string{"Alloc", "BuckHashSys", "NumGC", "TotalAlloc", "RandomValue"} {
+		assert.Contains(t, byID, id)
+	}
+	assert.Equal(t, int64(1), *byID["PollCount"].Delta)
+}
+
+func TestCollectReportsFailures(t *testing.T) {
+	a := New("localhost:8080", time.Second, time.Second, context.Background(), "", 0, "")
+
+	tests := []struct {
+		name      string
+		collector stubCollector
+		options   CollectorOptions
+		up        float64
+	}{
+		{
+			name:      "success",
+			collector: stubCollector{name: "ok", metrics: []contracts.Metrics{gauge("Value", 1)}},
+			up:        1,
+		},
+		{
+			name:      "error",
+			collector: stubCollector{name: "broken", err: errors.New("boom")},
+			up:        0,
+		},
+		{
+			name:      "timeout",
+			collector: stubCollector{name: "slow", delay: time.Second},
+			options:   CollectorOptions{Timeout: 10 * time.Millisecond},
+			up:        0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := a.collect(context.Background(), registeredCollector{collector: test.collector, options: test.options})
+			byID := metricsByID(metrics)
+
+			up := byID[contracts.LabelledID("CollectorUp", "collector", test.collector.name)]
+			require.NotNil(t, up.Value)
+			assert.Equal(t, test.up, *up.Value)
+
+			errorsMetric, failed := byID[contracts.LabelledID("CollectorErrors", "collector", test.collector.name)]
+			assert.Equal(t, test.up == 0, failed)
+			if failed {
+				assert.Equal(t, int64(1), *errorsMetric.Delta)
+			}
+		})
+	}
+}
+
+func TestRunCollectorsUsesPerCollectorInterval(t *testing.T) {
+	a := New("localhost:8080", time.Hour, time.Hour, context.Background(), "", 0, "")
+	a.registry = NewRegistry()
+	require.NoError(t, a.Register(
+		stubCollector{name: "fast", metrics: []contracts.Metrics{gauge("Value", 1)}},
+		CollectorOptions{Interval: 10 * time.Millisecond},
+	))
+
+	var mutex sync.Mutex
+	calls := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.runCollectors(ctx, func(metrics []contracts.Metrics) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+	}).Wait()
+
+	assert.Greater(t, calls, 3)
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"strconv"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+// runtimeCollector собирает статистику памяти рантайма Go, случайное значение
+// и счетчик опросов PollCount.
+type runtimeCollector struct{}
+
+func (runtimeCollector) Name() string {
+	return "runtime"
+}
+
+func (runtimeCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	pollCount := int64(1)
+	return []contracts.Metrics{
+		gauge("Alloc", float64(stats.Alloc)),
+		gauge("BuckHashSys", float64(stats.BuckHashSys)),
+		gauge("Frees", float64(stats.Frees)),
+		gauge("GCCPUFraction", stats.GCCPUFraction),
+		gauge("GCSys", float64(stats.GCSys)),
+		gauge("HeapAlloc", float64(stats.HeapAlloc)),
+		gauge("HeapIdle", float64(stats.HeapIdle)),
+		gauge("HeapInuse", float64(stats.HeapInuse)),
+		gauge("HeapObjects", float64(stats.HeapObjects)),
+		gauge("HeapReleased", float64(stats.HeapReleased)),
+		gauge("HeapSys", float64(stats.HeapSys)),
+		gauge("LastGC", float64(stats.LastGC)),
+		gauge("Lookups", float64(stats.Lookups)),
+		gauge("MCacheInuse", float64(stats.MCacheInuse)),
+		gauge("MCacheSys", float64(stats.MCacheSys)),
+		gauge("MSpanInuse", float64(stats.MSpanInuse)),
+		gauge("MSpanSys", float64(stats.MSpanSys)),
+		gauge("Mallocs", float64(stats.Mallocs)),
+		gauge("NextGC", float64(stats.NextGC)),
+		gauge("NumForcedGC", float64(stats.NumForcedGC)),
+		gauge("NumGC", float64(stats.NumGC)),
+		gauge("OtherSys", float64(stats.OtherSys)),
+		gauge("PauseTotalNs", float64(stats.PauseTotalNs)),
+		gauge("StackInuse", float64(stats.StackInuse)),
+		gauge("StackSys", float64(stats.StackSys)),
+		gauge("Sys", float64(stats.Sys)),
+		gauge("TotalAlloc", float64(stats.TotalAlloc)),
+		gauge("RandomValue", rand.Float64()),
+		{ID: "PollCount", Delta: &pollCount, MType: consts.Counter},
+	}, nil
+}
+
+// memoryCollector собирает общий и свободный объем памяти системы.
+type memoryCollector struct{}
+
+func (memoryCollector) Name() string {
+	return "memory"
+}
+
+func (memoryCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []contracts.Metrics{
+		gauge("TotalMemory", float64(v.Total)),
+		gauge("FreeMemory", float64(v.Free)),
+	}, nil
+}
+
+// cpuCollector собирает загрузку каждого ядра процессора с момента прошлого сбора.
+type cpuCollector struct{}
+
+func (cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (cpuCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	utilization, err := cpu.PercentWithContext(ctx, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]contracts.Metrics, 0, len(utilization))
+	for i, value := range utilization {
+		metrics = append(metrics, gauge("CPUutilization"+strconv.Itoa(i), value))
+	}
+
+	return metrics, nil
+}
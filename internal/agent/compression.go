@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Сжатие тел запросов к серверу.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	// CompressionNone - тело отправляется без сжатия.
+	CompressionNone = "none"
+	// CompressionAuto - кодировка выбирается по заголовку Accept-Encoding ответов сервера.
+	// До первого ответа используется gzip.
+	CompressionAuto = "auto"
+)
+
+// identity - кодировка несжатого тела в терминах HTTP.
+const identity = "identity"
+
+// DefaultCompressionThreshold - тела меньше этого размера в байтах не сжимаются.
+const DefaultCompressionThreshold = 1024
+
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+)
+
+// compress сжимает данные в указанной кодировке. Для identity данные возвращаются как есть.
+func compress(coding string, data []byte) ([]byte, error) {
+	switch coding {
+	case identity:
+		return data, nil
+	case CompressionGzip:
+		buf := bytes.NewBuffer(nil)
+		zb := gzip.NewWriter(buf)
+		if _, err := zb.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zb.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		// EncodeAll можно вызывать из нескольких горутин, поэтому кодировщик общий.
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		})
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", coding)
+	}
+}
+
+// preferredCoding выбирает лучшую кодировку из заголовка Accept-Encoding сервера.
+// Если сервер не поддерживает ни одну из известных агенту, возвращает identity.
+func preferredCoding(header http.Header) string {
+	accepted := make(map[string]bool)
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding, _, _ = strings.Cut(coding, ";")
+			accepted[strings.ToLower(strings.TrimSpace(coding))] = true
+		}
+	}
+	for _, coding := range []string{CompressionZstd, CompressionGzip} {
+		if accepted[coding] {
+			return coding
+		}
+	}
+	return identity
+}
+
+// requestCoding возвращает кодировку тела запроса к получателю. Кодировка,
+// выбранная после ответа сервера, важнее настроенной.
+func (t *Agent) requestCoding(s settings, d destination, size int) string {
+	if size < s.compressionThreshold || s.compression == CompressionNone {
+		return identity
+	}
+	if coding, ok := t.codings.Load(d.name); ok {
+		return coding.(string)
+	}
+	if s.compression == CompressionAuto {
+		return CompressionGzip
+	}
+	return s.compression
+}
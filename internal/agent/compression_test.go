@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/instance"
+	"github.com/evildead81/metrics-and-alerts/internal/server/middlewares"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	memstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/mem-storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair сохраняет ключи RSA: приватный для сервера и публичный для агента.
+func writeKeyPair(t *testing.T) (privatePath, publicPath string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privatePath = filepath.Join(dir, "private.pem")
+	publicPath = filepath.Join(dir, "public.pem")
+	private := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+	public := &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(private), 0600))
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(public), 0600))
+	return privatePath, publicPath
+}
+
+// encodingRecorder запоминает заголовки Content-Encoding запросов к обработчику.
+type encodingRecorder struct {
+	mutex     *sync.Mutex
+	encodings []string
+}
+
+func (e *encodingRecorder) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mutex.Lock()
+		e.encodings = append(e.encodings, r.Header.Get("Content-Encoding"))
+		e.mutex.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (e *encodingRecorder) list() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]string(nil), e.encodings...)
+}
+
+func newCompressionAgent(t *testing.T, address, compression string, threshold int) *Agent {
+	t.Helper()
+	a, err := NewWithConfig(context.Background(), AgentConfig{
+		Address:              address,
+		PollInterval:         configloader.Seconds(1),
+		ReportInterval:       configloader.Seconds(1),
+		Compression:          compression,
+		CompressionThreshold: threshold,
+	})
+	require.NoError(t, err)
+	return a
+}
+
+func TestCompressedBatchesReachServer(t *testing.T) {
+	const key = "test-key"
+	privatePath, publicPath := writeKeyPair(t)
+
+	tests := []struct {
+		compression string
+		want        []string
+	}{
+		{compression: CompressionGzip, want: []string{"gzip", "gzip"}},
+		{compression: CompressionZstd, want: []string{"zstd", "zstd"}},
+		{compression: CompressionNone, want: []string{"", ""}},
+		{compression: CompressionAuto, want: []string{"gzip", "zstd"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.compression, func(t *testing.T) {
+			var storage storages.Storage = memstorage.New("", false)
+			recorder := &encodingRecorder{mutex: &sync.Mutex{}}
+			srv := httptest.NewServer(recorder.wrap(instance.New(":0", &storage, time.Second, key, true, privatePath)))
+			defer srv.Close()
+
+			a, err := NewWithConfig(context.Background(), AgentConfig{
+				PollInterval:   configloader.Seconds(1),
+				ReportInterval: configloader.Seconds(1),
+				Destinations: []DestinationConfig{{
+					Address:   strings.TrimPrefix(srv.URL, "http://"),
+					Key:       key,
+					CryptoKey: publicPath,
+				}},
+				Compression: test.compression,
+			})
+			require.NoError(t, err)
+
+			// Шифрование RSA ограничивает размер пачки, поэтому метрики уходят по одной.
+			for i := 0; i < 2; i++ {
+				a.collected([]contracts.Metrics{counter("Jobs", 5)})
+				a.report()
+				drainAll(a)
+			}
+
+			assert.Zero(t, a.pending(), "server accepted every batch")
+			assert.Equal(t, test.want, recorder.list())
+			got, err := storage.GetCountValueByName("Jobs")
+			require.NoError(t, err)
+			assert.Equal(t, int64(10), got)
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	recorder := &encodingRecorder{mutex: &sync.Mutex{}}
+	srv := newWrappedFakeServer(t, recorder.wrap)
+
+	a := newCompressionAgent(t, srv.address(), CompressionGzip, 4096)
+	a.collected([]contracts.Metrics{counter("Small", 1)})
+	a.report()
+	drainAll(a)
+
+	batch := make([]contracts.Metrics, 0, 100)
+	for i := 0; i < 100; i++ {
+		batch = append(batch, counter(contracts.LabelledID("Large", "n", strings.Repeat("x", i)), 1))
+	}
+	a.collected(batch)
+	a.report()
+	drainAll(a)
+
+	assert.Equal(t, []string{"", "gzip"}, recorder.list())
+	assert.Equal(t, 101, srv.count())
+	assert.Less(t, a.telemetry.bytesCompressed.Load(), a.telemetry.bytesUncompressed.Load())
+}
+
+func TestUnsupportedCodingFallsBack(t *testing.T) {
+	recorder := &encodingRecorder{mutex: &sync.Mutex{}}
+	// Сервер старой версии понимает только gzip.
+	srv := newWrappedFakeServer(t, func(decoder http.Handler) http.Handler {
+		return recorder.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Encoding") == CompressionZstd {
+				w.Header().Set("Accept-Encoding", "gzip")
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			decoder.ServeHTTP(w, r)
+		}))
+	})
+
+	a := newCompressionAgent(t, srv.address(), CompressionZstd, 0)
+	for i := 0; i < 2; i++ {
+		a.collected([]contracts.Metrics{counter("Jobs", 1)})
+		a.report()
+		drainAll(a)
+	}
+
+	assert.Equal(t, []string{"zstd", "gzip", "gzip"}, recorder.list())
+	assert.Equal(t, 2, srv.count())
+	assert.Zero(t, a.pending())
+}
+
+func TestPreferredCoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: middlewares.RequestEncodings, want: CompressionZstd},
+		{header: "gzip;q=1.0, br", want: CompressionGzip},
+		{header: "br", want: identity},
+		{header: "", want: identity},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if len(test.header) != 0 {
+			header.Set("Accept-Encoding", test.header)
+		}
+		assert.Equal(t, test.want, preferredCoding(header), test.header)
+	}
+}
@@ -1,18 +1,265 @@
 package agent
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/agent/procfs"
+	"github.com/evildead81/metrics-and-alerts/internal/agent/textformat"
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+)
+
 // AgentConfig - конфигурация агента
 type AgentConfig struct {
 	// Address - адрес сервера, куда отправляются метрики.
-	Address string `env:"ADDRESS" json:"address"`
-	// ReportInterval - интервал сбора метрик.
-	ReportInterval int64 `env:"REPORT_INTERVAL" json:"report_interval"`
-	// PollInterval - интервал отправки метрик.
-	PollInterval int64 `env:"POLL_INTERVAL" json:"poll_interval"`
+	Address string `env:"ADDRESS" json:"address" flag:"a" usage:"Server endpoint"`
+	// ReportInterval - интервал отправки метрик.
+	ReportInterval configloader.Duration `env:"REPORT_INTERVAL" json:"report_interval" flag:"r" usage:"Report interval (seconds or duration like 10s)"`
+	// PollInterval - интервал сбора метрик.
+	PollInterval configloader.Duration `env:"POLL_INTERVAL" json:"poll_interval" flag:"p" usage:"Poll interval (seconds or duration like 2s)"`
 	// Key - ключ шифрования отправляемых данных.
-	Key string `env:"KEY" json:"key"`
+	Key string `env:"KEY" json:"key" flag:"k" usage:"Secret key" secret:"true"`
 	// RateLimit максимальное количество запросов, параллельно отправляемых на сервер.
-	RateLimit int `env:"RATE_LIMIT" json:"rate_limit"`
+	RateLimit int `env:"RATE_LIMIT" json:"rate_limit" flag:"l" usage:"Parallels sends count"`
+	// BatchSize - максимальное число метрик в одном запросе к серверу.
+	BatchSize int `env:"BATCH_SIZE" json:"batch_size" flag:"batch-size" usage:"Max metrics per request"`
+	// GaugeAggregation - функции агрегации gauge за интервал отправки: last, min, max, avg.
+	// Для всех функций, кроме last, к имени метрики добавляется суффикс _<функция>.
+	GaugeAggregation []string `env:"GAUGE_AGGREGATION" json:"gauge_aggregation" flag:"gauge-aggregation" usage:"Comma separated gauge aggregations (last,min,max,avg)"`
 	// CryptoKey - путь до файла с публичным ключом
-	CryptoKey  string `env:"CRYPTO_KEY" json:"crypto_key"`
-	ConfigPath string `env:"CONFIG"`
+	CryptoKey  string `env:"CRYPTO_KEY" json:"crypto_key" flag:"crypto-key" usage:"Public key"`
+	ConfigPath string `env:"CONFIG" flag:"c" usage:"Config path (JSON, YAML or TOML)"`
+	// ProcfsRoot - точка монтирования procfs для системных сборщиков.
+	ProcfsRoot string `env:"PROCFS_ROOT" json:"procfs_root" flag:"procfs-root" usage:"procfs mount point"`
+	// CollectLoad - сбор средней загрузки системы.
+	CollectLoad bool `env:"COLLECT_LOAD" json:"collect_load" flag:"collect-load" usage:"Collect load average"`
+	// CollectNetwork - сбор счетчиков сетевых интерфейсов.
+	CollectNetwork bool `env:"COLLECT_NETWORK" json:"collect_network" flag:"collect-network" usage:"Collect network interface counters"`
+	// CollectDisk - сбор счетчиков ввода-вывода дисков.
+	CollectDisk bool `env:"COLLECT_DISK" json:"collect_disk" flag:"collect-disk" usage:"Collect disk IO counters"`
+	// CollectFilesystem - сбор занятости файловых систем по точкам монтирования.
+	CollectFilesystem bool `env:"COLLECT_FILESYSTEM" json:"collect_filesystem" flag:"collect-filesystem" usage:"Collect filesystem usage"`
+	// CollectFileDescriptors - сбор количества открытых дескрипторов файлов.
+	CollectFileDescriptors bool `env:"COLLECT_FILE_DESCRIPTORS" json:"collect_file_descriptors" flag:"collect-fd" usage:"Collect open file descriptors"`
+	// CollectContextSwitches - сбор количества переключений контекста.
+	CollectContextSwitches bool `env:"COLLECT_CONTEXT_SWITCHES" json:"collect_context_switches" flag:"collect-ctxt" usage:"Collect context switches"`
+	// Collectors - интервалы и таймауты сборщиков по именам (runtime, memory, cpu, procfs, agent, scrape:host:port).
+	// Задаются только в файле конфигурации.
+	Collectors map[string]CollectorConfig `json:"collectors"`
+	// ScrapeTargets - адреса приложений, метрики которых забираются в формате Prometheus
+	// с интервалом опроса: host:port или полный URL.
+	ScrapeTargets []string `env:"SCRAPE_TARGETS" json:"scrape_targets" flag:"scrape" usage:"Comma separated Prometheus targets (host:port or URL)"`
+	// SelfTelemetry - отправка метрик работы самого агента.
+	SelfTelemetry bool `env:"SELF_TELEMETRY" json:"self_telemetry" flag:"self-telemetry" usage:"Report agent's own metrics"`
+	// SelfTelemetryPrefix - префикс имен метрик самодиагностики.
+	SelfTelemetryPrefix string `env:"SELF_TELEMETRY_PREFIX" json:"self_telemetry_prefix" flag:"self-telemetry-prefix" usage:"Agent's own metrics prefix"`
+	// PushAddress - локальный адрес приема метрик от приложений, только loopback.
+	PushAddress string `env:"PUSH_ADDRESS" json:"push_address" flag:"push-address" usage:"Local push endpoint (loopback host:port)"`
+	// PushSocket - путь unix-сокета для приема метрик от приложений.
+	PushSocket string `env:"PUSH_SOCKET" json:"push_socket" flag:"push-socket" usage:"Local push endpoint unix socket"`
+	// Destinations - серверы, на которые отправляются метрики. Если список пуст,
+	// используется один сервер из Address, Key и CryptoKey. Задаются только в файле конфигурации.
+	Destinations []DestinationConfig `json:"destinations"`
+	// DestinationMode - fanout (всем получателям, по умолчанию) или failover (первому доступному).
+	DestinationMode string `env:"DESTINATION_MODE" json:"destination_mode" flag:"destination-mode" usage:"Destinations mode: fanout or failover"`
+	// OutboxPath - файл, куда при остановке сохраняются неотправленные метрики.
+	// При следующем запуске они отправляются первыми. Пустое значение отключает сохранение.
+	OutboxPath string `env:"OUTBOX_PATH" json:"outbox_path" flag:"outbox-path" usage:"Unsent metrics file, empty disables saving"`
+	// ShutdownTimeout - время на отправку оставшихся метрик при остановке.
+	ShutdownTimeout configloader.Duration `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout" flag:"shutdown-timeout" usage:"Final flush timeout (seconds or duration like 5s)"`
+	// Compression - сжатие тел запросов: gzip (по умолчанию), zstd, none или auto.
+	// В режиме auto кодировка выбирается по заголовку Accept-Encoding ответов сервера.
+	Compression string `env:"COMPRESSION" json:"compression" flag:"compression" usage:"Request compression: gzip, zstd, none or auto"`
+	// CompressionThreshold - минимальный размер тела запроса в байтах, с которого оно сжимается.
+	CompressionThreshold int `env:"COMPRESSION_THRESHOLD" json:"compression_threshold" flag:"compression-threshold" usage:"Min request body size to compress, bytes"`
+	// AgentID - идентификатор агента в логах сервера. По умолчанию имя хоста.
+	AgentID string `env:"AGENT_ID" json:"agent_id" flag:"agent-id" usage:"Agent ID sent to server (hostname if empty)"`
+	// LogLevel - уровень логирования.
+	LogLevel string `env:"LOG_LEVEL" json:"log_level" flag:"log-level" usage:"Log level"`
+	// LogFormat - формат логов: console или json.
+	LogFormat string `env:"LOG_FORMAT" json:"log_format" flag:"log-format" usage:"Log format: console or json"`
+	// LogFile - файл логов. Пустое значение означает stderr.
+	LogFile string `env:"LOG_FILE" json:"log_file" flag:"log-file" usage:"Log file (stderr if empty)"`
+	// LogMaxSize - размер файла логов в мегабайтах, после которого он ротируется.
+	LogMaxSize int `env:"LOG_MAX_SIZE" json:"log_max_size" flag:"log-max-size" usage:"Log file size to rotate at, megabytes (0 disables rotation)"`
+	// LogMaxBackups - число хранимых ротированных файлов логов.
+	LogMaxBackups int `env:"LOG_MAX_BACKUPS" json:"log_max_backups" flag:"log-max-backups" usage:"Rotated log files to keep (0 keeps all)"`
+	// LogSampling - число одинаковых записей в секунду, после которого логи сэмплируются.
+	LogSampling int `env:"LOG_SAMPLING" json:"log_sampling" flag:"log-sampling" usage:"Identical log entries per second before sampling (0 disables)"`
+	// TraceExporter - экспорт спанов трассировки: none, otlp или file.
+	TraceExporter string `env:"TRACE_EXPORTER" json:"trace_exporter" flag:"trace-exporter" usage:"Trace exporter: none, otlp or file"`
+	// TraceEndpoint - URL коллектора OTLP/HTTP.
+	TraceEndpoint string `env:"TRACE_ENDPOINT" json:"trace_endpoint" flag:"trace-endpoint" usage:"OTLP/HTTP collector URL"`
+	// TraceFile - файл спанов для экспортера file.
+	TraceFile string `env:"TRACE_FILE" json:"trace_file" flag:"trace-file" usage:"Trace file for the file exporter"`
+	// TraceSampleRatio - доля записываемых трасс от 0 до 1.
+	TraceSampleRatio float64 `env:"TRACE_SAMPLE_RATIO" json:"trace_sample_ratio" flag:"trace-sample-ratio" usage:"Sampled traces ratio (0..1)"`
+	// Exec - внешние команды, вывод которых разбирается как метрики.
+	// Задаются только в файле конфигурации.
+	Exec []ExecConfig `json:"exec"`
+}
+
+// ExecConfig - команда, выводящая метрики в stdout.
+type ExecConfig struct {
+	// Name - имя сборщика, уникальное среди всех сборщиков агента.
+	Name string `json:"name"`
+	// Command - исполняемый файл и его аргументы. Команда запускается без shell.
+	Command []string `json:"command"`
+	// Format - формат вывода: prometheus, influx, simple. По умолчанию определяется по выводу.
+	Format   string                `json:"format"`
+	Interval configloader.Duration `json:"interval"`
+	Timeout  configloader.Duration `json:"timeout"`
+}
+
+// DefaultBatchSize - размер пачки метрик по умолчанию.
+const DefaultBatchSize = 100
+
+// DefaultShutdownTimeout - время на отправку оставшихся метрик при остановке по умолчанию.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// DefaultConfig возвращает конфигурацию агента по умолчанию.
+func DefaultConfig() AgentConfig {
+	return AgentConfig{
+		Address:              "localhost:8080",
+		ReportInterval:       configloader.Seconds(10),
+		PollInterval:         configloader.Seconds(2),
+		BatchSize:            DefaultBatchSize,
+		DestinationMode:      DestinationFanout,
+		Compression:          CompressionGzip,
+		CompressionThreshold: DefaultCompressionThreshold,
+		ShutdownTimeout:      configloader.Duration{Duration: DefaultShutdownTimeout},
+		GaugeAggregation:     []string{AggregateLast},
+		ProcfsRoot:           procfs.DefaultRoot,
+		SelfTelemetryPrefix:  DefaultTelemetryPrefix,
+		LogLevel:             "info",
+		LogFormat:            logger.FormatConsole,
+		TraceExporter:        tracing.ExporterNone,
+		TraceSampleRatio:     1,
+	}
+}
+
+// Tracing возвращает параметры трассировки.
+func (c AgentConfig) Tracing() tracing.Config {
+	return tracing.Config{
+		ServiceName: "metrics-agent",
+		Exporter:    c.TraceExporter,
+		Endpoint:    c.TraceEndpoint,
+		File:        c.TraceFile,
+		SampleRatio: c.TraceSampleRatio,
+	}
+}
+
+// Logging возвращает параметры логгера агента.
+func (c AgentConfig) Logging() logger.Config {
+	return logger.Config{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		File:       c.LogFile,
+		MaxSize:    c.LogMaxSize,
+		MaxBackups: c.LogMaxBackups,
+		Sampling:   c.LogSampling,
+	}
+}
+
+// Validate проверяет, что конфигурация пригодна для запуска агента.
+func (c AgentConfig) Validate() error {
+	var errs []error
+	if len(c.Destinations) == 0 {
+		if len(c.Address) == 0 {
+			errs = append(errs, errors.New("address is empty"))
+		} else if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			errs = append(errs, errors.New("address must be in host:port form"))
+		}
+	}
+	seen := make(map[string]bool, len(c.Destinations))
+	for _, d := range c.Destinations {
+		if err := d.validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if seen[d.Address] {
+			errs = append(errs, fmt.Errorf("destination %q is listed twice", d.Address))
+		}
+		seen[d.Address] = true
+	}
+	switch c.DestinationMode {
+	case "", DestinationFanout, DestinationFailover:
+	default:
+		errs = append(errs, fmt.Errorf("unknown destination mode %q", c.DestinationMode))
+	}
+	if c.ReportInterval.Duration <= 0 {
+		errs = append(errs, errors.New("report interval must be positive"))
+	}
+	if c.PollInterval.Duration <= 0 {
+		errs = append(errs, errors.New("poll interval must be positive"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rate limit must not be negative"))
+	}
+	if c.ShutdownTimeout.Duration < 0 {
+		errs = append(errs, errors.New("shutdown timeout must not be negative"))
+	}
+	if c.BatchSize < 0 {
+		errs = append(errs, errors.New("batch size must not be negative"))
+	}
+	switch c.Compression {
+	case "", CompressionGzip, CompressionZstd, CompressionNone, CompressionAuto:
+	default:
+		errs = append(errs, fmt.Errorf("unknown compression %q", c.Compression))
+	}
+	if c.CompressionThreshold < 0 {
+		errs = append(errs, errors.New("compression threshold must not be negative"))
+	}
+	if err := c.Logging().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, fn := range c.GaugeAggregation {
+		switch fn {
+		case AggregateLast, AggregateMin, AggregateMax, AggregateAvg:
+		default:
+			errs = append(errs, fmt.Errorf("unknown gauge aggregation %q", fn))
+		}
+	}
+	if len(c.PushAddress) != 0 && !isLoopback(c.PushAddress) {
+		errs = append(errs, errors.New("push address must be a loopback host:port"))
+	}
+	for _, target := range c.ScrapeTargets {
+		if _, err := parseScrapeTarget(target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, e := range c.Exec {
+		if len(e.Name) == 0 {
+			errs = append(errs, fmt.Errorf("exec[%d]: name is empty", i))
+		}
+		if len(e.Command) == 0 {
+			errs = append(errs, fmt.Errorf("exec[%d]: command is empty", i))
+		}
+		if !textformat.ValidFormat(e.Format) {
+			errs = append(errs, fmt.Errorf("exec[%d]: unknown format %q", i, e.Format))
+		}
+		if e.Interval.Duration < 0 || e.Timeout.Duration < 0 {
+			errs = append(errs, fmt.Errorf("exec[%d]: interval and timeout must not be negative", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isLoopback проверяет, что адрес доступен только с этого хоста.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
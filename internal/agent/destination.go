@@ -0,0 +1,333 @@
+package agent
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+)
+
+// Режимы отправки при нескольких получателях.
+const (
+	// DestinationFanout - каждая пачка отправляется всем получателям.
+	DestinationFanout = "fanout"
+	// DestinationFailover - пачка отправляется первому получателю, при ошибке - следующему.
+	DestinationFailover = "failover"
+)
+
+// Транспорты получателя.
+const (
+	TransportHTTP  = "http"
+	TransportHTTPS = "https"
+	// TransportUnix - HTTP через unix-сокет, адрес - путь к сокету.
+	TransportUnix = "unix"
+)
+
+// maxOutboxBatches - предельное число пачек в очереди получателя.
+// При переполнении отбрасываются самые старые пачки.
+const maxOutboxBatches = 1000
+
+// DestinationConfig - сервер, на который агент отправляет метрики.
+type DestinationConfig struct {
+	// Address - host:port сервера или путь к сокету для транспорта unix.
+	Address string `json:"address"`
+	// Key - ключ подписи запросов.
+	Key string `json:"key"`
+	// CryptoKey - путь до файла с публичным ключом сервера.
+	CryptoKey string `json:"crypto_key"`
+	// Transport - http (по умолчанию), https или unix.
+	Transport string `json:"transport"`
+}
+
+// String скрывает ключ подписи при выводе конфигурации.
+func (c DestinationConfig) String() string {
+	key := ""
+	if len(c.Key) != 0 {
+		key = "******"
+	}
+	return fmt.Sprintf("{%s %s key=%s crypto_key=%s}", c.transport(), c.Address, key, c.CryptoKey)
+}
+
+func (c DestinationConfig) transport() string {
+	if len(c.Transport) == 0 {
+		return TransportHTTP
+	}
+	return c.Transport
+}
+
+// validate проверяет адрес и транспорт получателя.
+func (c DestinationConfig) validate() error {
+	switch c.transport() {
+	case TransportHTTP, TransportHTTPS:
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("destination %q: address must be in host:port form", c.Address)
+		}
+	case TransportUnix:
+		if len(c.Address) == 0 {
+			return errors.New("destination: unix socket path is empty")
+		}
+	default:
+		return fmt.Errorf("destination %q: unknown transport %q", c.Address, c.Transport)
+	}
+	return nil
+}
+
+// destination - параметры получателя, которые можно изменить без перезапуска.
+type destination struct {
+	// name - адрес из конфигурации, по нему находится очередь получателя.
+	name      string
+	url       string
+	key       string
+	publicKey *rsa.PublicKey
+	client    *http.Client
+}
+
+func newDestination(cfg DestinationConfig) (destination, error) {
+	d := destination{
+		name:   cfg.Address,
+		url:    cfg.transport() + "://" + cfg.Address,
+		key:    cfg.Key,
+		client: &http.Client{},
+	}
+	if cfg.transport() == TransportUnix {
+		socket := cfg.Address
+		d.url = "http://unix"
+		d.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+
+	if len(cfg.CryptoKey) != 0 {
+		publicKey, err := loadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			return d, err
+		}
+		d.publicKey = publicKey
+	}
+
+	return d, nil
+}
+
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	publicKeyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyBlock, _ := pem.Decode(publicKeyPEM)
+	if publicKeyBlock == nil {
+		return nil, errors.New("crypto key is not a PEM file: " + path)
+	}
+	return x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
+}
+
+// outbox - очередь пачек одного получателя. У каждого получателя своя очередь
+// и свой отправитель, поэтому медленный получатель не задерживает остальных.
+type outbox struct {
+	mutex   *sync.Mutex
+	batches [][]contracts.Metrics
+	// kick будит отправителя. Буфер на одно значение: повторные сигналы схлопываются.
+	kick chan struct{}
+	// dropped - сколько пачек отброшено из-за переполнения.
+	dropped func(n int)
+}
+
+func newOutbox(dropped func(n int)) *outbox {
+	return &outbox{
+		mutex:   &sync.Mutex{},
+		kick:    make(chan struct{}, 1),
+		dropped: dropped,
+	}
+}
+
+// push добавляет пачки в конец очереди и будит отправителя.
+func (o *outbox) push(batches ...[]contracts.Metrics) {
+	o.mutex.Lock()
+	o.batches = append(o.batches, batches...)
+	o.trim()
+	o.mutex.Unlock()
+
+	select {
+	case o.kick <- struct{}{}:
+	default:
+	}
+}
+
+// retry возвращает пачки в начало очереди. Отправитель не будится:
+// пачки уйдут со следующим отчетом.
+func (o *outbox) retry(batches ...[]contracts.Metrics) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.batches = append(append([][]contracts.Metrics(nil), batches...), o.batches...)
+	o.trim()
+}
+
+func (o *outbox) trim() {
+	if extra := len(o.batches) - maxOutboxBatches; extra > 0 {
+		o.batches = o.batches[extra:]
+		o.dropped(extra)
+	}
+}
+
+// take забирает все пачки из очереди.
+func (o *outbox) take() [][]contracts.Metrics {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	batches := o.batches
+	o.batches = nil
+	return batches
+}
+
+// size возвращает число метрик в очереди.
+func (o *outbox) size() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	n := 0
+	for _, batch := range o.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+// outbox возвращает очередь получателя, создавая ее при первом обращении.
+// Если агент уже запущен, для новой очереди сразу запускается отправитель.
+func (t *Agent) outbox(name string) *outbox {
+	t.outboxesMutex.Lock()
+	defer t.outboxesMutex.Unlock()
+
+	ob, ok := t.outboxes[name]
+	if !ok {
+		ob = newOutbox(func(n int) {
+			t.telemetry.batchesDropped.Add(int64(n))
+			logger.Logger.Warnf("Destination %s outbox is full, dropped %d batches", name, n)
+		})
+		t.outboxes[name] = ob
+		if t.senders != nil {
+			t.startSender(name, ob)
+		}
+	}
+	return ob
+}
+
+// startSenders запускает отправителей всех очередей. Вызывается из Run.
+func (t *Agent) startSenders(ctx context.Context) *sync.WaitGroup {
+	t.outboxesMutex.Lock()
+	defer t.outboxesMutex.Unlock()
+
+	t.sendersCtx = ctx
+	t.senders = &sync.WaitGroup{}
+	for name, ob := range t.outboxes {
+		t.startSender(name, ob)
+	}
+	return t.senders
+}
+
+func (t *Agent) startSender(name string, ob *outbox) {
+	ctx := t.sendersCtx
+	t.senders.Add(1)
+	go func() {
+		defer t.senders.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ob.kick:
+				t.drain(ctx, name, ob)
+			}
+		}
+	}()
+}
+
+// drain отправляет все пачки из очереди получателя, не больше rateLimit одновременно.
+// Отмена ctx прерывает отправку, неотправленные пачки остаются в очереди.
+func (t *Agent) drain(ctx context.Context, name string, ob *outbox) {
+	s := t.currentSettings()
+	index := -1
+	for i, d := range s.destinations {
+		if d.name == name {
+			index = i
+		}
+	}
+	batches := ob.take()
+	if index < 0 {
+		if len(batches) != 0 {
+			logger.Logger.Warnf("Destination %s was removed from config, dropped %d batches", name, len(batches))
+			t.telemetry.batchesDropped.Add(int64(len(batches)))
+		}
+		return
+	}
+
+	limiter := make(chan struct{}, max(s.rateLimit, 1))
+	var sends sync.WaitGroup
+	for _, batch := range batches {
+		limiter <- struct{}{}
+		sends.Add(1)
+		go func(batch []contracts.Metrics) {
+			defer sends.Done()
+			defer func() { <-limiter }()
+			start := time.Now()
+			err := t.postWithRetry(ctx, s.destinations[index], batch)
+			t.telemetry.sent(err, time.Since(start))
+			if err != nil {
+				logger.Logger.Errorf("Failed to send %d metrics to %s: %v", len(batch), name, err)
+				t.failed(s, index, batch)
+			}
+		}(batch)
+	}
+	sends.Wait()
+}
+
+// failed решает судьбу неотправленной пачки. В режиме failover пачка переходит
+// к следующему получателю, а после последнего возвращается к первому.
+// В режиме fanout пачка остается в очереди своего получателя.
+func (t *Agent) failed(s settings, index int, batch []contracts.Metrics) {
+	if s.destinationMode != DestinationFailover {
+		t.outbox(s.destinations[index].name).retry(batch)
+		return
+	}
+	if index+1 < len(s.destinations) {
+		t.outbox(s.destinations[index+1].name).push(batch)
+		return
+	}
+	t.outbox(s.destinations[0].name).retry(batch)
+}
+
+// enqueue раздает пачки получателям согласно режиму отправки.
+func (t *Agent) enqueue(s settings, batches [][]contracts.Metrics) {
+	if len(batches) == 0 {
+		return
+	}
+	if s.destinationMode == DestinationFailover {
+		t.outbox(s.destinations[0].name).push(batches...)
+		return
+	}
+	for _, d := range s.destinations {
+		t.outbox(d.name).push(batches...)
+	}
+}
+
+// pending возвращает число метрик, ожидающих отправки во всех очередях.
+func (t *Agent) pending() int {
+	t.outboxesMutex.Lock()
+	defer t.outboxesMutex.Unlock()
+
+	n := 0
+	for _, ob := range t.outboxes {
+		n += ob.size()
+	}
+	return n
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/middlewares"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drainAll синхронно отправляет очереди всех получателей в порядке конфигурации.
+func drainAll(a *Agent) {
+	for _, d := range a.currentSettings().destinations {
+		a.drain(context.Background(), d.name, a.outbox(d.name))
+	}
+}
+
+// fakeServer считает принятые метрики и отвечает заданным статусом.
+// Тела запросов распаковываются так же, как на настоящем сервере.
+type fakeServer struct {
+	*httptest.Server
+	mutex    *sync.Mutex
+	status   int
+	received int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	return newWrappedFakeServer(t, func(h http.Handler) http.Handler { return h })
+}
+
+// newWrappedFakeServer позволяет подменить обработку запроса до распаковки тела.
+func newWrappedFakeServer(t *testing.T, wrap func(http.Handler) http.Handler) *fakeServer {
+	f := &fakeServer{mutex: &sync.Mutex{}, status: http.StatusOK}
+	f.Server = httptest.NewServer(wrap(middlewares.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []contracts.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		w.WriteHeader(f.status)
+		if f.status == http.StatusOK {
+			f.received += len(batch)
+		}
+	}))))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeServer) address() string {
+	return strings.TrimPrefix(f.URL, "http://")
+}
+
+func (f *fakeServer) setStatus(status int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.status = status
+}
+
+func (f *fakeServer) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.received
+}
+
+func newDestinationsAgent(t *testing.T, mode string, destinations ...DestinationConfig) *Agent {
+	t.Helper()
+	cfg := AgentConfig{
+		PollInterval:    configloader.Seconds(1),
+		ReportInterval:  configloader.Seconds(1),
+		Destinations:    destinations,
+		DestinationMode: mode,
+	}
+	require.NoError(t, cfg.Validate())
+	a, err := NewWithConfig(context.Background(), cfg)
+	require.NoError(t, err)
+	return a
+}
+
+func TestFanout(t *testing.T) {
+	primary, secondary := newFakeServer(t), newFakeServer(t)
+	a := newDestinationsAgent(t, DestinationFanout,
+		DestinationConfig{Address: primary.address()},
+		DestinationConfig{Address: secondary.address()},
+	)
+
+	secondary.setStatus(http.StatusInternalServerError)
+	a.collected([]contracts.Metrics{counter("Jobs", 1), gauge("Temp", 1)})
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 2, primary.count())
+	assert.Equal(t, 0, secondary.count())
+	assert.Zero(t, a.outbox(primary.address()).size())
+	assert.Equal(t, 2, a.outbox(secondary.address()).size(), "failed batch waits in its own outbox")
+
+	secondary.setStatus(http.StatusOK)
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 2, primary.count())
+	assert.Equal(t, 2, secondary.count())
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := newFakeServer(t), newFakeServer(t)
+	a := newDestinationsAgent(t, DestinationFailover,
+		DestinationConfig{Address: primary.address()},
+		DestinationConfig{Address: secondary.address()},
+	)
+
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 1, primary.count())
+	assert.Equal(t, 0, secondary.count())
+
+	primary.setStatus(http.StatusInternalServerError)
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 1, secondary.count(), "batch moves to the secondary")
+
+	secondary.setStatus(http.StatusInternalServerError)
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 1, a.outbox(primary.address()).size(), "batch returns to the primary")
+}
+
+func TestSlowDestinationDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newFakeServer(t)
+
+	a := newDestinationsAgent(t, DestinationFanout,
+		DestinationConfig{Address: strings.TrimPrefix(slow.URL, "http://")},
+		DestinationConfig{Address: fast.address()},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.startSenders(ctx)
+
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	assert.Eventually(t, func() bool { return fast.count() == 1 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestUnixTransport(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	received := make(chan int, 1)
+	srv := &http.Server{Handler: middlewares.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []contracts.Metrics
+		json.NewDecoder(r.Body).Decode(&batch)
+		received <- len(batch)
+	}))}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	a := newDestinationsAgent(t, "", DestinationConfig{Address: socket, Transport: TransportUnix})
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	drainAll(a)
+	assert.Equal(t, 1, <-received)
+}
+
+func TestDestinationsValidation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Address = ""
+	cfg.DestinationMode = "roundrobin"
+	cfg.Destinations = []DestinationConfig{
+		{Address: "localhost:1"},
+		{Address: "localhost:1"},
+		{Address: "localhost", Transport: TransportHTTPS},
+		{Address: "localhost:2", Transport: "grpc"},
+	}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "listed twice")
+	assert.Contains(t, err.Error(), "host:port")
+	assert.Contains(t, err.Error(), `unknown transport "grpc"`)
+	assert.Contains(t, err.Error(), `unknown destination mode "roundrobin"`)
+	assert.NotContains(t, err.Error(), "address is empty")
+
+	d := DestinationConfig{Address: "localhost:1", Key: "secret"}
+	assert.NotContains(t, d.String(), "secret")
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/agent/textformat"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
+// execWaitDelay - сколько ждать закрытия stdout после завершения команды. Без него
+// сборщик зависнет, если команда оставила дочерние процессы с унаследованным stdout.
+const execWaitDelay = time.Second
+
+// maxStderr - сколько байт stderr попадает в текст ошибки.
+const maxStderr = 512
+
+// execCollector запускает внешнюю команду и разбирает ее stdout как метрики.
+// По истечении таймаута команда принудительно завершается.
+type execCollector struct {
+	name    string
+	command []string
+	format  string
+	deltas  *deltaTracker
+}
+
+func newExecCollector(name string, command []string, format string) *execCollector {
+	return &execCollector{
+		name:    name,
+		command: command,
+		format:  format,
+		deltas:  newDeltaTracker(),
+	}
+}
+
+func (c *execCollector) Name() string {
+	return c.name
+}
+
+// Collect выполняет команду. Ненулевой код выхода считается ошибкой, даже если
+// команда успела что-то вывести.
+func (c *execCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = execWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: killed: %w", c.name, ctx.Err())
+		}
+		message := strings.TrimSpace(stderr.String())
+		if len(message) > maxStderr {
+			message = message[:maxStderr]
+		}
+		if len(message) != 0 {
+			return nil, fmt.Errorf("%s: %w: %s", c.name, err, message)
+		}
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+
+	samples, err := textformat.Parse(c.format, stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+
+	return c.deltas.metrics(samples), nil
+}
+
+// deltaTracker переводит накопленные итоги counter в приращения с прошлого сбора,
+// потому что сервер суммирует counter.
+type deltaTracker struct {
+	mutex *sync.Mutex
+	last  map[string]int64
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		mutex: &sync.Mutex{},
+		last:  make(map[string]int64),
+	}
+}
+
+// metrics преобразует значения в метрики. Первое значение накопленного counter
+// только запоминается. После сброса счетчика приращением считается новое значение.
+func (d *deltaTracker) metrics(samples []textformat.Sample) []contracts.Metrics {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	metrics := make([]contracts.Metrics, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Type == consts.Gauge {
+			metrics = append(metrics, gauge(sample.ID, sample.Value))
+			continue
+		}
+
+		delta := int64(sample.Value)
+		if sample.Cumulative {
+			prev, ok := d.last[sample.ID]
+			d.last[sample.ID] = delta
+			if !ok {
+				continue
+			}
+			if delta >= prev {
+				delta -= prev
+			}
+		}
+		metrics = append(metrics, contracts.Metrics{ID: sample.ID, MType: consts.Counter, Delta: &delta})
+	}
+
+	return metrics
+}
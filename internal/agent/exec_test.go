@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+	path := filepath.Join(t.TempDir(), "collect.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700))
+	return path
+}
+
+func TestExecCollector(t *testing.T) {
+	path := writeScript(t, `echo "# TYPE jobs_total counter"
+echo "jobs_total $(cat "$0.count" 2>/dev/null || echo 10)"
+echo "queue_depth 7"
+echo 15 > "$0.count"
+`)
+	c := newExecCollector("jobs", []string{path}, "")
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	byID := metricsByID(metrics)
+	assert.Equal(t, 7.0, *byID["queue_depth"].Value)
+	assert.NotContains(t, byID, "jobs_total", "first cumulative value is only remembered")
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	byID = metricsByID(metrics)
+	assert.Equal(t, int64(5), *byID["jobs_total"].Delta)
+}
+
+func TestExecCollectorFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		message string
+	}{
+		{name: "exit code", script: "echo 'broken pipe' >&2\nexit 3\n", message: "broken pipe"},
+		{name: "bad output", script: "echo 'A gauge many'\n", message: "line 1"},
+		{name: "hang", script: "sleep 10\n", message: "killed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newExecCollector("script", []string{writeScript(t, test.script)}, "simple")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := c.Collect(ctx)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.message)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
+
+func TestExecConfigValidation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Exec = []ExecConfig{{Name: "", Command: nil, Format: "xml"}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "name is empty")
+	assert.Contains(t, err.Error(), "command is empty")
+	assert.Contains(t, err.Error(), `unknown format "xml"`)
+
+	cfg.Exec = []ExecConfig{{Name: "runtime", Command: []string{"true"}}}
+	require.NoError(t, cfg.Validate())
+	_, err = NewWithConfig(context.Background(), cfg)
+	assert.Error(t, err, "exec collector name must not clash with builtin collectors")
+}
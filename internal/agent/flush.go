@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+)
+
+// finalFlush собирает метрики последний раз и отправляет все, что ждет отправки.
+// Вызывается после остановки сборщиков и отправителей. Пачки, которые не удалось
+// отправить до истечения ctx, сохраняются в файл очереди, если он задан.
+func (t *Agent) finalFlush(ctx context.Context) error {
+	var collects sync.WaitGroup
+	for _, rc := range t.registry.list() {
+		collects.Add(1)
+		go func(rc registeredCollector) {
+			defer collects.Done()
+			t.collected(t.collect(ctx, rc))
+		}(rc)
+	}
+	collects.Wait()
+	t.report()
+
+	// Получатели обходятся по порядку: в режиме failover пачки, не принятые
+	// первым получателем, попадают в очередь следующего.
+	for _, d := range t.currentSettings().destinations {
+		t.drain(ctx, d.name, t.outbox(d.name))
+	}
+
+	pending := t.pending()
+	if pending == 0 {
+		return nil
+	}
+	if len(t.outboxPath) == 0 {
+		logger.Logger.Warnf("Outbox file is not configured, %d unsent metrics are lost", pending)
+		return nil
+	}
+	if err := t.saveOutboxes(t.outboxPath); err != nil {
+		logger.Logger.Errorw("Failed to save unsent metrics, they are lost",
+			"path", t.outboxPath, "metrics", pending, "error", err.Error())
+		return fmt.Errorf("outbox %s: %w", t.outboxPath, err)
+	}
+	return nil
+}
+
+// saveOutboxes сохраняет очереди получателей в файл. Файл заменяется атомарно.
+func (t *Agent) saveOutboxes(path string) error {
+	t.outboxesMutex.Lock()
+	saved := make(map[string][][]contracts.Metrics, len(t.outboxes))
+	for name, ob := range t.outboxes {
+		if batches := ob.take(); len(batches) != 0 {
+			saved[name] = batches
+		}
+	}
+	t.outboxesMutex.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	logger.Logger.Infof("Saved unsent metrics of %d destinations to %s", len(saved), path)
+	return nil
+}
+
+// loadOutboxes восстанавливает очереди, сохраненные при прошлой остановке,
+// и удаляет файл. Пачки уйдут вместе с первым отчетом.
+func (t *Agent) loadOutboxes(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved map[string][][]contracts.Metrics
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("outbox %s: %w", path, err)
+	}
+	for name, batches := range saved {
+		t.outbox(name).retry(batches...)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFlushAgent(t *testing.T, ctx context.Context, address, outboxPath string) *Agent {
+	t.Helper()
+	a, err := NewWithConfig(ctx, AgentConfig{
+		Address:         address,
+		PollInterval:    configloader.Seconds(10),
+		ReportInterval:  configloader.Seconds(10),
+		RateLimit:       2,
+		OutboxPath:      outboxPath,
+		ShutdownTimeout: configloader.Duration{Duration: time.Second},
+	})
+	require.NoError(t, err)
+	a.registry = NewRegistry()
+	require.NoError(t, a.Register(runtimeCollector{}, CollectorOptions{}))
+	return a
+}
+
+func runUntilCancelled(t *testing.T, a *Agent, cancel context.CancelFunc) error {
+	t.Helper()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+		return nil
+	}
+}
+
+func TestRunFlushesOnShutdown(t *testing.T) {
+	srv := newFakeServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	a := newFlushAgent(t, ctx, srv.address(), "")
+
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	require.NoError(t, runUntilCancelled(t, a, cancel))
+
+	// Jobs, метрики runtime и CollectorUp сборщика runtime.
+	metrics, _ := runtimeCollector{}.Collect(context.Background())
+	assert.Equal(t, 1+len(metrics)+1, srv.count())
+	assert.Zero(t, a.pending())
+}
+
+func TestRunPersistsUnsentMetrics(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setStatus(http.StatusServiceUnavailable)
+	path := filepath.Join(t.TempDir(), "outbox.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := newFlushAgent(t, ctx, srv.address(), path)
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	require.NoError(t, runUntilCancelled(t, a, cancel))
+	require.FileExists(t, path)
+
+	srv.setStatus(http.StatusOK)
+	restarted := newFlushAgent(t, context.Background(), srv.address(), path)
+	assert.NoFileExists(t, path, "outbox file is consumed on start")
+	pending := restarted.pending()
+	assert.Positive(t, pending)
+
+	drainAll(restarted)
+	assert.Equal(t, pending, srv.count())
+}
+
+func TestRunReportsUnwritableOutbox(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setStatus(http.StatusServiceUnavailable)
+	path := filepath.Join(t.TempDir(), "missing", "outbox.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := newFlushAgent(t, ctx, srv.address(), path)
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	err := runUntilCancelled(t, a, cancel)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), path)
+}
+
+func TestDefaultConfigDisablesOutbox(t *testing.T) {
+	assert.Empty(t, DefaultConfig().OutboxPath)
+}
+
+func TestLoadOutboxesRejectsBrokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+
+	_, err := NewWithConfig(context.Background(), AgentConfig{
+		Address:        "localhost:8080",
+		PollInterval:   configloader.Seconds(1),
+		ReportInterval: configloader.Seconds(1),
+		OutboxPath:     path,
+	})
+	assert.Error(t, err)
+}
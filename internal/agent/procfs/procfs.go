@@ -0,0 +1,262 @@
+// Package procfs читает системную статистику Linux из /proc.
+// Корень файловой системы задается явно, чтобы парсеры можно было проверять на тестовых данных.
+package procfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultRoot - стандартная точка монтирования procfs.
+const DefaultRoot = "/proc"
+
+// sectorSize - размер сектора в /proc/diskstats, не зависит от устройства.
+const sectorSize = 512
+
+// LoadAvg - средняя загрузка системы за 1, 5 и 15 минут.
+type LoadAvg struct {
+	Load1  float64
+	Load5  float64
+	Load15 float64
+}
+
+// NetDev - счетчики сетевого интерфейса.
+type NetDev struct {
+	Interface string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+}
+
+// DiskStats - счетчики ввода-вывода блочного устройства.
+type DiskStats struct {
+	Device       string
+	Reads        uint64
+	ReadBytes    uint64
+	Writes       uint64
+	WrittenBytes uint64
+	IOTimeMs     uint64
+}
+
+// Mount - смонтированная файловая система.
+type Mount struct {
+	Device     string
+	MountPoint string
+	FSType     string
+}
+
+// FileDescriptors - количество открытых дескрипторов файлов в системе и их предел.
+type FileDescriptors struct {
+	Allocated uint64
+	Max       uint64
+}
+
+// pseudoFS - файловые системы без собственного хранилища, которые не имеет смысла мерить.
+var pseudoFS = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true,
+	"configfs": true, "debugfs": true, "devpts": true, "devtmpfs": true, "fusectl": true,
+	"hugetlbfs": true, "mqueue": true, "nsfs": true, "proc": true, "pstore": true,
+	"securityfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+}
+
+// ReadLoadAvg читает /proc/loadavg.
+func ReadLoadAvg(root string) (LoadAvg, error) {
+	content, err := os.ReadFile(filepath.Join(root, "loadavg"))
+	if err != nil {
+		return LoadAvg{}, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) < 3 {
+		return LoadAvg{}, errors.New("unexpected loadavg format")
+	}
+
+	var values [3]float64
+	for i := range values {
+		values[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return LoadAvg{}, fmt.Errorf("loadavg: %w", err)
+		}
+	}
+
+	return LoadAvg{Load1: values[0], Load5: values[1], Load15: values[2]}, nil
+}
+
+// ReadNetDev читает /proc/net/dev. Первые две строки - заголовок таблицы.
+func ReadNetDev(root string) ([]NetDev, error) {
+	lines, err := readLines(filepath.Join(root, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]NetDev, 0, len(lines))
+	for _, line := range lines[min(2, len(lines)):] {
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		values, err := parseUints(strings.Fields(rest))
+		if err != nil || len(values) < 11 {
+			return nil, fmt.Errorf("net/dev: unexpected line %q", line)
+		}
+		stats = append(stats, NetDev{
+			Interface: strings.TrimSpace(name),
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+		})
+	}
+
+	return stats, nil
+}
+
+// ReadDiskStats читает /proc/diskstats. Loop- и ram-устройства пропускаются.
+func ReadDiskStats(root string) ([]DiskStats, error) {
+	lines, err := readLines(filepath.Join(root, "diskstats"))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]DiskStats, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 14 {
+			continue
+		}
+		device := fields[2]
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+		values, err := parseUints(fields[3:14])
+		if err != nil {
+			return nil, fmt.Errorf("diskstats: unexpected line %q", line)
+		}
+		stats = append(stats, DiskStats{
+			Device:       device,
+			Reads:        values[0],
+			ReadBytes:    values[2] * sectorSize,
+			Writes:       values[4],
+			WrittenBytes: values[6] * sectorSize,
+			IOTimeMs:     values[9],
+		})
+	}
+
+	return stats, nil
+}
+
+// ReadMounts читает /proc/mounts и возвращает файловые системы с реальным хранилищем.
+func ReadMounts(root string) ([]Mount, error) {
+	lines, err := readLines(filepath.Join(root, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := make([]Mount, 0, len(lines))
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || pseudoFS[fields[2]] {
+			continue
+		}
+		mountPoint := unescapeMountPoint(fields[1])
+		if seen[mountPoint] {
+			continue
+		}
+		seen[mountPoint] = true
+		mounts = append(mounts, Mount{Device: fields[0], MountPoint: mountPoint, FSType: fields[2]})
+	}
+
+	return mounts, nil
+}
+
+// ReadFileDescriptors читает /proc/sys/fs/file-nr.
+func ReadFileDescriptors(root string) (FileDescriptors, error) {
+	content, err := os.ReadFile(filepath.Join(root, "sys", "fs", "file-nr"))
+	if err != nil {
+		return FileDescriptors{}, err
+	}
+
+	values, err := parseUints(strings.Fields(string(content)))
+	if err != nil || len(values) < 3 {
+		return FileDescriptors{}, errors.New("unexpected file-nr format")
+	}
+
+	return FileDescriptors{Allocated: values[0], Max: values[2]}, nil
+}
+
+// ReadContextSwitches возвращает общее число переключений контекста из /proc/stat.
+func ReadContextSwitches(root string) (uint64, error) {
+	lines, err := readLines(filepath.Join(root, "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		value, ok := strings.CutPrefix(line, "ctxt ")
+		if ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+
+	return 0, errors.New("ctxt not found in stat")
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, scanner.Err()
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// unescapeMountPoint раскрывает восьмеричные последовательности вида \040 из /proc/mounts.
+func unescapeMountPoint(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if code, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,71 @@
+package procfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRoot = "testdata"
+
+func TestReadLoadAvg(t *testing.T) {
+	load, err := ReadLoadAvg(testRoot)
+	require.NoError(t, err)
+	assert.Equal(t, LoadAvg{Load1: 0.21, Load5: 0.24, Load15: 0.20}, load)
+}
+
+func TestReadNetDev(t *testing.T) {
+	stats, err := ReadNetDev(testRoot)
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	assert.Equal(t, NetDev{
+		Interface: "eth0",
+		RxBytes:   987654321,
+		RxPackets: 12345,
+		RxErrors:  3,
+		TxBytes:   123456789,
+		TxPackets: 6789,
+		TxErrors:  1,
+	}, stats[1])
+}
+
+func TestReadDiskStats(t *testing.T) {
+	stats, err := ReadDiskStats(testRoot)
+	require.NoError(t, err)
+	require.Len(t, stats, 2, "loop devices must be skipped")
+	assert.Equal(t, DiskStats{
+		Device:       "sda",
+		Reads:        1000,
+		ReadBytes:    20480 * 512,
+		Writes:       2000,
+		WrittenBytes: 40960 * 512,
+		IOTimeMs:     1200,
+	}, stats[0])
+}
+
+func TestReadMounts(t *testing.T) {
+	mounts, err := ReadMounts(testRoot)
+	require.NoError(t, err)
+	assert.Equal(t, []Mount{
+		{Device: "/dev/sda1", MountPoint: "/", FSType: "ext4"},
+		{Device: "/dev/sdb1", MountPoint: "/mnt/data disk", FSType: "xfs"},
+	}, mounts)
+}
+
+func TestReadFileDescriptors(t *testing.T) {
+	fds, err := ReadFileDescriptors(testRoot)
+	require.NoError(t, err)
+	assert.Equal(t, FileDescriptors{Allocated: 282, Max: 612720}, fds)
+}
+
+func TestReadContextSwitches(t *testing.T) {
+	ctxt, err := ReadContextSwitches(testRoot)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(752004), ctxt)
+}
+
+func TestMissingRoot(t *testing.T) {
+	_, err := ReadLoadAvg("testdata/missing")
+	assert.Error(t, err)
+}
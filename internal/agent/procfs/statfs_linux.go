@@ -0,0 +1,29 @@
+package procfs
+
+import "syscall"
+
+// FSUsage - занятость файловой системы в байтах.
+type FSUsage struct {
+	Total     uint64
+	Free      uint64
+	Available uint64
+	Used      uint64
+}
+
+// ReadFSUsage возвращает занятость файловой системы, смонтированной в path.
+func ReadFSUsage(path string) (FSUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return FSUsage{}, err
+	}
+
+	blockSize := uint64(st.Bsize)
+	usage := FSUsage{
+		Total:     st.Blocks * blockSize,
+		Free:      st.Bfree * blockSize,
+		Available: st.Bavail * blockSize,
+	}
+	usage.Used = usage.Total - usage.Free
+
+	return usage, nil
+}
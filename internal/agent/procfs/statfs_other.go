@@ -0,0 +1,18 @@
+//go:build !linux
+
+package procfs
+
+import "errors"
+
+// FSUsage - занятость файловой системы в байтах.
+type FSUsage struct {
+	Total     uint64
+	Free      uint64
+	Available uint64
+	Used      uint64
+}
+
+// ReadFSUsage поддерживается только в Linux.
+func ReadFSUsage(path string) (FSUsage, error) {
+	return FSUsage{}, errors.New("filesystem usage is supported on linux only")
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPushSize - предельный размер тела запроса к локальному приемнику.
+const maxPushSize = 10 << 20
+
+// pushServer - локальный приемник метрик от приложений. Принимает тот же JSON
+// в /update/ и /updates/, что и сервер, но без подписи и шифрования: слушает только
+// localhost и unix-сокет. Метрики передаются в sink и уходят на сервер вместе
+// с остальными метриками агента.
+type pushServer struct {
+	servers   []*http.Server
+	listeners []net.Listener
+}
+
+// newPushServer открывает слушателей. Пустой address или socket отключает
+// соответствующего слушателя.
+func newPushServer(address, socket string, sink func([]contracts.Metrics)) (*pushServer, error) {
+	var listeners []net.Listener
+	if len(address) != 0 {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(socket) != 0 {
+		// Сокет мог остаться после аварийного завершения прошлого запуска.
+		if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			closeListeners(listeners)
+			return nil, err
+		}
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	p := &pushServer{listeners: listeners}
+	router := pushRouter(sink)
+	for range listeners {
+		p.servers = append(p.servers, &http.Server{Handler: router, ReadHeaderTimeout: 5 * time.Second})
+	}
+	return p, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// start начинает обслуживать запросы в фоне.
+func (p *pushServer) start() {
+	for i, srv := range p.servers {
+		go func(srv *http.Server, l net.Listener) {
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Logger.Errorf("Push endpoint %s stopped: %v", l.Addr(), err)
+			}
+		}(srv, p.listeners[i])
+	}
+}
+
+// shutdown дожидается обработки начатых запросов.
+func (p *pushServer) shutdown(ctx context.Context) {
+	for _, srv := range p.servers {
+		srv.Shutdown(ctx)
+	}
+}
+
+func pushRouter(sink func([]contracts.Metrics)) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/update/", func(w http.ResponseWriter, r *http.Request) {
+		var metric contracts.Metrics
+		if err := decodePush(r, &metric); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := metric.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sink([]contracts.Metrics{metric})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metric)
+	})
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		var metrics []contracts.Metrics
+		if err := decodePush(r, &metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, metric := range metrics {
+			if err := metric.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		sink(metrics)
+
+		w.WriteHeader(http.StatusOK)
+	})
+	return r
+}
+
+// decodePush читает JSON из тела запроса, при необходимости распаковывая gzip.
+func decodePush(r *http.Request, dst any) error {
+	var body io.Reader = http.MaxBytesReader(nil, r.Body, maxPushSize)
+	if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("failed to decompress body: %w", err)
+		}
+		defer zr.Close()
+		body = io.LimitReader(zr, maxPushSize)
+	}
+
+	if err := json.NewDecoder(body).Decode(dst); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushRouter(t *testing.T) {
+	a := New("localhost:8080", time.Second, time.Second, context.Background(), "", 0, "")
+	srv := httptest.NewServer(pushRouter(a.aggregator.add))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		gzip   bool
+		status int
+	}{
+		{name: "gauge", path: "/update/", body: `{"id":"Temp","type":"gauge","value":1.5}`, status: http.StatusOK},
+		{name: "last gauge wins", path: "/update/", body: `{"id":"Temp","type":"gauge","value":2.5}`, status: http.StatusOK},
+		{name: "counter", path: "/update/", body: `{"id":"Jobs","type":"counter","delta":2}`, status: http.StatusOK},
+		{name: "batch", path: "/updates/", body: `[{"id":"Jobs","type":"counter","delta":3},{"id":"Queue","type":"gauge","value":4}]`, gzip: true, status: http.StatusOK},
+		{name: "missing delta", path: "/update/", body: `{"id":"Jobs","type":"counter"}`, status: http.StatusBadRequest},
+		{name: "unknown type", path: "/updates/", body: `[{"id":"Jobs","type":"histogram","delta":1}]`, status: http.StatusBadRequest},
+		{name: "broken json", path: "/update/", body: `{`, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := []byte(test.body)
+			if test.gzip {
+				var buf bytes.Buffer
+				zw := gzip.NewWriter(&buf)
+				zw.Write(body)
+				zw.Close()
+				body = buf.Bytes()
+			}
+			req, err := http.NewRequest(http.MethodPost, srv.URL+test.path, bytes.NewReader(body))
+			require.NoError(t, err)
+			if test.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.status, resp.StatusCode)
+		})
+	}
+
+	byID := metricsByID(a.aggregator.flush([]string{AggregateLast}))
+	assert.Equal(t, 2.5, *byID["Temp"].Value)
+	assert.Equal(t, 4.0, *byID["Queue"].Value)
+	assert.Equal(t, int64(5), *byID["Jobs"].Delta)
+}
+
+func TestPushServerUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	received := make(chan []contracts.Metrics, 1)
+	push, err := newPushServer("", socket, func(metrics []contracts.Metrics) { received <- metrics })
+	require.NoError(t, err)
+	push.start()
+	defer push.shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Post("http://agent/update/", "application/json", strings.NewReader(`{"id":"Jobs","type":"counter","delta":1}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var echoed contracts.Metrics
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&echoed))
+	assert.Equal(t, "Jobs", echoed.ID)
+	assert.Equal(t, int64(1), *(<-received)[0].Delta)
+}
+
+func TestPushAddressMustBeLoopback(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, address := range []string{"localhost:9000", "127.0.0.1:9000", "[::1]:9000"} {
+		cfg.PushAddress = address
+		assert.NoError(t, cfg.Validate(), address)
+	}
+	for _, address := range []string{"0.0.0.0:9000", ":9000", "10.0.0.1:9000"} {
+		cfg.PushAddress = address
+		assert.Error(t, cfg.Validate(), address)
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/evildead81/metrics-and-alerts/internal/agent/textformat"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+)
+
+// maxScrapeSize - предельный размер ответа /metrics.
+const maxScrapeSize = 10 << 20
+
+// scrapeCollector забирает метрики приложения в формате Prometheus по HTTP.
+// К каждой серии добавляется метка target с адресом приложения.
+type scrapeCollector struct {
+	url    string
+	target string
+	client *http.Client
+	deltas *deltaTracker
+}
+
+// newScrapeCollector создает сборщик для цели вида host:port, http://host:port
+// или полного URL. Если путь не указан, используется /metrics.
+func newScrapeCollector(target string) (*scrapeCollector, error) {
+	u, err := parseScrapeTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scrapeCollector{
+		url:    u.String(),
+		target: u.Host,
+		client: &http.Client{},
+		deltas: newDeltaTracker(),
+	}, nil
+}
+
+func parseScrapeTarget(target string) (*url.URL, error) {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("scrape target %q: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+		return nil, fmt.Errorf("scrape target %q: expected http(s)://host:port[/path]", target)
+	}
+	if len(u.Path) == 0 {
+		u.Path = "/metrics"
+	}
+	return u, nil
+}
+
+func (c *scrapeCollector) Name() string {
+	return "scrape:" + c.target
+}
+
+func (c *scrapeCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", c.url, resp.Status)
+	}
+
+	samples, err := textformat.ParsePrometheus(io.LimitReader(resp.Body, maxScrapeSize), "target", c.target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.url, err)
+	}
+
+	return c.deltas.metrics(samples), nil
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeCollector(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metrics", r.URL.Path)
+		requests++
+		w.Write([]byte("# TYPE requests_total counter\n" +
+			"requests_total{code=\"200\"} " + []string{"100", "130"}[min(requests-1, 1)] + "\n" +
+			"goroutines{target=\"self\"} 12\n"))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	c, err := newScrapeCollector(u.Host)
+	require.NoError(t, err)
+	assert.Equal(t, "scrape:"+u.Host, c.Name())
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	byID := metricsByID(metrics)
+	assert.Equal(t, 12.0, *byID[`goroutines{exported_target="self",target="`+u.Host+`"}`].Value)
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	byID = metricsByID(metrics)
+	assert.Equal(t, int64(30), *byID[`requests_total{code="200",target="`+u.Host+`"}`].Delta)
+}
+
+func TestScrapeCollectorErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no metrics", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c, err := newScrapeCollector(srv.URL + "/custom")
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/custom", c.url)
+
+	_, err = c.Collect(context.Background())
+	assert.ErrorContains(t, err, "404")
+
+	_, err = newScrapeCollector("ftp://host/metrics")
+	assert.Error(t, err)
+}
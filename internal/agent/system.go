@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/evildead81/metrics-and-alerts/internal/agent/procfs"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
+// procfsSettings - включенные сборщики системных метрик.
+type procfsSettings struct {
+	root            string
+	load            bool
+	network         bool
+	disk            bool
+	filesystem      bool
+	fileDescriptors bool
+	contextSwitches bool
+}
+
+// systemCollector собирает системные метрики из procfs. Накопительные счетчики ядра
+// переводятся в приращения с прошлого сбора, потому что сервер суммирует counter.
+type systemCollector struct {
+	mutex    *sync.Mutex
+	last     map[string]uint64
+	settings func() procfsSettings
+}
+
+func newSystemCollector(settings func() procfsSettings) *systemCollector {
+	return &systemCollector{
+		mutex:    &sync.Mutex{},
+		last:     make(map[string]uint64),
+		settings: settings,
+	}
+}
+
+func (c *systemCollector) Name() string {
+	return "procfs"
+}
+
+// Collect собирает метрики сборщиков, включенных в текущей конфигурации агента.
+func (c *systemCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	return c.collect(c.settings())
+}
+
+// collect возвращает метрики включенных сборщиков. Ошибка одного сборщика
+// не мешает остальным.
+func (c *systemCollector) collect(s procfsSettings) ([]contracts.Metrics, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var metrics []contracts.Metrics
+	var errs []error
+
+	if s.load {
+		load, err := procfs.ReadLoadAvg(s.root)
+		if err == nil {
+			metrics = append(metrics,
+				gauge("LoadAverage1", load.Load1),
+				gauge("LoadAverage5", load.Load5),
+				gauge("LoadAverage15", load.Load15),
+			)
+		}
+		errs = append(errs, err)
+	}
+
+	if s.network {
+		stats, err := procfs.ReadNetDev(s.root)
+		for _, st := range stats {
+			labels := []string{"interface", st.Interface}
+			metrics = c.appendCounter(metrics, contracts.LabelledID("NetworkReceivedBytes", labels...), st.RxBytes)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("NetworkReceivedPackets", labels...), st.RxPackets)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("NetworkReceiveErrors", labels...), st.RxErrors)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("NetworkTransmittedBytes", labels...), st.TxBytes)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("NetworkTransmittedPackets", labels...), st.TxPackets)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("NetworkTransmitErrors", labels...), st.TxErrors)
+		}
+		errs = append(errs, err)
+	}
+
+	if s.disk {
+		stats, err := procfs.ReadDiskStats(s.root)
+		for _, st := range stats {
+			labels := []string{"device", st.Device}
+			metrics = c.appendCounter(metrics, contracts.LabelledID("DiskReads", labels...), st.Reads)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("DiskReadBytes", labels...), st.ReadBytes)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("DiskWrites", labels...), st.Writes)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("DiskWrittenBytes", labels...), st.WrittenBytes)
+			metrics = c.appendCounter(metrics, contracts.LabelledID("DiskIOTimeMs", labels...), st.IOTimeMs)
+		}
+		errs = append(errs, err)
+	}
+
+	if s.filesystem {
+		mounts, err := procfs.ReadMounts(s.root)
+		for _, mount := range mounts {
+			usage, err := procfs.ReadFSUsage(mount.MountPoint)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			labels := []string{"mount", mount.MountPoint}
+			metrics = append(metrics,
+				gauge(contracts.LabelledID("FilesystemTotalBytes", labels...), float64(usage.Total)),
+				gauge(contracts.LabelledID("FilesystemFreeBytes", labels...), float64(usage.Available)),
+				gauge(contracts.LabelledID("FilesystemUsedBytes", labels...), float64(usage.Used)),
+			)
+			if usage.Total != 0 {
+				metrics = append(metrics, gauge(
+					contracts.LabelledID("FilesystemUsedPercent", labels...),
+					float64(usage.Used)/float64(usage.Total)*100,
+				))
+			}
+		}
+		errs = append(errs, err)
+	}
+
+	if s.fileDescriptors {
+		fds, err := procfs.ReadFileDescriptors(s.root)
+		if err == nil {
+			metrics = append(metrics,
+				gauge("OpenFileDescriptors", float64(fds.Allocated)),
+				gauge("MaxFileDescriptors", float64(fds.Max)),
+			)
+		}
+		errs = append(errs, err)
+	}
+
+	if s.contextSwitches {
+		ctxt, err := procfs.ReadContextSwitches(s.root)
+		if err == nil {
+			metrics = c.appendCounter(metrics, "ContextSwitches", ctxt)
+		}
+		errs = append(errs, err)
+	}
+
+	return metrics, errors.Join(errs...)
+}
+
+// appendCounter добавляет приращение накопительного счетчика с прошлого сбора.
+// Первое значение только запоминается. Если счетчик уменьшился (например,
+// после перезагрузки или пересоздания интерфейса), отсчет начинается с нуля.
+func (c *systemCollector) appendCounter(metrics []contracts.Metrics, id string, value uint64) []contracts.Metrics {
+	last, ok := c.last[id]
+	c.last[id] = value
+	if !ok {
+		return metrics
+	}
+
+	delta := int64(value - last)
+	if value < last {
+		delta = int64(value)
+	}
+
+	return append(metrics, contracts.Metrics{ID: id, Delta: &delta, MType: consts.Counter})
+}
+
+func gauge(id string, value float64) contracts.Metrics {
+	return contracts.Metrics{ID: id, Value: &value, MType: consts.Gauge}
+}
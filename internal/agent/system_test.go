@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metricsByID(metrics []contracts.Metrics) map[string]contracts.Metrics {
+	byID := make(map[string]contracts.Metrics, len(metrics))
+	for _, m := range metrics {
+		byID[m.ID] = m
+	}
+	return byID
+}
+
+func TestSystemCollector(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"loadavg", "stat", "net/dev", "sys/fs/file-nr"} {
+		content, err := os.ReadFile(filepath.Join("procfs/testdata", name))
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), content, 0600))
+	}
+
+	c := newSystemCollector(nil)
+	s := procfsSettings{root: root, load: true, network: true, contextSwitches: true, fileDescriptors: true}
+
+	first, err := c.collect(s)
+	require.NoError(t, err)
+	byID := metricsByID(first)
+	assert.Equal(t, 0.21, *byID["LoadAverage1"].Value)
+	assert.Equal(t, 282.0, *byID["OpenFileDescriptors"].Value)
+	assert.NotContains(t, byID, "ContextSwitches", "first counter value is only remembered")
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "stat"), []byte("ctxt 752104\n"), 0600))
+
+	second, err := c.collect(s)
+	require.NoError(t, err)
+	byID = metricsByID(second)
+	assert.Equal(t, int64(100), *byID["ContextSwitches"].Delta)
+	assert.Equal(t, int64(0), *byID[`NetworkReceivedBytes{interface="eth0"}`].Delta)
+}
+
+func TestSystemCollectorDisabled(t *testing.T) {
+	metrics, err := newSystemCollector(nil).collect(procfsSettings{root: "procfs/testdata"})
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+}
+
+func TestSystemCollectorReportsErrors(t *testing.T) {
+	metrics, err := newSystemCollector(nil).collect(procfsSettings{root: t.TempDir(), load: true, disk: true})
+	assert.Error(t, err)
+	assert.Empty(t, metrics)
+}
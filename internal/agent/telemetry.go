@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
+// DefaultTelemetryPrefix - префикс метрик самодиагностики агента по умолчанию.
+const DefaultTelemetryPrefix = "agent_"
+
+// telemetry - счетчики работы самого агента. Обновляются всегда, а на сервер
+// уходят, только если включен сборщик самодиагностики.
+type telemetry struct {
+	samples           atomic.Int64
+	batchesSent       atomic.Int64
+	batchesFailed     atomic.Int64
+	batchesDropped    atomic.Int64
+	retries           atomic.Int64
+	bytesUncompressed atomic.Int64
+	bytesCompressed   atomic.Int64
+	latencySum        atomic.Int64
+	latencyCount      atomic.Int64
+	lastSend          atomic.Int64
+}
+
+// sent учитывает отправку пачки и ее длительность.
+func (m *telemetry) sent(err error, latency time.Duration) {
+	if err != nil {
+		m.batchesFailed.Add(1)
+		return
+	}
+	m.batchesSent.Add(1)
+	m.latencySum.Add(int64(latency))
+	m.latencyCount.Add(1)
+	m.lastSend.Store(time.Now().Unix())
+}
+
+// telemetryCollector отдает счетчики агента как метрики с заданным префиксом.
+// Счетчики передаются приращениями с прошлого сбора, задержка - средним за тот же период.
+type telemetryCollector struct {
+	prefix     string
+	telemetry  *telemetry
+	queueDepth func() int
+}
+
+func (c *telemetryCollector) Name() string {
+	return "agent"
+}
+
+func (c *telemetryCollector) Collect(ctx context.Context) ([]contracts.Metrics, error) {
+	m := c.telemetry
+	metrics := []contracts.Metrics{
+		c.counter("samples_collected", m.samples.Swap(0)),
+		c.counter("batches_sent", m.batchesSent.Swap(0)),
+		c.counter("batches_failed", m.batchesFailed.Swap(0)),
+		c.counter("batches_dropped", m.batchesDropped.Swap(0)),
+		c.counter("send_retries", m.retries.Swap(0)),
+		c.counter("bytes_uncompressed", m.bytesUncompressed.Swap(0)),
+		c.counter("bytes_compressed", m.bytesCompressed.Swap(0)),
+		gauge(c.prefix+"queue_depth", float64(c.queueDepth())),
+	}
+	if last := m.lastSend.Load(); last != 0 {
+		metrics = append(metrics, gauge(c.prefix+"last_send_timestamp_seconds", float64(last)))
+	}
+	if count := m.latencyCount.Swap(0); count != 0 {
+		latency := time.Duration(m.latencySum.Swap(0) / count)
+		metrics = append(metrics, gauge(c.prefix+"send_latency_seconds", latency.Seconds()))
+	}
+
+	return metrics, nil
+}
+
+func (c *telemetryCollector) counter(name string, delta int64) contracts.Metrics {
+	return contracts.Metrics{ID: c.prefix + name, MType: consts.Counter, Delta: &delta}
+}
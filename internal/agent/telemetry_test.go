@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTelemetryAgent(t *testing.T, address string, enabled bool) *Agent {
+	t.Helper()
+	a, err := NewWithConfig(context.Background(), AgentConfig{
+		Address:        address,
+		PollInterval:   configloader.Seconds(1),
+		ReportInterval: configloader.Seconds(1),
+		SelfTelemetry:  enabled,
+	})
+	require.NoError(t, err)
+	return a
+}
+
+func telemetryOf(t *testing.T, a *Agent) map[string]contracts.Metrics {
+	t.Helper()
+	for _, rc := range a.registry.list() {
+		if rc.collector.Name() == "agent" {
+			metrics, err := rc.collector.Collect(context.Background())
+			require.NoError(t, err)
+			return metricsByID(metrics)
+		}
+	}
+	t.Fatal("telemetry collector is not registered")
+	return nil
+}
+
+func TestTelemetryIsOptional(t *testing.T) {
+	a := newTelemetryAgent(t, "localhost:8080", false)
+	for _, rc := range a.registry.list() {
+		assert.NotEqual(t, "agent", rc.collector.Name())
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	a := newTelemetryAgent(t, strings.TrimPrefix(srv.URL, "http://"), true)
+	a.collected([]contracts.Metrics{gauge("Temp", 1), counter("Jobs", 1)})
+
+	byID := telemetryOf(t, a)
+	assert.Equal(t, int64(2), *byID["agent_samples_collected"].Delta)
+	assert.Equal(t, 2.0, *byID["agent_queue_depth"].Value)
+	assert.NotContains(t, byID, "agent_last_send_timestamp_seconds")
+	assert.NotContains(t, byID, "agent_send_latency_seconds")
+
+	a.report()
+	drainAll(a)
+	byID = telemetryOf(t, a)
+	assert.Equal(t, int64(0), *byID["agent_samples_collected"].Delta, "counters are reported as deltas")
+	assert.Equal(t, int64(1), *byID["agent_batches_sent"].Delta)
+	assert.Equal(t, int64(0), *byID["agent_batches_failed"].Delta)
+	assert.Equal(t, 0.0, *byID["agent_queue_depth"].Value)
+	assert.Positive(t, *byID["agent_bytes_uncompressed"].Delta)
+	assert.Positive(t, *byID["agent_bytes_compressed"].Delta)
+	assert.Contains(t, byID, "agent_last_send_timestamp_seconds")
+	assert.Contains(t, byID, "agent_send_latency_seconds")
+
+	status = http.StatusInternalServerError
+	a.collected([]contracts.Metrics{counter("Jobs", 1)})
+	a.report()
+	drainAll(a)
+	byID = telemetryOf(t, a)
+	assert.Equal(t, int64(1), *byID["agent_batches_failed"].Delta)
+	assert.Equal(t, 1.0, *byID["agent_queue_depth"].Value, "failed batch stays in the outbox")
+	assert.NotContains(t, byID, "agent_send_latency_seconds")
+}
+
+func TestTelemetryRetries(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	address := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	a := newTelemetryAgent(t, address, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	a.ctx = ctx
+
+	require.Error(t, a.postWithRetry(ctx, a.currentSettings().destinations[0], []contracts.Metrics{counter("Jobs", 1)}))
+	byID := telemetryOf(t, a)
+	assert.Equal(t, int64(1), *byID["agent_send_retries"].Delta, "retries stop when the agent is cancelled")
+}
@@ -0,0 +1,405 @@
+// Package textformat разбирает текстовые форматы метрик: Prometheus exposition format,
+// InfluxDB line protocol и простой формат "имя тип значение".
+package textformat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
+// Форматы вывода.
+const (
+	FormatAuto       = ""
+	FormatPrometheus = "prometheus"
+	FormatInflux     = "influx"
+	FormatSimple     = "simple"
+)
+
+// Sample - одно значение метрики.
+type Sample struct {
+	// ID - имя метрики с метками в виде name{key="value",...}.
+	ID string
+	// Type - consts.Gauge или consts.Counter.
+	Type  string
+	Value float64
+	// Cumulative - значение counter является накопленным итогом, а не приращением.
+	Cumulative bool
+}
+
+// Parse разбирает content в заданном формате. Для FormatAuto формат определяется по содержимому.
+func Parse(format string, content []byte) ([]Sample, error) {
+	if format == FormatAuto {
+		format = Detect(content)
+	}
+
+	r := bytes.NewReader(content)
+	switch format {
+	case FormatPrometheus:
+		return ParsePrometheus(r)
+	case FormatInflux:
+		return ParseInflux(r)
+	case FormatSimple:
+		return ParseSimple(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// ValidFormat сообщает, поддерживается ли формат.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatAuto, FormatPrometheus, FormatInflux, FormatSimple:
+		return true
+	}
+	return false
+}
+
+// Detect определяет формат по первой значимой строке.
+func Detect(content []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			return FormatPrometheus
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 3 && (fields[1] == consts.Gauge || fields[1] == consts.Counter) {
+			return FormatSimple
+		}
+		if len(fields) >= 2 && strings.Contains(fields[1], "=") && !strings.Contains(fields[0], "{") {
+			return FormatInflux
+		}
+		return FormatPrometheus
+	}
+	return FormatSimple
+}
+
+// ParseSimple разбирает строки "имя тип значение", где тип - gauge или counter.
+// Значение counter считается приращением. Пустые строки и строки с # пропускаются.
+func ParseSimple(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	err := eachLine(r, func(line string) error {
+		if strings.HasPrefix(line, "#") {
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return errors.New("expected 'name type value'")
+		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return err
+		}
+		switch fields[1] {
+		case consts.Gauge:
+		case consts.Counter:
+			if value != math.Trunc(value) {
+				return errors.New("counter value must be integer")
+			}
+		default:
+			return fmt.Errorf("unknown type %q", fields[1])
+		}
+		if isFinite(value) {
+			samples = append(samples, Sample{ID: fields[0], Type: fields[1], Value: value})
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// ParsePrometheus разбирает Prometheus text exposition format.
+// Серии counter, а также _bucket, _count и _sum у histogram и summary, являются
+// накопленными итогами. Остальные серии считаются gauge. Метки сортируются по имени.
+//
+// extra - пары ключ-значение, которые добавляются к меткам каждой серии. Если у серии
+// уже есть такая метка, она переименовывается в exported_<ключ>, как это делает Prometheus.
+func ParsePrometheus(r io.Reader, extra ...string) ([]Sample, error) {
+	types := make(map[string]string)
+	var samples []Sample
+	err := eachLine(r, func(line string) error {
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			return nil
+		}
+
+		name, labels, rest, err := splitPrometheusSeries(line)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || len(fields) > 2 {
+			return errors.New("expected value and optional timestamp")
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return err
+		}
+		if !isFinite(value) {
+			return nil
+		}
+
+		for i := 0; i+1 < len(extra); i += 2 {
+			if existing, ok := labels[extra[i]]; ok {
+				labels["exported_"+extra[i]] = existing
+			}
+			labels[extra[i]] = extra[i+1]
+		}
+
+		sample := Sample{ID: contracts.LabelledID(name, sortedLabels(labels)...), Type: consts.Gauge, Value: value}
+		if isCumulative(name, types) {
+			sample.Type = consts.Counter
+			sample.Cumulative = true
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	return samples, err
+}
+
+// isCumulative проверяет тип семейства, к которому относится серия.
+func isCumulative(name string, types map[string]string) bool {
+	if types[name] == "counter" {
+		return true
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if ok && (types[base] == "histogram" || types[base] == "summary") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPrometheusSeries разделяет строку на имя, метки и остаток со значением.
+func splitPrometheusSeries(line string) (string, map[string]string, string, error) {
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return "", nil, "", errors.New("expected metric name and value")
+	}
+	name := line[:end]
+	labels := make(map[string]string)
+	if line[end] != '{' {
+		return name, labels, line[end:], nil
+	}
+
+	i := end + 1
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == ',') {
+			i++
+		}
+		if i < len(line) && line[i] == '}' {
+			break
+		}
+		eq := strings.IndexByte(line[i:], '=')
+		if eq <= 0 {
+			return "", nil, "", errors.New("malformed labels")
+		}
+		key := strings.TrimSpace(line[i : i+eq])
+		i += eq + 1
+		if i >= len(line) || line[i] != '"' {
+			return "", nil, "", errors.New("label value must be quoted")
+		}
+		value, n, err := unquote(line[i:])
+		if err != nil {
+			return "", nil, "", err
+		}
+		labels[key] = value
+		i += n
+	}
+
+	return name, labels, line[i+1:], nil
+}
+
+// unquote читает строку в кавычках с экранированием \\, \" и \n и возвращает ее
+// значение и количество прочитанных байт.
+func unquote(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				break
+			}
+			if s[i] == 'n' {
+				sb.WriteByte('\n')
+			} else {
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated quoted string")
+}
+
+// ParseInflux разбирает InfluxDB line protocol. Каждое числовое или булево поле
+// становится gauge с именем measurement_field, теги - метками. Строковые поля
+// и строки с # пропускаются.
+func ParseInflux(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	err := eachLine(r, func(line string) error {
+		if strings.HasPrefix(line, "#") {
+			return nil
+		}
+		parts := splitUnescaped(line, ' ', true)
+		if len(parts) < 2 || len(parts) > 3 {
+			return errors.New("expected measurement, fields and optional timestamp")
+		}
+
+		series := splitUnescaped(parts[0], ',', false)
+		measurement := unescapeInflux(series[0])
+		if len(measurement) == 0 {
+			return errors.New("measurement is empty")
+		}
+		tags := make(map[string]string, len(series)-1)
+		for _, tag := range series[1:] {
+			kv := splitUnescaped(tag, '=', false)
+			if len(kv) != 2 {
+				return fmt.Errorf("malformed tag %q", tag)
+			}
+			tags[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+		}
+		labels := sortedLabels(tags)
+
+		for _, field := range splitUnescaped(parts[1], ',', true) {
+			key, raw, ok := cutUnescaped(field, '=')
+			if !ok {
+				return fmt.Errorf("malformed field %q", field)
+			}
+			value, ok, err := parseInfluxValue(raw)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", key, err)
+			}
+			if !ok || !isFinite(value) {
+				continue
+			}
+			samples = append(samples, Sample{
+				ID:    contracts.LabelledID(measurement+"_"+unescapeInflux(key), labels...),
+				Type:  consts.Gauge,
+				Value: value,
+			})
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// parseInfluxValue разбирает значение поля. ok=false для строковых полей.
+func parseInfluxValue(raw string) (float64, bool, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		return 0, false, nil
+	}
+	if trimmed, ok := strings.CutSuffix(raw, "i"); ok {
+		n, err := strconv.ParseInt(trimmed, 10, 64)
+		return float64(n), true, err
+	}
+	if trimmed, ok := strings.CutSuffix(raw, "u"); ok {
+		n, err := strconv.ParseUint(trimmed, 10, 64)
+		return float64(n), true, err
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	return f, true, err
+}
+
+// splitUnescaped делит s по sep, пропуская экранированные символы
+// и, если quotes, разделители внутри строк в кавычках.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	parts := splitUnescaped(s, sep, false)
+	if len(parts) < 2 {
+		return s, "", false
+	}
+	return parts[0], s[len(parts[0])+1:], true
+}
+
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// sortedLabels возвращает метки парами ключ-значение в порядке ключей.
+func sortedLabels(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key, labels[key])
+	}
+	return pairs
+}
+
+// eachLine вызывает fn для каждой непустой строки и дополняет ошибку номером строки.
+func eachLine(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
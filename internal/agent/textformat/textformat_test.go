@@ -0,0 +1,85 @@
+package textformat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSimple(t *testing.T) {
+	samples, err := Parse(FormatSimple, []byte("# queue\nQueueDepth gauge 12.5\n\nJobsDone counter 3\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{
+		{ID: "QueueDepth", Type: "gauge", Value: 12.5},
+		{ID: "JobsDone", Type: "counter", Value: 3},
+	}, samples)
+
+	_, err = Parse(FormatSimple, []byte("JobsDone counter 1.5\n"))
+	assert.Error(t, err)
+	_, err = Parse(FormatSimple, []byte("JobsDone histogram 1\n"))
+	assert.Error(t, err)
+}
+
+func TestParsePrometheus(t *testing.T) {
+	content := `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{code="400",method="post"} 3
+# TYPE temperature gauge
+temperature{room="a \"big\" one"} 21.5
+untyped_value NaN
+# TYPE latency histogram
+latency_bucket{le="+Inf"} 10
+latency_sum 4.5
+latency_count 10
+`
+	samples, err := Parse(FormatAuto, []byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{
+		{ID: `http_requests_total{code="200",method="post"}`, Type: "counter", Value: 1027, Cumulative: true},
+		{ID: `http_requests_total{code="400",method="post"}`, Type: "counter", Value: 3, Cumulative: true},
+		{ID: `temperature{room="a \"big\" one"}`, Type: "gauge", Value: 21.5},
+		{ID: `latency_bucket{le="+Inf"}`, Type: "counter", Value: 10, Cumulative: true},
+		{ID: "latency_sum", Type: "counter", Value: 4.5, Cumulative: true},
+		{ID: "latency_count", Type: "counter", Value: 10, Cumulative: true},
+	}, samples)
+
+	_, err = Parse(FormatPrometheus, []byte(`broken{label=value} 1`))
+	assert.Error(t, err)
+}
+
+func TestParseInflux(t *testing.T) {
+	content := `cpu,host=server\ 1,region=eu usage=0.64,cores=8i,busy=t,model="x 1" 1465839830100400200
+disk free=10u
+`
+	samples, err := Parse(FormatAuto, []byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{
+		{ID: `cpu_usage{host="server 1",region="eu"}`, Type: "gauge", Value: 0.64},
+		{ID: `cpu_cores{host="server 1",region="eu"}`, Type: "gauge", Value: 8},
+		{ID: `cpu_busy{host="server 1",region="eu"}`, Type: "gauge", Value: 1},
+		{ID: "disk_free", Type: "gauge", Value: 10},
+	}, samples)
+
+	_, err = Parse(FormatInflux, []byte("cpu usage=abc\n"))
+	assert.Error(t, err)
+}
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, FormatSimple, Detect([]byte("\nA gauge 1\n")))
+	assert.Equal(t, FormatPrometheus, Detect([]byte("a_total 1\n")))
+	assert.Equal(t, FormatPrometheus, Detect([]byte(`a{b="c"} 1`)))
+	assert.Equal(t, FormatInflux, Detect([]byte("cpu,host=a usage=1\n")))
+	assert.Equal(t, FormatInflux, Detect([]byte("cpu usage=1\n")))
+}
+
+func TestParsePrometheusExtraLabels(t *testing.T) {
+	samples, err := ParsePrometheus(strings.NewReader("up 1\nup{target=\"x\",job=\"a\"} 0\n"), "target", "host:1")
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{
+		{ID: `up{target="host:1"}`, Type: "gauge", Value: 1},
+		{ID: `up{exported_target="x",job="a",target="host:1"}`, Type: "gauge", Value: 0},
+	}, samples)
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/instance"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	memstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/mem-storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceFromAgentToStorage(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	saved := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(saved)
+
+	const key = "test-key"
+	privatePath, publicPath := writeKeyPair(t)
+	var storage storages.Storage = memstorage.New("", false)
+	srv := httptest.NewServer(instance.New(":0", &storage, time.Second, key, true, privatePath))
+	defer srv.Close()
+
+	a, err := NewWithConfig(context.Background(), AgentConfig{
+		PollInterval:   configloader.Seconds(1),
+		ReportInterval: configloader.Seconds(1),
+		Destinations: []DestinationConfig{{
+			Address:   strings.TrimPrefix(srv.URL, "http://"),
+			Key:       key,
+			CryptoKey: publicPath,
+		}},
+		Compression: CompressionGzip,
+	})
+	require.NoError(t, err)
+	a.collected([]contracts.Metrics{counter("Jobs", 5)})
+	a.report()
+	drainAll(a)
+	require.Zero(t, a.pending())
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range exporter.GetSpans().Snapshots() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"send batch", "POST /updates/", "POST /updates", "decompress", "verify", "decrypt", "storage.UpdateMetrics"} {
+		require.Contains(t, spans, name)
+	}
+
+	send := spans["send batch"]
+	parents := map[string]string{
+		"POST /updates/":        "send batch",
+		"POST /updates":         "POST /updates/",
+		"decompress":            "POST /updates",
+		"verify":                "POST /updates",
+		"decrypt":               "POST /updates",
+		"storage.UpdateMetrics": "POST /updates",
+	}
+	for name, parent := range parents {
+		span := spans[name]
+		assert.Equal(t, send.SpanContext().TraceID(), span.SpanContext().TraceID(), name)
+		assert.Equal(t, spans[parent].SpanContext().SpanID(), span.Parent().SpanID(), "parent of %s", name)
+	}
+	assert.True(t, spans["POST /updates"].Parent().IsRemote(), "server continues the agent trace")
+}
@@ -0,0 +1,372 @@
+// Package configloader собирает конфигурацию агента и сервера из нескольких слоев:
+// значения по умолчанию < файл (JSON, YAML или TOML) < переменные окружения < флаги.
+//
+// Поля структуры конфигурации описываются тегами:
+//
+//	json   - ключ в файле конфигурации;
+//	env    - имя переменной окружения;
+//	flag   - имя флага командной строки;
+//	usage  - описание флага;
+//	secret - "true", если значение нельзя выводить в лог.
+//
+// Значение считается заданным в слое, только если оно там явно указано,
+// поэтому нулевые значения (например, -r=false) перекрывают нижние слои.
+package configloader
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Source - слой, из которого получено значение параметра.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Sources - источники значений по именам полей структуры конфигурации.
+type Sources map[string]Source
+
+// Loader загружает конфигурацию заданного типа. Флаги регистрируются один раз
+// при создании, а Load можно вызывать повторно, например при SIGHUP.
+type Loader struct {
+	defaults  reflect.Value
+	flags     map[string]*flagValue
+	pathField string
+	// unknown - ключи файла последней загрузки, которым нет поля в конфигурации.
+	unknown []string
+}
+
+// New регистрирует флаги для полей defaults в fs. defaults - указатель на структуру
+// со значениями по умолчанию. pathField - имя поля с путем к файлу конфигурации;
+// сам путь берется из флага, переменной окружения или значения по умолчанию этого поля.
+func New(fs *flag.FlagSet, defaults any, pathField string) *Loader {
+	v := reflect.ValueOf(defaults)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic("configloader: defaults must be a pointer to struct")
+	}
+	if _, ok := v.Elem().Type().FieldByName(pathField); !ok {
+		panic("configloader: unknown config path field " + pathField)
+	}
+
+	l := &Loader{
+		defaults:  v.Elem(),
+		flags:     make(map[string]*flagValue),
+		pathField: pathField,
+	}
+
+	t := l.defaults.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("flag")
+		if len(name) == 0 {
+			continue
+		}
+		fv := &flagValue{
+			def: formatValue(l.defaults.Field(i)),
+			typ: field.Type,
+		}
+		l.flags[field.Name] = fv
+		fs.Var(fv, name, field.Tag.Get("usage"))
+	}
+
+	return l
+}
+
+// Load заполняет dst (указатель на структуру того же типа, что и defaults)
+// и возвращает источник каждого значения. При ошибках dst заполняется
+// всем, что удалось разобрать, а ошибки возвращаются вместе.
+func (l *Loader) Load(dst any) (Sources, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Type() != l.defaults.Type() {
+		return nil, fmt.Errorf("configloader: dst must be *%s", l.defaults.Type())
+	}
+	v = v.Elem()
+	v.Set(l.defaults)
+	l.unknown = nil
+
+	t := v.Type()
+	sources := make(Sources, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sources[t.Field(i).Name] = SourceDefault
+	}
+
+	// Ошибки собираются, а загрузка продолжается, чтобы все проблемы
+	// конфигурации были видны за один запуск.
+	var errs []error
+	path := l.configPath()
+	if len(path) != 0 {
+		if err := l.applyFile(v, path, sources); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		if len(name) == 0 {
+			continue
+		}
+		raw, ok := os.LookupEnv(name)
+		if !ok || len(raw) == 0 {
+			continue
+		}
+		if err := setFromString(v.Field(i), raw); err != nil {
+			errs = append(errs, fmt.Errorf("env %s: %w", name, err))
+			continue
+		}
+		sources[field.Name] = SourceEnv
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv, ok := l.flags[field.Name]
+		if !ok || !fv.set {
+			continue
+		}
+		if err := setFromString(v.Field(i), fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", field.Tag.Get("flag"), err))
+			continue
+		}
+		sources[field.Name] = SourceFlag
+	}
+
+	return sources, errors.Join(errs...)
+}
+
+// configPath возвращает путь к файлу конфигурации: флаг важнее переменной окружения.
+func (l *Loader) configPath() string {
+	if fv, ok := l.flags[l.pathField]; ok && fv.set {
+		return fv.value
+	}
+	field, _ := l.defaults.Type().FieldByName(l.pathField)
+	if path, ok := os.LookupEnv(field.Tag.Get("env")); ok && len(path) != 0 {
+		return path
+	}
+	return l.defaults.FieldByName(l.pathField).String()
+}
+
+// applyFile применяет значения из файла. Формат определяется по расширению.
+func (l *Loader) applyFile(v reflect.Value, path string, sources Sources) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	t := v.Type()
+	known := make(map[string]bool, t.NumField())
+	var errs []error
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := jsonName(field)
+		if len(key) == 0 {
+			continue
+		}
+		known[key] = true
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		// Значение проходит через JSON, чтобы для всех форматов работали
+		// одни и те же правила разбора, включая UnmarshalJSON у полей.
+		raw, err := json.Marshal(value)
+		if err == nil {
+			err = json.Unmarshal(raw, v.Field(i).Addr().Interface())
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %s: %w", path, key, err))
+			continue
+		}
+		sources[field.Name] = SourceFile
+	}
+
+	// Лишние ключи не мешают загрузке: в файле могут остаться устаревшие параметры.
+	for key := range values {
+		if !known[key] {
+			l.unknown = append(l.unknown, key)
+		}
+	}
+	sort.Strings(l.unknown)
+
+	return errors.Join(errs...)
+}
+
+// UnknownKeys возвращает ключи файла конфигурации последней загрузки,
+// которым нет соответствующего поля. Такие ключи игнорируются.
+func (l *Loader) UnknownKeys() []string {
+	return l.unknown
+}
+
+// Describe возвращает итоговую конфигурацию в виде "ключ = значение (источник)"
+// по одному параметру на строку. Секретные значения скрываются.
+func Describe(cfg any, sources Sources) string {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	t := v.Type()
+
+	lines := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		if len(name) == 0 {
+			name = field.Name
+		}
+		value := formatValue(v.Field(i))
+		if field.Tag.Get("secret") == "true" && len(value) != 0 {
+			value = "******"
+		}
+		source := sources[field.Name]
+		if len(source) == 0 {
+			source = SourceDefault
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s (%s)", name, value, source))
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// setFromString разбирает строковое значение из окружения или флага.
+// Списки задаются через запятую.
+func setFromString(v reflect.Value, raw string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		parts := strings.Split(raw, ",")
+		slice := reflect.MakeSlice(v.Type(), 0, len(parts))
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if len(part) == 0 {
+				continue
+			}
+			item := reflect.New(v.Type().Elem()).Elem()
+			if err := setFromString(item, part); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, item)
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	if v.Kind() == reflect.Slice {
+		parts := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, formatValue(v.Index(i)))
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagValue запоминает сырое значение флага и факт его явного указания.
+type flagValue struct {
+	def   string
+	value string
+	set   bool
+	typ   reflect.Type
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	if f.set {
+		return f.value
+	}
+	return f.def
+}
+
+// Set проверяет значение сразу, чтобы ошибки в флагах сообщались при их разборе.
+func (f *flagValue) Set(value string) error {
+	if err := setFromString(reflect.New(f.typ).Elem(), value); err != nil {
+		return err
+	}
+	f.value = value
+	f.set = true
+	return nil
+}
+
+// IsBoolFlag позволяет указывать булевы флаги без значения.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.typ.Kind() == reflect.Bool
+}
@@ -0,0 +1,163 @@
+package configloader
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Address    string   `env:"TEST_ADDRESS" json:"address" flag:"a"`
+	Interval   Duration `env:"TEST_INTERVAL" json:"interval" flag:"i"`
+	Restore    bool     `env:"TEST_RESTORE" json:"restore" flag:"r"`
+	Limit      int      `env:"TEST_LIMIT" json:"limit" flag:"l"`
+	Key        string   `env:"TEST_KEY" json:"key" flag:"k" secret:"true"`
+	Targets    []string `env:"TEST_TARGETS" json:"targets"`
+	ConfigPath string   `env:"TEST_CONFIG" flag:"c"`
+}
+
+func newLoader(t *testing.T, args ...string) *Loader {
+	t.Helper()
+	defaults := testConfig{
+		Address:  "localhost:8080",
+		Interval: Seconds(10),
+		Restore:  true,
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := New(fs, &defaults, "ConfigPath")
+	require.NoError(t, fs.Parse(args))
+	return l
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	var cfg testConfig
+	sources, err := newLoader(t).Load(&cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "localhost:8080", cfg.Address)
+	assert.Equal(t, 10*time.Second, cfg.Interval.Duration)
+	assert.True(t, cfg.Restore)
+	assert.Equal(t, SourceDefault, sources["Address"])
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.json", `{"address":"file:1","interval":"30s","limit":3,"key":"file-key"}`)
+	t.Setenv("TEST_ADDRESS", "env:2")
+	t.Setenv("TEST_LIMIT", "5")
+
+	var cfg testConfig
+	sources, err := newLoader(t, "-c", path, "-a", "flag:3").Load(&cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "flag:3", cfg.Address)
+	assert.Equal(t, SourceFlag, sources["Address"])
+	assert.Equal(t, 5, cfg.Limit)
+	assert.Equal(t, SourceEnv, sources["Limit"])
+	assert.Equal(t, 30*time.Second, cfg.Interval.Duration)
+	assert.Equal(t, SourceFile, sources["Interval"])
+	assert.Equal(t, "file-key", cfg.Key)
+}
+
+func TestLoadExplicitZeroOverrides(t *testing.T) {
+	path := writeFile(t, "config.json", `{"restore":true,"limit":4}`)
+
+	var cfg testConfig
+	sources, err := newLoader(t, "-c", path, "-r=false", "-l", "0").Load(&cfg)
+	require.NoError(t, err)
+
+	assert.False(t, cfg.Restore)
+	assert.Equal(t, SourceFlag, sources["Restore"])
+	assert.Equal(t, 0, cfg.Limit)
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{name: "json", file: "config.json", content: `{"address":"host:1","interval":5,"targets":["a","b"]}`},
+		{name: "yaml", file: "config.yaml", content: "address: host:1\ninterval: 5s\ntargets: [a, b]\n"},
+		{name: "toml", file: "config.toml", content: "address = \"host:1\"\ninterval = \"5s\"\ntargets = [\"a\", \"b\"]\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFile(t, test.file, test.content)
+
+			var cfg testConfig
+			_, err := newLoader(t, "-c", path).Load(&cfg)
+			require.NoError(t, err)
+
+			assert.Equal(t, "host:1", cfg.Address)
+			assert.Equal(t, 5*time.Second, cfg.Interval.Duration)
+			assert.Equal(t, []string{"a", "b"}, cfg.Targets)
+		})
+	}
+}
+
+func TestLoadConfigPathFromEnv(t *testing.T) {
+	path := writeFile(t, "config.json", `{"address":"file:1"}`)
+	t.Setenv("TEST_CONFIG", path)
+
+	var cfg testConfig
+	_, err := newLoader(t).Load(&cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "file:1", cfg.Address)
+}
+
+func TestLoadEnvList(t *testing.T) {
+	t.Setenv("TEST_TARGETS", "a, b,,c")
+
+	var cfg testConfig
+	_, err := newLoader(t).Load(&cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, cfg.Targets)
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := writeFile(t, "config.json", `{"address":"file:1","unknown":1}`)
+	t.Setenv("TEST_LIMIT", "many")
+
+	var cfg testConfig
+	loader := newLoader(t, "-c", path)
+	_, err := loader.Load(&cfg)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "unknown", "unknown keys are not errors")
+	assert.Equal(t, []string{"unknown"}, loader.UnknownKeys())
+	assert.Equal(t, "file:1", cfg.Address)
+	assert.Contains(t, err.Error(), "TEST_LIMIT", "env errors must be reported along with file errors")
+
+	t.Setenv("TEST_CONFIG", "")
+	_, err = newLoader(t).Load(&cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TEST_LIMIT")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	New(fs, &testConfig{}, "ConfigPath")
+	assert.Error(t, fs.Parse([]string{"-i", "soon"}))
+}
+
+func TestDescribeRedactsSecrets(t *testing.T) {
+	cfg := testConfig{Address: "localhost:8080", Key: "secret", Interval: Seconds(2)}
+	out := Describe(cfg, Sources{"Key": SourceEnv})
+
+	assert.Contains(t, out, "key = ****** (env)")
+	assert.Contains(t, out, "address = localhost:8080 (default)")
+	assert.Contains(t, out, "interval = 2s (default)")
+	assert.NotContains(t, out, "secret")
+}
@@ -0,0 +1,53 @@
+package configloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration - интервал в конфигурации. Принимает строки вида "10s" или "1m30s",
+// а также целые числа, которые трактуются как секунды для совместимости
+// со старыми конфигурациями.
+type Duration struct {
+	time.Duration
+}
+
+// Seconds создает Duration из количества секунд.
+func Seconds(n int64) Duration {
+	return Duration{time.Duration(n) * time.Second}
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	if n, err := strconv.ParseInt(string(text), 10, 64); err == nil {
+		*d = Seconds(n)
+		return nil
+	}
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration{time.Duration(v * float64(time.Second))}
+		return nil
+	case string:
+		return d.UnmarshalText([]byte(v))
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+}
@@ -0,0 +1,9 @@
+package contracts
+
+// Заголовки, по которым сервер связывает записи логов с запросом и агентом.
+const (
+	// RequestIDHeader - идентификатор запроса. Если клиент его не передал, сервер создает свой.
+	RequestIDHeader = "X-Request-ID"
+	// AgentIDHeader - идентификатор агента, отправившего запрос.
+	AgentIDHeader = "X-Agent-ID"
+)
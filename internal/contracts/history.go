@@ -0,0 +1,26 @@
+package contracts
+
+import "time"
+
+// RangePoint - значения метрики за один шаг запроса истории.
+type RangePoint struct {
+	// Time - начало шага.
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Sum   float64   `json:"sum"`
+	Count int64     `json:"count"`
+}
+
+// RangeResponse - ответ сервера на запрос истории метрики за период.
+type RangeResponse struct {
+	ID    string `json:"id"`
+	MType string `json:"type"`
+	// Step - шаг точек, пустой, если возвращены исходные значения.
+	Step string `json:"step,omitempty"`
+	// Resolution - интервал уровня истории, из которого взяты точки,
+	// пустой для исходных значений.
+	Resolution string       `json:"resolution,omitempty"`
+	Points     []RangePoint `json:"points"`
+}
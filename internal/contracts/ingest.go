@@ -0,0 +1,24 @@
+package contracts
+
+// Статусы строк потоковой загрузки метрик.
+const (
+	IngestAccepted = "accepted"
+	IngestRejected = "rejected"
+)
+
+// IngestResult - результат обработки одной строки потоковой загрузки метрик.
+type IngestResult struct {
+	// Line - номер строки в теле запроса, начиная с 1.
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Error - причина, по которой метрика отклонена.
+	Error string `json:"error,omitempty"`
+}
+
+// IngestReport - ответ сервера на потоковую загрузку метрик.
+type IngestReport struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []IngestResult `json:"results"`
+}
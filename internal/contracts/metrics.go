@@ -1,5 +1,14 @@
 package contracts
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+)
+
 // Metrics - контракт получения и отправки метрик в json-формате.
 type Metrics struct {
 	ID    string   `json:"id"`
@@ -7,3 +16,46 @@ type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
 }
+
+// Validate проверяет, что у метрики есть имя, известный тип и значение этого типа.
+func (m Metrics) Validate() error {
+	if len(m.ID) == 0 {
+		return errors.New("metric id is empty")
+	}
+	switch m.MType {
+	case consts.Gauge:
+		if m.Value == nil {
+			return fmt.Errorf("gauge %s: value is missing", m.ID)
+		}
+	case consts.Counter:
+		if m.Delta == nil {
+			return fmt.Errorf("counter %s: delta is missing", m.ID)
+		}
+	default:
+		return fmt.Errorf("metric %s: incorrect type %q", m.ID, m.MType)
+	}
+	return nil
+}
+
+// LabelledID формирует идентификатор метрики с метками в виде name{key="value",...}.
+// Метки передаются парами ключ-значение и выводятся в переданном порядке.
+func LabelledID(name string, labels ...string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(labels[i])
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(labels[i+1]))
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
@@ -0,0 +1,43 @@
+package contracts
+
+import "testing"
+
+func TestLabelledID(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		want   string
+	}{
+		{name: "Load", want: "Load"},
+		{name: "NetworkReceivedBytes", labels: []string{"interface", "eth0"}, want: `NetworkReceivedBytes{interface="eth0"}`},
+		{name: "Disk", labels: []string{"device", "sda", "mount", `/a "b"`}, want: `Disk{device="sda",mount="/a \"b\""}`},
+	}
+
+	for _, test := range tests {
+		if got := LabelledID(test.name, test.labels...); got != test.want {
+			t.Errorf("LabelledID() = %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	value := 1.5
+	delta := int64(2)
+	tests := []struct {
+		metric Metrics
+		valid  bool
+	}{
+		{metric: Metrics{ID: "g", MType: "gauge", Value: &value}, valid: true},
+		{metric: Metrics{ID: "c", MType: "counter", Delta: &delta}, valid: true},
+		{metric: Metrics{MType: "gauge", Value: &value}},
+		{metric: Metrics{ID: "g", MType: "gauge"}},
+		{metric: Metrics{ID: "c", MType: "counter", Value: &value}},
+		{metric: Metrics{ID: "h", MType: "histogram", Value: &value}},
+	}
+
+	for _, test := range tests {
+		if err := test.metric.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %t", test.metric, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type fieldsKey struct{}
+
+// WithFields возвращает контекст, записи из которого дополняются парами ключ-значение
+// keysAndValues, как в zap.SugaredLogger.With. Поля накапливаются при повторных вызовах.
+func WithFields(ctx context.Context, keysAndValues ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	merged := make([]any, 0, len(fields)+len(keysAndValues))
+	merged = append(append(merged, fields...), keysAndValues...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FromContext возвращает Logger с полями, сохраненными в ctx через WithFields.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	if len(fields) == 0 {
+		return &Logger
+	}
+	return Logger.With(fields...)
+}
@@ -0,0 +1,135 @@
+// Package logger - общий логгер сервера и агента.
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var Logger zap.SugaredLogger
+
+var level = zap.NewAtomicLevelAt(zap.DebugLevel)
+
+// Форматы вывода.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Config - параметры логгера.
+type Config struct {
+	// Level - уровень логирования, по умолчанию debug.
+	Level string
+	// Format - формат записей: console (по умолчанию) или json.
+	Format string
+	// File - файл для записей. Пустое значение означает stderr.
+	File string
+	// MaxSize - размер файла в мегабайтах, после которого он ротируется. 0 отключает ротацию.
+	MaxSize int
+	// MaxBackups - число хранимых ротированных файлов. 0 означает хранить все.
+	MaxBackups int
+	// Sampling - сколько одинаковых записей в секунду пишется полностью,
+	// из остальных пишется каждая сотая. 0 отключает сэмплирование.
+	Sampling int
+}
+
+// samplingThereafter - из записей сверх Config.Sampling пишется каждая samplingThereafter-я.
+const samplingThereafter = 100
+
+var (
+	mutex sync.Mutex
+	// output - открытый файл текущего логгера, закрывается при перенастройке.
+	output io.Closer
+)
+
+func init() {
+	cfg := zap.NewDevelopmentConfig()
+	cfg.Level = level
+	logger, lerr := cfg.Build()
+	if lerr != nil {
+		panic(lerr)
+	}
+	Logger = *logger.Sugar()
+}
+
+// Validate проверяет параметры логгера.
+func (c Config) Validate() error {
+	var errs []error
+	if len(c.Level) != 0 {
+		if _, err := zapcore.ParseLevel(c.Level); err != nil {
+			errs = append(errs, fmt.Errorf("log level: %w", err))
+		}
+	}
+	switch c.Format {
+	case "", FormatConsole, FormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("unknown log format %q", c.Format))
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 {
+		errs = append(errs, errors.New("log rotation limits must not be negative"))
+	}
+	if c.Sampling < 0 {
+		errs = append(errs, errors.New("log sampling must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Configure перестраивает Logger по cfg. Вызывается при старте процесса,
+// до того как логгер начнут использовать другие горутины.
+// Уровень потом можно менять на лету через SetLevel.
+func Configure(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Level) != 0 {
+		if err := SetLevel(cfg.Level); err != nil {
+			return err
+		}
+	}
+
+	var sink zapcore.WriteSyncer = zapcore.Lock(os.Stderr)
+	var file io.Closer
+	if len(cfg.File) != 0 {
+		rf, err := openRotatingFile(cfg.File, int64(cfg.MaxSize)<<20, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		sink, file = rf, rf
+	}
+
+	core := zapcore.NewCore(newEncoder(cfg.Format), sink, level)
+	if cfg.Sampling > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.Sampling, samplingThereafter)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	Logger.Sync()
+	Logger = *zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel)).Sugar()
+	if output != nil {
+		output.Close()
+	}
+	output = file
+	return nil
+}
+
+func newEncoder(format string) zapcore.Encoder {
+	if format == FormatJSON {
+		cfg := zap.NewProductionEncoderConfig()
+		cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewJSONEncoder(cfg)
+	}
+	return zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+}
+
+// SetLevel меняет уровень логирования на лету.
+func SetLevel(text string) error {
+	return level.UnmarshalText([]byte(text))
+}
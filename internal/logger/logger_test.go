@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// var TestLogger zap.SugaredLogger
+
+func initLogger() {
+	logger, lerr := zap.NewDevelopment()
+	if lerr != nil {
+		panic(lerr)
+	}
+	Logger = *logger.Sugar()
+}
+
+func TestLoggerInitialization(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Logger initialization failed with panic: %v", r)
+		}
+	}()
+
+	initLogger()
+
+	if Logger.Desugar() == nil {
+		t.Errorf("Logger should be initialized, but got nil")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel("debug")
+
+	if err := SetLevel("warn"); err != nil {
+		t.Fatalf("SetLevel failed: %v", err)
+	}
+	if level.Enabled(zap.InfoLevel) {
+		t.Errorf("Info level should be disabled after SetLevel(warn)")
+	}
+
+	if err := SetLevel("loud"); err == nil {
+		t.Errorf("SetLevel should reject unknown level")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(Config{Level: "debug"})
+
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := Configure(Config{Level: "info", Format: FormatJSON, File: path}); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	ctx := WithFields(context.Background(), "request_id", "abc")
+	FromContext(WithFields(ctx, "agent_id", "host-1")).Infow("Request handled", "status", 200)
+	Logger.Debug("hidden")
+	Logger.Sync()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one entry above debug level, got %q", content)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Expected JSON entry, got %q: %v", lines[0], err)
+	}
+	for key, want := range map[string]any{"msg": "Request handled", "request_id": "abc", "agent_id": "host-1", "status": 200.0} {
+		if entry[key] != want {
+			t.Errorf("Expected %s to be %v, got %v", key, want, entry[key])
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (Config{Level: "warn", Format: FormatConsole}).Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	err := (Config{Level: "loud", Format: "xml", MaxSize: -1, Sampling: -1}).Validate()
+	if err == nil || len(err.(interface{ Unwrap() []error }).Unwrap()) != 4 {
+		t.Errorf("Expected every problem to be reported, got %v", err)
+	}
+	if err := Configure(Config{Format: "xml"}); err == nil {
+		t.Errorf("Configure should reject invalid config")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		// Метка времени в имени файла с точностью до миллисекунды.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "agent-*.log"))
+	if len(backups) != 2 {
+		t.Errorf("Expected 2 rotated files to be kept, got %v", backups)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != 10 {
+		t.Errorf("Expected current file to hold the last write, got %v %v", info, err)
+	}
+}
@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat - метка времени в имени ротированного файла.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile - файл логов, который переименовывается с меткой времени,
+// когда его размер превышает maxSize. Хранится не больше maxBackups старых файлов.
+type rotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Sync() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Sync()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+// rotate переименовывает текущий файл, открывает новый и удаляет лишние старые.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext) + "-"
+	if err := os.Rename(f.path, prefix+time.Now().Format(backupTimeFormat)+ext); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	if f.maxBackups == 0 {
+		return nil
+	}
+
+	// Метка времени сортируется как строка, поэтому самые старые файлы идут первыми.
+	backups, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
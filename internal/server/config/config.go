@@ -1,20 +1,353 @@
 package config
 
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	memstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/mem-storage"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+)
+
 // ServerConfig - конфигурация сервера.
 type ServerConfig struct {
 	// Address - адрес хоста сервера.
-	Address string `env:"ADDRESS" json:"address"`
-	// StoreInterval - интервал сохранения метрик в файл.
-	StoreInterval int64 `env:"STORE_INTERVAL" json:"store_interval"`
-	// FileStoragePath - путь к файлу для сохранения метрик.
-	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"store_file"`
+	Address string `env:"ADDRESS" json:"address" flag:"a" usage:"Server endpoint"`
+	// StoreInterval - интервал сохранения метрик в файл. 0 включает синхронный режим:
+	// каждое обновление сбрасывается в журнал на диске до ответа, а снимки
+	// делаются раз в DefaultSyncCheckpointInterval.
+	StoreInterval configloader.Duration `env:"STORE_INTERVAL" json:"store_interval" flag:"i" usage:"Save metrics into file interval (seconds or duration like 5m)"`
+	// FileStoragePath - путь к файлу для сохранения метрик. Пустое значение
+	// отключает сохранение: метрики хранятся только в памяти.
+	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"store_file" flag:"f" usage:"File storage path"`
 	// Restore - признак необходимости восстановления данных из файла.
-	Restore bool `env:"RESTORE" json:"restore"`
+	Restore bool `env:"RESTORE" json:"restore" flag:"r" usage:"Restore from file flag"`
+	// WALSync - политика журнала изменений файлового хранилища: off, always или interval.
+	// Пустое значение означает interval, если задан FileStoragePath, и off иначе.
+	WALSync string `env:"WAL_SYNC" json:"wal_sync" flag:"wal-sync" usage:"Write-ahead log sync policy: off, always or interval"`
+	// WALSyncInterval - интервал сброса журнала на диск при политике interval.
+	WALSyncInterval configloader.Duration `env:"WAL_SYNC_INTERVAL" json:"wal_sync_interval" flag:"wal-sync-interval" usage:"Write-ahead log sync interval (duration like 1s)"`
+	// BoltPath - файл встроенной базы bbolt. Если задан и DatabaseDSN пуст, метрики
+	// хранятся в нем вместо файла FileStoragePath.
+	BoltPath string `env:"BOLT_PATH" json:"bolt_path" flag:"bolt-path" usage:"Embedded bbolt database file"`
 	// DatabaseDSN - строка подключения к базе данных.
-	DatabaseDSN string `env:"DATABASE_DSN" json:"database_dsn"`
+	DatabaseDSN string `env:"DATABASE_DSN" json:"database_dsn" flag:"d" usage:"DB connection string" secret:"true"`
 	// Key - ключ шифрования передаваемых данных.
-	Key string `env:"KEY" json:"key"`
+	Key string `env:"KEY" json:"key" flag:"k" usage:"Secret key" secret:"true"`
+	// HashRequired - признак того, что запросы на запись без подписи отклоняются.
+	HashRequired bool `env:"HASH_REQUIRED" json:"hash_required" flag:"hash-required" usage:"Reject unsigned write requests"`
 	// CryptoKey - путь до файла с приватным ключом
-	CryptoKey  string `env:"CRYPTO_KEY" json:"crypto_key"`
-	ConfigPath string `env:"CONFIG"`
+	CryptoKey string `env:"CRYPTO_KEY" json:"crypto_key" flag:"crypto-key" usage:"Private key"`
+	// CompressionMinSize - минимальный размер ответа в байтах, с которого он сжимается.
+	CompressionMinSize int `env:"COMPRESSION_MIN_SIZE" json:"compression_min_size" flag:"compression-min-size" usage:"Min response size to compress, bytes"`
+	// CompressionTypes - типы содержимого сжимаемых ответов, text/* разрешает все подтипы.
+	CompressionTypes []string `env:"COMPRESSION_TYPES" json:"compression_types" flag:"compression-types" usage:"Comma separated compressible content types"`
+	// MaxBodySize - максимальный размер распакованного тела запроса в байтах.
+	MaxBodySize int64 `env:"MAX_BODY_SIZE" json:"max_body_size" flag:"max-body-size" usage:"Max request body size after decompression, bytes"`
+	// ReadinessTimeout - время на одну проверку готовности /readyz.
+	ReadinessTimeout configloader.Duration `env:"READINESS_TIMEOUT" json:"readiness_timeout" flag:"readiness-timeout" usage:"Readiness check timeout (seconds or duration like 2s)"`
+	// LogLevel - уровень логирования.
+	LogLevel string `env:"LOG_LEVEL" json:"log_level" flag:"log-level" usage:"Log level"`
+	// LogFormat - формат логов: console или json.
+	LogFormat string `env:"LOG_FORMAT" json:"log_format" flag:"log-format" usage:"Log format: console or json"`
+	// LogFile - файл логов. Пустое значение означает stderr.
+	LogFile string `env:"LOG_FILE" json:"log_file" flag:"log-file" usage:"Log file (stderr if empty)"`
+	// LogMaxSize - размер файла логов в мегабайтах, после которого он ротируется.
+	LogMaxSize int `env:"LOG_MAX_SIZE" json:"log_max_size" flag:"log-max-size" usage:"Log file size to rotate at, megabytes (0 disables rotation)"`
+	// LogMaxBackups - число хранимых ротированных файлов логов.
+	LogMaxBackups int `env:"LOG_MAX_BACKUPS" json:"log_max_backups" flag:"log-max-backups" usage:"Rotated log files to keep (0 keeps all)"`
+	// LogSampling - число одинаковых записей в секунду, после которого логи сэмплируются.
+	LogSampling int `env:"LOG_SAMPLING" json:"log_sampling" flag:"log-sampling" usage:"Identical log entries per second before sampling (0 disables)"`
+	// HistoryRetention - срок хранения истории значений метрик, не подошедших ни под одно
+	// правило Retention. 0 означает хранить бессрочно.
+	HistoryRetention configloader.Duration `env:"HISTORY_RETENTION" json:"history_retention" flag:"history-retention" usage:"Metric history retention (duration like 24h, 0 keeps forever)"`
+	// Retention - сроки хранения истории по шаблонам имен метрик. Применяется первое
+	// подошедшее правило. Задаются только в файле конфигурации.
+	RetentionRules []RetentionRule `json:"retention"`
+	// StaleAfter - метрики, которые не обновлялись дольше, удаляются вместе с историей.
+	// 0 отключает удаление.
+	StaleAfter configloader.Duration `env:"STALE_AFTER" json:"stale_after" flag:"stale-after" usage:"Delete metrics not updated for this long (0 disables)"`
+	// RollupTiers - уровни свернутой истории в виде "интервал:срок", например "1m:720h".
+	// Интервалы идут по возрастанию, каждый кратен предыдущему. Срок 0 означает хранить бессрочно.
+	RollupTiers []string `env:"ROLLUP_TIERS" json:"rollup_tiers" flag:"rollup-tiers" usage:"Comma separated history rollup tiers as resolution:keep"`
+	// JanitorInterval - интервал свертки истории и удаления устаревших данных.
+	JanitorInterval configloader.Duration `env:"JANITOR_INTERVAL" json:"janitor_interval" flag:"janitor-interval" usage:"Expired data cleanup interval (duration like 1m)"`
+	// TraceExporter - экспорт спанов трассировки: none, otlp или file.
+	TraceExporter string `env:"TRACE_EXPORTER" json:"trace_exporter" flag:"trace-exporter" usage:"Trace exporter: none, otlp or file"`
+	// TraceEndpoint - URL коллектора OTLP/HTTP.
+	TraceEndpoint string `env:"TRACE_ENDPOINT" json:"trace_endpoint" flag:"trace-endpoint" usage:"OTLP/HTTP collector URL"`
+	// TraceFile - файл спанов для экспортера file.
+	TraceFile string `env:"TRACE_FILE" json:"trace_file" flag:"trace-file" usage:"Trace file for the file exporter"`
+	// TraceSampleRatio - доля записываемых трасс от 0 до 1.
+	TraceSampleRatio float64 `env:"TRACE_SAMPLE_RATIO" json:"trace_sample_ratio" flag:"trace-sample-ratio" usage:"Sampled traces ratio (0..1)"`
+	ConfigPath       string  `env:"CONFIG" flag:"c" usage:"Config path (JSON, YAML or TOML)"`
+}
+
+// DefaultConfig возвращает конфигурацию сервера по умолчанию.
+func DefaultConfig() ServerConfig {
+	return ServerConfig{
+		Address:          "localhost:8080",
+		StoreInterval:    configloader.Seconds(300),
+		FileStoragePath:  "./metrics.json",
+		Restore:          true,
+		WALSyncInterval:  configloader.Duration{Duration: DefaultWALSyncInterval},
+		MaxBodySize:      DefaultMaxBodySize,
+		LogLevel:         "debug",
+		LogFormat:        logger.FormatConsole,
+		HistoryRetention: configloader.Duration{Duration: DefaultHistoryRetention},
+		RollupTiers:      DefaultRollupTiers(),
+		JanitorInterval:  configloader.Duration{Duration: DefaultJanitorInterval},
+		TraceExporter:    tracing.ExporterNone,
+		TraceSampleRatio: 1,
+	}
+}
+
+// Tracing возвращает параметры трассировки.
+func (c ServerConfig) Tracing() tracing.Config {
+	return tracing.Config{
+		ServiceName: "metrics-server",
+		Exporter:    c.TraceExporter,
+		Endpoint:    c.TraceEndpoint,
+		File:        c.TraceFile,
+		SampleRatio: c.TraceSampleRatio,
+	}
+}
+
+// Logging возвращает параметры логгера сервера.
+func (c ServerConfig) Logging() logger.Config {
+	return logger.Config{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		File:       c.LogFile,
+		MaxSize:    c.LogMaxSize,
+		MaxBackups: c.LogMaxBackups,
+		Sampling:   c.LogSampling,
+	}
+}
+
+// DefaultMaxBodySize - максимальный размер тела запроса по умолчанию.
+const DefaultMaxBodySize = 10 << 20
+
+// DefaultSyncCheckpointInterval - интервал снимков файлового хранилища в синхронном режиме,
+// когда обновления и так сохраняются в журнале.
+const DefaultSyncCheckpointInterval = 5 * time.Minute
+
+// DefaultWALSyncInterval - интервал сброса журнала изменений на диск по умолчанию.
+const DefaultWALSyncInterval = time.Second
+
+// DefaultHistoryRetention - срок хранения истории значений метрик по умолчанию.
+const DefaultHistoryRetention = 24 * time.Hour
+
+// DefaultJanitorInterval - интервал удаления устаревших данных по умолчанию.
+const DefaultJanitorInterval = time.Minute
+
+// DefaultRollupTiers возвращает уровни свернутой истории по умолчанию: минутные
+// интервалы хранятся 30 дней, часовые - год.
+func DefaultRollupTiers() []string {
+	return []string{"1m:720h", "1h:8760h"}
+}
+
+// parseTier разбирает уровень свернутой истории в виде "интервал:срок".
+func parseTier(s string) (storages.Tier, error) {
+	resolution, keep, ok := strings.Cut(s, ":")
+	if !ok {
+		return storages.Tier{}, fmt.Errorf("rollup tier %q must be in resolution:keep form", s)
+	}
+	var tier storages.Tier
+	var err error
+	if tier.Resolution, err = time.ParseDuration(strings.TrimSpace(resolution)); err != nil {
+		return storages.Tier{}, fmt.Errorf("rollup tier %q: %w", s, err)
+	}
+	if tier.Keep, err = time.ParseDuration(strings.TrimSpace(keep)); err != nil {
+		return storages.Tier{}, fmt.Errorf("rollup tier %q: %w", s, err)
+	}
+	return tier, nil
+}
+
+// Tiers возвращает уровни свернутой истории. Неразборчивые уровни пропускаются,
+// их находит Validate.
+func (c ServerConfig) Tiers() []storages.Tier {
+	tiers := make([]storages.Tier, 0, len(c.RollupTiers))
+	for _, s := range c.RollupTiers {
+		if tier, err := parseTier(s); err == nil {
+			tiers = append(tiers, tier)
+		}
+	}
+	return tiers
+}
+
+// RetentionRule - срок хранения истории метрик, имена которых подходят под шаблон.
+type RetentionRule struct {
+	// Pattern - шаблон имени метрики в синтаксисе path.Match, например "cpu_*".
+	Pattern string `json:"pattern"`
+	// Type - gauge или counter. Пустое значение подходит к обоим типам.
+	Type string `json:"type"`
+	// Keep - срок хранения истории, 0 означает хранить бессрочно.
+	Keep configloader.Duration `json:"keep"`
+}
+
+// MemStorage возвращает параметры надежности файлового хранилища.
+// При StoreInterval 0 журнал сбрасывается на диск при каждом обновлении
+// независимо от WALSync. Без WALSync журнал ведется только при заданном
+// FileStoragePath.
+func (c ServerConfig) MemStorage() memstorage.Options {
+	opts := memstorage.Options{
+		Sync:         c.WALSync,
+		SyncInterval: c.WALSyncInterval.Duration,
+	}
+	switch {
+	case c.StoreInterval.Duration == 0:
+		opts.Sync = memstorage.SyncAlways
+	case len(c.WALSync) != 0:
+	case len(c.FileStoragePath) != 0:
+		opts.Sync = memstorage.SyncInterval
+		if opts.SyncInterval <= 0 {
+			opts.SyncInterval = DefaultWALSyncInterval
+		}
+	default:
+		opts.Sync = memstorage.SyncOff
+	}
+	return opts
+}
+
+// Retention возвращает сроки хранения истории и текущих значений метрик.
+func (c ServerConfig) Retention() storages.Retention {
+	retention := storages.Retention{
+		History:    c.HistoryRetention.Duration,
+		StaleAfter: c.StaleAfter.Duration,
+		Tiers:      c.Tiers(),
+	}
+	for _, rule := range c.RetentionRules {
+		retention.Rules = append(retention.Rules, storages.RetentionRule{
+			Pattern: rule.Pattern,
+			Type:    rule.Type,
+			Keep:    rule.Keep.Duration,
+		})
+	}
+	return retention
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные проблемы разом.
+func (c ServerConfig) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		errs = append(errs, fmt.Errorf("address %q must be in host:port form", c.Address))
+	}
+	if c.StoreInterval.Duration < 0 {
+		errs = append(errs, errors.New("store interval must not be negative"))
+	}
+	if len(c.DatabaseDSN) == 0 && len(c.BoltPath) != 0 {
+		if info, err := os.Stat(filepath.Dir(c.BoltPath)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("bolt database directory %q does not exist", filepath.Dir(c.BoltPath)))
+		}
+	} else if len(c.DatabaseDSN) == 0 {
+		if len(c.FileStoragePath) == 0 {
+			// Без файла метрики хранятся только в памяти, но не тогда,
+			// когда явно запрошено их надежное сохранение.
+			if c.StoreInterval.Duration == 0 {
+				errs = append(errs, errors.New("synchronous store (store interval 0) needs a file storage path"))
+			} else if len(c.WALSync) != 0 && c.WALSync != memstorage.SyncOff {
+				errs = append(errs, fmt.Errorf("WAL sync policy %q needs a file storage path", c.WALSync))
+			}
+		} else if info, err := os.Stat(filepath.Dir(c.FileStoragePath)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("file storage directory %q does not exist", filepath.Dir(c.FileStoragePath)))
+		}
+	}
+	switch c.WALSync {
+	case "", memstorage.SyncOff, memstorage.SyncAlways:
+	case memstorage.SyncInterval:
+		if c.WALSyncInterval.Duration <= 0 {
+			errs = append(errs, errors.New("WAL sync interval must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown WAL sync policy %q", c.WALSync))
+	}
+	if c.HistoryRetention.Duration < 0 || c.StaleAfter.Duration < 0 || c.JanitorInterval.Duration < 0 {
+		errs = append(errs, errors.New("retention durations must not be negative"))
+	}
+	for i, rule := range c.RetentionRules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil || len(rule.Pattern) == 0 {
+			errs = append(errs, fmt.Errorf("retention[%d]: invalid pattern %q", i, rule.Pattern))
+		}
+		if rule.Type != "" && rule.Type != consts.Gauge && rule.Type != consts.Counter {
+			errs = append(errs, fmt.Errorf("retention[%d]: unknown metric type %q", i, rule.Type))
+		}
+		if rule.Keep.Duration < 0 {
+			errs = append(errs, fmt.Errorf("retention[%d]: keep must not be negative", i))
+		}
+	}
+	var previous time.Duration
+	for i, s := range c.RollupTiers {
+		tier, err := parseTier(s)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+			continue
+		case tier.Resolution < time.Second || tier.Resolution%time.Second != 0:
+			errs = append(errs, fmt.Errorf("rollup tier %q: resolution must be a whole number of seconds", s))
+		case previous != 0 && (tier.Resolution <= previous || tier.Resolution%previous != 0):
+			errs = append(errs, fmt.Errorf("rollup tier %q: resolution must be a multiple of the previous tier %s", s, previous))
+		case tier.Keep < 0:
+			errs = append(errs, fmt.Errorf("rollup tier %q: keep must not be negative", s))
+		}
+		if i == 0 || tier.Resolution > previous {
+			previous = tier.Resolution
+		}
+	}
+	if c.ReadinessTimeout.Duration < 0 {
+		errs = append(errs, errors.New("readiness timeout must not be negative"))
+	}
+	if c.MaxBodySize < 0 {
+		errs = append(errs, errors.New("max body size must not be negative"))
+	}
+	if c.CompressionMinSize < 0 {
+		errs = append(errs, errors.New("compression min size must not be negative"))
+	}
+	for _, contentType := range c.CompressionTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil && !strings.HasSuffix(contentType, "/*") {
+			errs = append(errs, fmt.Errorf("compression type %q: %w", contentType, err))
+		}
+	}
+	if len(c.CryptoKey) != 0 {
+		if _, err := LoadPrivateKey(c.CryptoKey); err != nil {
+			errs = append(errs, fmt.Errorf("crypto key: %w", err))
+		}
+	}
+	if err := c.Logging().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// LoadPrivateKey читает приватный RSA-ключ в формате PEM (PKCS #1).
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	privateKeyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyBlock, _ := pem.Decode(privateKeyPEM)
+	if privateKeyBlock == nil {
+		return nil, errors.New("not a PEM file: " + path)
+	}
+
+	return x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
 }
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPath := filepath.Join(dir, "private.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	valid := ServerConfig{
+		Address:          "localhost:8080",
+		StoreInterval:    configloader.Seconds(300),
+		FileStoragePath:  filepath.Join(dir, "metrics.json"),
+		CryptoKey:        keyPath,
+		LogLevel:         "warn",
+		CompressionTypes: []string{"application/json", "text/*"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	invalid := ServerConfig{
+		Address:            "localhost",
+		StoreInterval:      configloader.Seconds(-1),
+		FileStoragePath:    "/not/exists/metrics.json",
+		CryptoKey:          filepath.Join(dir, "missing.pem"),
+		LogLevel:           "loud",
+		LogFormat:          "xml",
+		CompressionMinSize: -1,
+		CompressionTypes:   []string{"application/json; charset"},
+	}
+	err = invalid.Validate()
+	if err == nil {
+		t.Fatalf("Expected invalid config")
+	}
+	problems := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(problems) != 7 {
+		t.Errorf("Expected every problem to be reported, got %d: %v", len(problems), err)
+	}
+
+	withDB := ServerConfig{Address: ":8080", DatabaseDSN: "postgres://localhost/db"}
+	if err := withDB.Validate(); err != nil {
+		t.Errorf("Expected file storage path to be ignored with DSN, got %v", err)
+	}
+}
+
+func TestLoadPrivateKeyNotPEM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(path, []byte("not a key"), 0600)
+
+	_, err := LoadPrivateKey(path)
+	if err == nil || !strings.Contains(err.Error(), "not a PEM file") {
+		t.Errorf("Expected PEM error, got %v", err)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected file to exist")
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "metrics.json")
+	cfg.RetentionRules = []RetentionRule{
+		{Pattern: "cpu_*", Type: "gauge", Keep: configloader.Seconds(3600)},
+		{Pattern: "*"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	retention := cfg.Retention()
+	if len(retention.Rules) != 2 || retention.HistoryTTL("gauge", "cpu_user").Hours() != 1 || retention.History != DefaultHistoryRetention {
+		t.Errorf("Unexpected retention %+v", retention)
+	}
+
+	cfg.StaleAfter = configloader.Seconds(-1)
+	cfg.RetentionRules = []RetentionRule{
+		{Pattern: "[", Type: "gauge"},
+		{Pattern: "cpu", Type: "histogram", Keep: configloader.Seconds(-1)},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("Expected invalid config")
+	}
+	if problems := err.(interface{ Unwrap() []error }).Unwrap(); len(problems) != 4 {
+		t.Errorf("Expected every problem to be reported, got %d: %v", len(problems), err)
+	}
+}
+
+func TestValidateRollupTiers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "metrics.json")
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	tiers := cfg.Retention().Tiers
+	if len(tiers) != 2 || tiers[0] != (storages.Tier{Resolution: time.Minute, Keep: 720 * time.Hour}) || tiers[1].Resolution != time.Hour {
+		t.Errorf("Unexpected tiers %+v", tiers)
+	}
+
+	cfg.RollupTiers = []string{"1m", "500ms:1h", "1m:1h", "90s:1h", "1h:-1h"}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("Expected invalid config")
+	}
+	if problems := err.(interface{ Unwrap() []error }).Unwrap(); len(problems) != 4 {
+		t.Errorf("Expected every problem to be reported, got %d: %v", len(problems), err)
+	}
+}
+
+func TestValidateWAL(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "metrics.json")
+	if opts := cfg.MemStorage(); opts.Sync != "interval" || opts.SyncInterval != DefaultWALSyncInterval {
+		t.Errorf("Unexpected default WAL options %+v", opts)
+	}
+
+	cfg.WALSync = "interval"
+	cfg.WALSyncInterval = configloader.Seconds(0)
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected zero sync interval to be rejected")
+	}
+	cfg.WALSync = "sometimes"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "sometimes") {
+		t.Errorf("Expected unknown policy to be rejected, got %v", err)
+	}
+}
+
+func TestValidateInMemoryOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileStoragePath = ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected empty file storage path to keep metrics in memory, got %v", err)
+	}
+	if opts := cfg.MemStorage(); opts.Sync != "off" {
+		t.Errorf("Expected WAL to be off without a file, got %+v", opts)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		modify func(*ServerConfig)
+	}{
+		{"sync store", func(c *ServerConfig) { c.StoreInterval = configloader.Seconds(0) }},
+		{"wal always", func(c *ServerConfig) { c.WALSync = "always" }},
+		{"wal interval", func(c *ServerConfig) { c.WALSync = "interval" }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			tt.modify(&c)
+			if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "file storage path") {
+				t.Errorf("Expected missing file storage path to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateBoltPath(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileStoragePath = ""
+	cfg.BoltPath = filepath.Join(t.TempDir(), "metrics.db")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected bolt storage to replace the file storage, got %v", err)
+	}
+	cfg.BoltPath = filepath.Join(t.TempDir(), "missing", "metrics.db")
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "bolt") {
+		t.Errorf("Expected missing bolt directory to be rejected, got %v", err)
+	}
+}
@@ -1,22 +1,26 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/evildead81/metrics-and-alerts/internal/contracts"
-	hash "github.com/evildead81/metrics-and-alerts/internal/hash"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
 	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
-	"github.com/evildead81/metrics-and-alerts/internal/server/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/server/middlewares"
 	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
 )
 
 // UpdateMetricByParamsHandler - обновляет метрику, переданную в строке запроса.
@@ -49,74 +53,25 @@ func UpdateMetricByParamsHandler(storage storages.Storage) http.HandlerFunc {
 }
 
 // UpdateMetricByJSONHandler обновляет метрику, переданную в body в формате JSON.
-func UpdateMetricByJSONHandler(storage storages.Storage, key string, privateKey *rsa.PrivateKey) http.HandlerFunc {
+func UpdateMetricByJSONHandler(storage storages.Storage, privateKey *rsa.PrivateKey) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		var reader io.ReadCloser
-		var err error
-
-		if r.Header.Get("Content-Encoding") == "gzip" {
-			reader, err = gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(rw, "failed to read gzip body", http.StatusBadRequest)
-				logger.Logger.Error(err.Error())
-				return
-			}
-			defer reader.Close()
-		} else {
-			reader = r.Body
-		}
-
-		encryptedData, err := io.ReadAll(reader)
+		decryptedData, err := readBody(r, privateKey)
 		if err != nil {
-			http.Error(rw, "failed to read request body", http.StatusBadRequest)
-			logger.Logger.Error(err.Error())
+			http.Error(rw, err.Error(), middlewares.BodyErrorStatus(err))
+			logger.FromContext(r.Context()).Error(err.Error())
 			return
 		}
 
-		var decryptedData []byte
-		if privateKey != nil {
-			decryptedData, err = rsa.DecryptPKCS1v15(reader, privateKey, encryptedData)
-			if err != nil {
-				http.Error(rw, err.Error(), http.StatusBadRequest)
-				logger.Logger.Error(err.Error())
-				return
-			}
-		} else {
-			decryptedData = encryptedData
-		}
-
 		var metric contracts.Metrics
 		if err := json.NewDecoder(bytes.NewReader(decryptedData)).Decode(&metric); err != nil {
 			http.Error(rw, fmt.Sprintf("failed to decode JSON: %v", err), http.StatusBadRequest)
-			logger.Logger.Error(err.Error())
+			logger.FromContext(r.Context()).Error(err.Error())
 			return
 		}
-
-		if len(key) != 0 {
-			var buf bytes.Buffer
-			_, err := buf.ReadFrom(reader)
-			if err != nil {
-				http.Error(rw, err.Error(), http.StatusBadRequest)
-				logger.Logger.Error(err.Error())
-				return
-			}
-			hashReqHeaderVal := r.Header.Get(hash.HashHeaderKey)
-
-			if len(hashReqHeaderVal) != 0 {
-
-				hashedRequest, err := hash.Hash(buf.Bytes(), key)
-				if err != nil {
-					http.Error(rw, err.Error(), http.StatusBadRequest)
-					logger.Logger.Error(err.Error())
-					return
-				}
-
-				if hashReqHeaderVal != hashedRequest {
-					http.Error(rw, "Incorrect hash header", http.StatusBadRequest)
-					logger.Logger.Error("Incorrect hash header")
-					return
-				}
-			}
+		if err := metric.Validate(); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			logger.FromContext(r.Context()).Error(err.Error())
+			return
 		}
 
 		newMetric := contracts.Metrics{
@@ -129,7 +84,7 @@ func UpdateMetricByJSONHandler(storage storages.Storage, key string, privateKey
 			err := storage.UpdateGauge(metric.ID, *metric.Value)
 			if err != nil {
 				http.Error(rw, "Server error", http.StatusInternalServerError)
-				logger.Logger.Error(err.Error())
+				logger.FromContext(r.Context()).Error(err.Error())
 				return
 			}
 			newMetric.Value = metric.Value
@@ -137,38 +92,31 @@ func UpdateMetricByJSONHandler(storage storages.Storage, key string, privateKey
 			err := storage.UpdateCounter(metric.ID, *metric.Delta)
 			if err != nil {
 				http.Error(rw, "Server error", http.StatusInternalServerError)
-				logger.Logger.Error(err.Error())
+				logger.FromContext(r.Context()).Error(err.Error())
 				return
 			}
 			updatedCounterValue, err := storage.GetCountValueByName(metric.ID)
 			if err != nil {
 				http.Error(rw, "Server error", http.StatusInternalServerError)
-				logger.Logger.Error(err.Error())
+				logger.FromContext(r.Context()).Error(err.Error())
 				return
 			}
 			newMetric.Delta = &updatedCounterValue
 		default:
 			http.Error(rw, "Incorrect type", http.StatusBadRequest)
-			logger.Logger.Error("Incorrect type")
+			logger.FromContext(r.Context()).Error("Incorrect type")
 			return
 		}
 
 		bytes, err := json.MarshalIndent(newMetric, "", "   ")
 		if err != nil {
 			http.Error(rw, "Server error", http.StatusInternalServerError)
-			logger.Logger.Error(err.Error())
+			logger.FromContext(r.Context()).Error(err.Error())
 			return
 		}
 
-		hashedResponse, err := hash.Hash(bytes, key)
-		if err != nil {
-			http.Error(rw, err.Error(), http.StatusInternalServerError)
-			logger.Logger.Error(err.Error())
-			return
-		}
 		rw.Header().Add("Content-type", "application/json")
 		rw.Header().Add("Accept-Encoding", "gzip")
-		rw.Header().Add(hash.HashHeaderKey, hashedResponse)
 		rw.WriteHeader(http.StatusOK)
 		rw.Write(bytes)
 	}
@@ -282,6 +230,98 @@ func GetPageHandler(storage storages.Storage) http.HandlerFunc {
 	}
 }
 
+// maxRangePoints - наибольшее число шагов в одном запросе истории.
+const maxRangePoints = 11000
+
+// RangeHandler возвращает историю метрики за период from-to с шагом step.
+// from и to задаются в RFC 3339 или секундах Unix, по умолчанию это последний час.
+// Без step возвращаются исходные значения, иначе точки берутся с самого крупного
+// уровня из tiers, интервал которого не больше step.
+func RangeHandler(storage storages.Storage, tiers []storages.Tier) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		metricType := r.PathValue("metricType")
+		metricName := r.PathValue("metricName")
+		if metricType != consts.Gauge && metricType != consts.Counter {
+			http.Error(rw, "Incorrect type", http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		to, err := parseRangeTime(query.Get("to"), time.Now())
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+		from, err := parseRangeTime(query.Get("from"), to.Add(-time.Hour))
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !from.Before(to) {
+			http.Error(rw, "from must be before to", http.StatusBadRequest)
+			return
+		}
+		var step time.Duration
+		if s := query.Get("step"); len(s) != 0 {
+			if step, err = time.ParseDuration(s); err != nil || step <= 0 {
+				http.Error(rw, fmt.Sprintf("invalid step %q", s), http.StatusBadRequest)
+				return
+			}
+			if to.Sub(from)/step > maxRangePoints {
+				http.Error(rw, fmt.Sprintf("step is too small, at most %d points are returned", maxRangePoints), http.StatusBadRequest)
+				return
+			}
+		}
+
+		rollups, resolution, err := storages.Range(r.Context(), storage, tiers, metricType, metricName, from, to, step)
+		if errors.Is(err, storages.ErrNoHistory) || errors.Is(err, storages.ErrNoRollups) {
+			http.Error(rw, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			logger.FromContext(r.Context()).Errorw("Failed to query metric range", "id", metricName, "error", err.Error())
+			http.Error(rw, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		response := contracts.RangeResponse{
+			ID:     metricName,
+			MType:  metricType,
+			Points: make([]contracts.RangePoint, len(rollups)),
+		}
+		if step != 0 {
+			response.Step = step.String()
+		}
+		if resolution != 0 {
+			response.Resolution = resolution.String()
+		}
+		for i, r := range rollups {
+			response.Points[i] = contracts.RangePoint{
+				Time: r.Time.UTC(), Min: r.Min, Max: r.Max, Avg: r.Avg(), Sum: r.Sum, Count: r.Count,
+			}
+		}
+		body, err := json.Marshal(response)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(body)
+	}
+}
+
+// parseRangeTime разбирает время в RFC 3339 или секундах Unix. Пустая строка дает def.
+func parseRangeTime(s string, def time.Time) (time.Time, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 // Ping проверяет доступность хранилища.
 func Ping(storage storages.Storage) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
@@ -296,49 +336,35 @@ func Ping(storage storages.Storage) http.HandlerFunc {
 }
 
 // UpdateMetrics обновляет список метрик, переданных в body в формате JSON.
-func UpdateMetrics(storage storages.Storage, key string, privateKey *rsa.PrivateKey) http.HandlerFunc {
+// Тело с типом application/x-ndjson обрабатывается построчно, см. updateMetricsStream.
+func UpdateMetrics(storage storages.Storage, privateKey *rsa.PrivateKey) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var reader io.ReadCloser
-		var err error
-
-		if r.Header.Get("Content-Encoding") == "gzip" {
-			reader, err = gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "failed to read gzip body", http.StatusBadRequest)
-				return
-			}
-			defer reader.Close()
-		} else {
-			reader = r.Body
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == NDJSONContentType {
+			updateMetricsStream(w, r, storage, privateKey)
+			return
 		}
 
-		defer reader.Close()
-
-		encryptedData, err := io.ReadAll(reader)
+		decryptedData, err := readBody(r, privateKey)
 		if err != nil {
-			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			http.Error(w, err.Error(), middlewares.BodyErrorStatus(err))
+			logger.FromContext(r.Context()).Error(err.Error())
 			return
 		}
 
-		var decryptedData []byte
-		if privateKey != nil {
-			decryptedData, err = rsa.DecryptPKCS1v15(rand.Reader, privateKey, encryptedData)
-			if err != nil {
-				http.Error(w, "failed to decrypt data", http.StatusBadRequest)
-				return
-			}
-		} else {
-			decryptedData = encryptedData
-		}
-
 		var metrics []contracts.Metrics
 		if err := json.NewDecoder(bytes.NewReader(decryptedData)).Decode(&metrics); err != nil {
 			http.Error(w, fmt.Sprintf("failed to decode JSON: %v", err), http.StatusBadRequest)
 			return
 		}
+		for i, metric := range metrics {
+			if err := metric.Validate(); err != nil {
+				http.Error(w, fmt.Sprintf("metric %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
 
-		if err := storage.UpdateMetrics(metrics); err != nil {
-			fmt.Println("UPDATE METRICS ERROR", err)
+		if err := storages.UpdateMetricsContext(r.Context(), storage, metrics); err != nil {
+			logger.FromContext(r.Context()).Error(err.Error())
 			http.Error(w, fmt.Sprintf("failed to update metrics: %v", err), http.StatusInternalServerError)
 			return
 		}
@@ -346,3 +372,127 @@ func UpdateMetrics(storage storages.Storage, key string, privateKey *rsa.Private
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// NDJSONContentType - тип тела потоковой загрузки метрик: по одной метрике JSON в строке.
+const NDJSONContentType = "application/x-ndjson"
+
+// ndjsonChunkSize - сколько корректных метрик потоковой загрузки записывается в хранилище разом.
+const ndjsonChunkSize = 100
+
+// updateMetricsStream читает метрики по строкам, проверяет каждую и записывает
+// корректные в хранилище частями по ndjsonChunkSize. Пустые строки пропускаются.
+// В ответ отправляется отчет с результатом по каждой строке. Если тело превышает
+// допустимый размер, уже записанные метрики остаются, а отчет уходит с кодом 413.
+// Зашифрованное или подписанное тело читается целиком до разбора: расшифровка
+// и проверка подписи требуют всего тела.
+func updateMetricsStream(w http.ResponseWriter, r *http.Request, storage storages.Storage, privateKey *rsa.PrivateKey) {
+	var body io.Reader = r.Body
+	if privateKey != nil {
+		decryptedData, err := readBody(r, privateKey)
+		if err != nil {
+			http.Error(w, err.Error(), middlewares.BodyErrorStatus(err))
+			logger.FromContext(r.Context()).Error(err.Error())
+			return
+		}
+		body = bytes.NewReader(decryptedData)
+	}
+
+	report := contracts.IngestReport{Results: []contracts.IngestResult{}}
+	var chunk []contracts.Metrics
+	var chunkResults []int
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		if err := storages.UpdateMetricsContext(r.Context(), storage, chunk); err != nil {
+			logger.FromContext(r.Context()).Error(err.Error())
+			for _, i := range chunkResults {
+				report.Results[i].Status = contracts.IngestRejected
+				report.Results[i].Error = fmt.Sprintf("failed to update metrics: %v", err)
+			}
+		}
+		chunk = chunk[:0]
+		chunkResults = chunkResults[:0]
+	}
+
+	status := http.StatusOK
+	reader := bufio.NewReader(body)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			status = middlewares.BodyErrorStatus(err)
+			logger.FromContext(r.Context()).Error(err.Error())
+			break
+		}
+
+		if data = bytes.TrimSpace(data); len(data) != 0 {
+			result := contracts.IngestResult{Line: line, Status: contracts.IngestAccepted}
+			var metric contracts.Metrics
+			decodeErr := json.Unmarshal(data, &metric)
+			if decodeErr == nil {
+				decodeErr = metric.Validate()
+			}
+			result.ID = metric.ID
+			if decodeErr != nil {
+				result.Status = contracts.IngestRejected
+				result.Error = decodeErr.Error()
+			} else {
+				chunk = append(chunk, metric)
+				chunkResults = append(chunkResults, len(report.Results))
+			}
+			report.Results = append(report.Results, result)
+		}
+		if len(chunk) >= ndjsonChunkSize {
+			flush()
+		}
+
+		if err != nil {
+			break
+		}
+	}
+	flush()
+
+	for _, result := range report.Results {
+		if result.Status == contracts.IngestAccepted {
+			report.Accepted++
+		} else {
+			report.Rejected++
+		}
+	}
+
+	response, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// readBody читает тело запроса и расшифровывает его, если задан приватный ключ.
+// Распаковка и проверка подписи выполняются раньше, в middleware.
+func readBody(r *http.Request, privateKey *rsa.PrivateKey) ([]byte, error) {
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	if privateKey == nil {
+		return data, nil
+	}
+
+	_, span := tracing.Start(r.Context(), "decrypt")
+	decryptedData, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, data)
+	if err != nil {
+		err = errors.New("failed to decrypt data")
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptedData, nil
+}
@@ -0,0 +1,133 @@
+// Package health - проверки живости и готовности сервера для оркестратора.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Статусы проверок.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// DefaultTimeout - время на одну проверку готовности по умолчанию.
+const DefaultTimeout = 2 * time.Second
+
+// Check - проверка одной зависимости сервера.
+type Check struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// Result - результат одной проверки.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report - ответ проверки готовности. Status равен ok, только если прошли все проверки.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Run выполняет проверки параллельно, каждую не дольше timeout.
+// Результаты идут в порядке проверок.
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	report := Report{Status: StatusOK, Checks: make([]Result, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Checks[i] = run(ctx, timeout, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, timeout time.Duration, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- check.Fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		// Проверка, которая не уважает ctx, не должна задерживать ответ.
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler отвечает 200, пока процесс способен обрабатывать запросы.
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Report{Status: StatusOK, Checks: []Result{}})
+	}
+}
+
+// ReadinessHandler выполняет проверки и отвечает 200, если все прошли, иначе 503.
+// Проверки запрашиваются при каждом вызове, так как их набор может меняться.
+func ReadinessHandler(checks func() []Check, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), timeout, checks())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Writable проверяет, что в каталоге dir можно создать файл.
+func Writable(dir string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		return errors.Join(f.Close(), os.Remove(name))
+	}
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	checks := []Check{
+		{Name: "ok", Fn: func(context.Context) error { return nil }},
+		{Name: "broken", Fn: func(context.Context) error { return errors.New("broken") }},
+		{Name: "stuck", Fn: func(context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	}
+
+	start := time.Now()
+	report := Run(context.Background(), 50*time.Millisecond, checks)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected stuck check to be cut by timeout, took %v", elapsed)
+	}
+
+	if report.Status != StatusFail {
+		t.Errorf("Expected overall status 'fail', got '%s'", report.Status)
+	}
+	want := []string{StatusOK, StatusFail, StatusFail}
+	for i, result := range report.Checks {
+		if result.Name != checks[i].Name || result.Status != want[i] {
+			t.Errorf("Check %d: expected %s '%s', got %+v", i, checks[i].Name, want[i], result)
+		}
+	}
+	if report.Checks[2].LatencyMS < 50 {
+		t.Errorf("Expected latency of stuck check to be at least the timeout, got %v", report.Checks[2].LatencyMS)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	healthy := true
+	handler := ReadinessHandler(func() []Check {
+		return []Check{{Name: "dependency", Fn: func(context.Context) error {
+			if !healthy {
+				return errors.New("down")
+			}
+			return nil
+		}}}
+	}, time.Second)
+
+	for _, test := range []struct {
+		healthy bool
+		want    int
+	}{
+		{healthy: true, want: http.StatusOK},
+		{healthy: false, want: http.StatusServiceUnavailable},
+	} {
+		healthy = test.healthy
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != test.want {
+			t.Errorf("Expected status code %d, got %d", test.want, rec.Code)
+		}
+		var report Report
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || len(report.Checks) != 1 {
+			t.Errorf("Expected JSON report with one check, got %+v (%v)", report, err)
+		}
+	}
+}
+
+func TestWritable(t *testing.T) {
+	if err := Writable(t.TempDir())(context.Background()); err != nil {
+		t.Errorf("Expected temp dir to be writable, got %v", err)
+	}
+	if err := Writable(filepath.Join(t.TempDir(), "missing"))(context.Background()); err == nil {
+		t.Errorf("Expected missing dir not to be writable")
+	}
+}
@@ -3,18 +3,28 @@ package instance
 import (
 	"context"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/server/config"
 	"github.com/evildead81/metrics-and-alerts/internal/server/handlers"
+	"github.com/evildead81/metrics-and-alerts/internal/server/health"
 	"github.com/evildead81/metrics-and-alerts/internal/server/middlewares"
+	"github.com/evildead81/metrics-and-alerts/internal/server/selfmetrics"
 	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	boltstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/bolt-storage"
+	dbstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/db-storage"
+	memstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/mem-storage"
 	"github.com/go-chi/chi/v5"
 	chiMid "github.com/go-chi/chi/v5/middleware"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -23,9 +33,43 @@ import (
 type ServerInstance struct {
 	endpoint      string
 	storage       storages.Storage
+	settings      settings
+	settingsMutex *sync.RWMutex
+	handler       atomic.Pointer[http.Handler]
+	reloaded      chan struct{}
+	done          chan struct{}
+	// metrics - метрики работы самого сервера, отдаются на /internal/metrics.
+	metrics *selfmetrics.Registry
+	// dumpDuration и dumpErrors - длительность и ошибки периодического сохранения хранилища.
+	dumpDuration *selfmetrics.HistogramVec
+	dumpErrors   *selfmetrics.CounterVec
+	// expiredSamples, expiredSeries и expiredRollups - данные, удаленные по срокам хранения.
+	expiredSamples *selfmetrics.CounterVec
+	expiredSeries  *selfmetrics.CounterVec
+	expiredRollups *selfmetrics.CounterVec
+	// rollupBuckets - интервалы, записанные сверткой истории, по уровням.
+	rollupBuckets *selfmetrics.CounterVec
+	// rolledUp - до какого момента свернута история каждого уровня. Используется только janitor.
+	rolledUp map[time.Duration]time.Time
+	// checks - проверки готовности помимо доступности хранилища, checksMutex защищает их.
+	checks       []health.Check
+	checksMutex  *sync.Mutex
+	shuttingDown atomic.Bool
+}
+
+// settings - параметры сервера, которые можно изменить без перезапуска.
+type settings struct {
 	storeInterval time.Duration
 	key           string
+	hashRequired  bool
 	privateKey    *rsa.PrivateKey
+	compression   middlewares.CompressionOptions
+	maxBodySize   int64
+	// readinessTimeout - время на одну проверку готовности.
+	readinessTimeout time.Duration
+	// retention - сроки хранения данных, janitorInterval - интервал их очистки.
+	retention       storages.Retention
+	janitorInterval time.Duration
 }
 
 // New создает инстанс сервера.
@@ -34,49 +78,273 @@ func New(
 	storage *storages.Storage,
 	storeInterval time.Duration,
 	key string,
+	hashRequired bool,
 	cryptoKeyPath string,
 ) *ServerInstance {
+	instance, err := NewWithConfig(storage, config.ServerConfig{
+		Address:       endpoint,
+		StoreInterval: configloader.Duration{Duration: storeInterval},
+		Key:           key,
+		HashRequired:  hashRequired,
+		CryptoKey:     cryptoKeyPath,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return instance
+}
+
+// NewWithConfig создает инстанс сервера по конфигурации.
+func NewWithConfig(storage *storages.Storage, cfg config.ServerConfig) (*ServerInstance, error) {
+	s, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := selfmetrics.NewRegistry()
+	selfmetrics.RegisterRuntime(metrics)
+
 	instance := ServerInstance{
-		endpoint:      endpoint,
-		storage:       *storage,
-		storeInterval: storeInterval,
-		key:           key,
+		endpoint:      cfg.Address,
+		storage:       storages.Instrument(*storage, metrics, storageBackend(*storage)),
+		settings:      s,
+		settingsMutex: &sync.RWMutex{},
+		reloaded:      make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		metrics:       metrics,
+		dumpDuration: metrics.Histogram("server_storage_dump_duration_seconds",
+			"Duration of periodic storage dumps.", nil),
+		dumpErrors: metrics.Counter("server_storage_dump_errors_total",
+			"Failed periodic storage dumps."),
+		expiredSamples: metrics.Counter("server_expired_samples_total",
+			"History samples removed by retention."),
+		expiredSeries: metrics.Counter("server_expired_series_total",
+			"Metrics removed after not being updated for stale_after."),
+		expiredRollups: metrics.Counter("server_expired_rollups_total",
+			"History rollup buckets removed by tier retention."),
+		rollupBuckets: metrics.Counter("server_rollup_buckets_total",
+			"History rollup buckets written.", "resolution"),
+		rolledUp:    make(map[time.Duration]time.Time),
+		checksMutex: &sync.Mutex{},
+	}
+	for _, check := range storageChecks(*storage) {
+		instance.AddReadinessCheck(check)
+	}
+	instance.swapHandler()
+
+	return &instance, nil
+}
+
+// storageChecks возвращает проверки готовности, которые поддерживает хранилище:
+// возможность записи в каталог файла хранилища и результат восстановления при запуске.
+func storageChecks(storage storages.Storage) []health.Check {
+	var checks []health.Check
+	if s, ok := storage.(interface{ StoragePath() string }); ok && len(s.StoragePath()) != 0 {
+		checks = append(checks, health.Check{
+			Name: "file_storage",
+			Fn:   health.Writable(filepath.Dir(s.StoragePath())),
+		})
+	}
+	if s, ok := storage.(interface{ RestoreStatus() error }); ok {
+		checks = append(checks, health.Check{
+			Name: "restore",
+			Fn:   func(context.Context) error { return s.RestoreStatus() },
+		})
+	}
+	return checks
+}
+
+// AddReadinessCheck добавляет проверку, от которой зависит готовность сервера.
+func (t *ServerInstance) AddReadinessCheck(check health.Check) {
+	t.checksMutex.Lock()
+	defer t.checksMutex.Unlock()
+	t.checks = append(t.checks, check)
+}
+
+// readinessChecks возвращает все проверки готовности: остановку сервера,
+// доступность хранилища и добавленные через AddReadinessCheck.
+func (t *ServerInstance) readinessChecks() []health.Check {
+	checks := []health.Check{
+		{Name: "shutdown", Fn: func(context.Context) error {
+			if t.shuttingDown.Load() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		}},
+		{Name: "storage", Fn: func(ctx context.Context) error {
+			return storages.PingContext(ctx, t.storage)
+		}},
+	}
+
+	t.checksMutex.Lock()
+	defer t.checksMutex.Unlock()
+	return append(checks, t.checks...)
+}
+
+// storageBackend возвращает метку вида хранилища для метрик сервера.
+func storageBackend(storage storages.Storage) string {
+	switch storage.(type) {
+	case *memstorage.MemStorage:
+		return "memory"
+	case *dbstorage.DBStorage:
+		return "database"
+	case *boltstorage.BoltStorage:
+		return "bolt"
+	default:
+		return "other"
+	}
+}
+
+func newSettings(cfg config.ServerConfig) (settings, error) {
+	s := settings{
+		storeInterval:    cfg.StoreInterval.Duration,
+		key:              cfg.Key,
+		hashRequired:     cfg.HashRequired,
+		compression:      middlewares.DefaultCompressionOptions(),
+		maxBodySize:      cfg.MaxBodySize,
+		readinessTimeout: cfg.ReadinessTimeout.Duration,
+		retention:        cfg.Retention(),
+		janitorInterval:  cfg.JanitorInterval.Duration,
+	}
+	if s.storeInterval == 0 {
+		// В синхронном режиме обновления сохраняются журналом хранилища,
+		// а снимки нужны только чтобы журнал не рос бесконечно.
+		s.storeInterval = config.DefaultSyncCheckpointInterval
+	}
+	if s.janitorInterval <= 0 {
+		s.janitorInterval = config.DefaultJanitorInterval
+	}
+	if s.readinessTimeout <= 0 {
+		s.readinessTimeout = health.DefaultTimeout
+	}
+	if s.maxBodySize == 0 {
+		s.maxBodySize = config.DefaultMaxBodySize
+	}
+	if cfg.CompressionMinSize != 0 {
+		s.compression.MinSize = cfg.CompressionMinSize
+	}
+	if len(cfg.CompressionTypes) != 0 {
+		s.compression.ContentTypes = cfg.CompressionTypes
 	}
 
-	if len(cryptoKeyPath) != 0 {
-		privateKeyPEM, err := os.ReadFile(cryptoKeyPath)
+	if len(cfg.CryptoKey) != 0 {
+		privateKey, err := config.LoadPrivateKey(cfg.CryptoKey)
 		if err != nil {
-			panic(err)
+			return s, err
 		}
+		s.privateKey = privateKey
+	}
 
-		privateKeyBlock, _ := pem.Decode(privateKeyPEM)
-		privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-		if err != nil {
-			panic(err)
+	return s, nil
+}
+
+// Reload применяет перезагружаемые параметры конфигурации: ключ подписи,
+// приватный ключ, интервал сохранения, сжатие ответов, предельный размер тела запроса,
+// время проверок готовности, сроки хранения данных и уровень логирования.
+// Остальные параметры, в том числе переход в синхронный режим сохранения
+// при интервале 0, требуют перезапуска и игнорируются.
+// При ошибке текущие параметры не меняются.
+func (t *ServerInstance) Reload(cfg config.ServerConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s, err := newSettings(cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.LogLevel) != 0 {
+		if err := logger.SetLevel(cfg.LogLevel); err != nil {
+			return err
 		}
+	}
+
+	t.settingsMutex.Lock()
+	t.settings = s
+	t.settingsMutex.Unlock()
 
-		instance.privateKey = privateKey
+	t.swapHandler()
+	select {
+	case t.reloaded <- struct{}{}:
+	default:
 	}
 
-	return &instance
+	return nil
+}
+
+func (t *ServerInstance) currentSettings() settings {
+	t.settingsMutex.RLock()
+	defer t.settingsMutex.RUnlock()
+	return t.settings
+}
+
+// swapHandler пересобирает маршруты с текущими параметрами.
+// Запросы, которые уже обрабатываются, завершаются со старыми параметрами.
+func (t *ServerInstance) swapHandler() {
+	h := t.router()
+	t.handler.Store(&h)
+}
+
+// ServeHTTP передает запрос актуальному обработчику.
+func (t *ServerInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*t.handler.Load()).ServeHTTP(w, r)
 }
 
 // Run - запускает сервер.
-func (t ServerInstance) Run() {
+func (t *ServerInstance) Run() {
+	t.runSaver()
+	t.runJanitor()
+
+	srv := &http.Server{
+		Addr:    t.endpoint,
+		Handler: t,
+	}
+	srvErrs := make(chan error, 1)
+	go func() {
+		srvErrs <- srv.ListenAndServe()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	shutdown := t.gracefulShutdown(srv)
+
+	select {
+	case err := <-srvErrs:
+		shutdown(err)
+	case sig := <-quit:
+		shutdown(sig)
+	}
+}
+
+// router собирает маршруты и middleware сервера.
+func (t *ServerInstance) router() http.Handler {
+	s := t.currentSettings()
+
 	r := chi.NewRouter()
+	r.Use(middlewares.WithTracing)
 	r.Use(middlewares.WithLogging)
-	r.Use(middlewares.GzipMiddleware)
+	r.Use(middlewares.WithMetrics(t.metrics))
+	r.Use(middlewares.Compression(s.compression))
+	r.Use(middlewares.BodyLimit(s.maxBodySize))
+	r.Use(middlewares.HashMiddleware(s.key, s.hashRequired))
 	r.Route("/update", func(r chi.Router) {
 		r.Post("/{metricType}/{metricName}/{metricValue}", handlers.UpdateMetricByParamsHandler(t.storage))
-		r.Post("/", handlers.UpdateMetricByJSONHandler(t.storage, t.key, t.privateKey))
+		r.Post("/", handlers.UpdateMetricByJSONHandler(t.storage, s.privateKey))
 	})
-	r.Post("/updates/", handlers.UpdateMetrics(t.storage, t.key, t.privateKey))
+	r.Post("/updates/", handlers.UpdateMetrics(t.storage, s.privateKey))
 	r.Route("/value", func(r chi.Router) {
 		r.Get("/{metricType}/{metricName}", handlers.GetMetricByParamsHandler(t.storage))
 		r.Post("/", handlers.GetMetricByJSONHandler(t.storage))
 	})
+	r.Get("/range/{metricType}/{metricName}", handlers.RangeHandler(t.storage, s.retention.Tiers))
 	r.Get("/", handlers.GetPageHandler(t.storage))
 	r.Get("/ping", handlers.Ping(t.storage))
+	r.Get("/internal/metrics", t.metrics.Handler())
+	r.Get("/healthz", health.LivenessHandler())
+	r.Get("/readyz", health.ReadinessHandler(t.readinessChecks, s.readinessTimeout))
 	r.Mount("/debug", chiMid.Profiler())
 
 	rtProf := chi.NewRouter()
@@ -96,45 +364,109 @@ func (t ServerInstance) Run() {
 
 	r.Mount("/debug/pprof", rtProf)
 
-	t.runSaver()
-
-	srv := &http.Server{
-		Addr:    t.endpoint,
-		Handler: r,
-	}
-	srvErrs := make(chan error, 1)
-	go func() {
-		srvErrs <- srv.ListenAndServe()
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
-
-	shutdown := t.gracefulShutdown(srv)
-
-	select {
-	case err := <-srvErrs:
-		shutdown(err)
-	case sig := <-quit:
-		shutdown(sig)
-	}
+	return r
 }
 
-func (t ServerInstance) gracefulShutdown(srv *http.Server) func(reason interface{}) {
+func (t *ServerInstance) gracefulShutdown(srv *http.Server) func(reason interface{}) {
 	return func(reason interface{}) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		t.shuttingDown.Store(true)
+		close(t.done)
 		t.storage.Write()
 		srv.Shutdown(ctx)
 	}
 }
 
-func (t ServerInstance) runSaver() {
+// runSaver периодически сохраняет хранилище. После перезагрузки конфигурации
+// ожидание начинается заново с новым интервалом.
+func (t *ServerInstance) runSaver() {
+	go func() {
+		for {
+			select {
+			case <-time.After(t.currentSettings().storeInterval):
+				t.dump()
+			case <-t.reloaded:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+// dump сохраняет хранилище и учитывает длительность и ошибки сохранения.
+func (t *ServerInstance) dump() {
+	start := time.Now()
+	err := t.storage.Write()
+	t.dumpDuration.With().Observe(time.Since(start).Seconds())
+	if err != nil {
+		t.dumpErrors.With().Inc()
+		logger.Logger.Errorw("Failed to save storage", "error", err.Error())
+	}
+}
+
+// runJanitor периодически сворачивает историю и удаляет данные с истекшим сроком хранения.
+// Новый интервал после перезагрузки конфигурации применяется со следующей очистки.
+func (t *ServerInstance) runJanitor() {
 	go func() {
 		for {
-			time.Sleep(t.storeInterval * time.Second)
-			t.storage.Write()
+			select {
+			case <-time.After(t.currentSettings().janitorInterval):
+				now := time.Now()
+				t.rollup(now)
+				t.expire(now)
+			case <-t.done:
+				return
+			}
 		}
 	}()
 }
+
+// expire удаляет из хранилища данные, устаревшие к now, учитывает и пишет в лог удаленное.
+func (t *ServerInstance) expire(now time.Time) {
+	expired, err := storages.Expire(context.Background(), t.storage, t.currentSettings().retention, now)
+	if err != nil {
+		logger.Logger.Errorw("Failed to expire stale data", "error", err.Error())
+		return
+	}
+	t.expiredSamples.With().Add(expired.Samples)
+	t.expiredSeries.With().Add(expired.Series)
+	t.expiredRollups.With().Add(expired.Rollups)
+	if expired.Samples != 0 || expired.Series != 0 || expired.Rollups != 0 {
+		logger.Logger.Infow("Expired stale data",
+			"samples", expired.Samples, "series", expired.Series, "rollups", expired.Rollups)
+	}
+}
+
+// rollup сворачивает историю в уровни до последних завершенных к now интервалов.
+// Первый уровень строится из исходных значений, каждый следующий - из предыдущего.
+// Период уровня, который еще не сворачивался, начинается с самых старых данных,
+// которые хранит его источник.
+func (t *ServerInstance) rollup(now time.Time) {
+	retention := t.currentSettings().retention
+	source, sourceKeep := time.Duration(0), retention.History
+	for _, tier := range retention.Tiers {
+		from, ok := t.rolledUp[tier.Resolution]
+		if !ok {
+			from = time.Unix(0, 0)
+			if sourceKeep > 0 {
+				from = storages.Bucket(now.Add(-sourceKeep), tier.Resolution)
+			}
+		}
+		to := storages.Bucket(now, tier.Resolution)
+		if from.Before(to) {
+			n, err := storages.RollupPeriod(context.Background(), t.storage, source, tier.Resolution, from, to)
+			if errors.Is(err, storages.ErrNoRollups) {
+				return
+			}
+			if err != nil {
+				logger.Logger.Errorw("Failed to roll up history", "resolution", tier.Resolution.String(), "error", err.Error())
+				return
+			}
+			t.rollupBuckets.With(tier.Resolution.String()).Add(n)
+			t.rolledUp[tier.Resolution] = to
+		}
+		source, sourceKeep = tier.Resolution, tier.Keep
+	}
+}
@@ -1,16 +1,37 @@
 package instance
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/evildead81/metrics-and-alerts/internal/configloader"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	hash "github.com/evildead81/metrics-and-alerts/internal/hash"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/server/config"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/health"
 	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	boltstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/bolt-storage"
 	memstorage "github.com/evildead81/metrics-and-alerts/internal/server/storages/mem-storage"
 	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/zstd"
 )
 
 type MockStorage struct {
@@ -31,16 +52,16 @@ func TestNewInstance(t *testing.T) {
 	storage := &MockStorage{
 		storage: memstorage.New("./metrics.json", true),
 	}
-	instance := New(":8080", &storage.storage, 5*time.Second, "test-key", "")
+	instance := New(":8080", &storage.storage, 5*time.Second, "test-key", false, "")
 
 	if instance.endpoint != ":8080" {
 		t.Errorf("Expected endpoint ':8080', got '%s'", instance.endpoint)
 	}
-	if instance.storeInterval != 5*time.Second {
-		t.Errorf("Expected storeInterval '5s', got '%v'", instance.storeInterval)
+	if instance.settings.storeInterval != 5*time.Second {
+		t.Errorf("Expected storeInterval '5s', got '%v'", instance.settings.storeInterval)
 	}
-	if instance.key != "test-key" {
-		t.Errorf("Expected key 'test-key', got '%s'", instance.key)
+	if instance.settings.key != "test-key" {
+		t.Errorf("Expected key 'test-key', got '%s'", instance.settings.key)
 	}
 	t.Log("New instance created successfully")
 }
@@ -69,7 +90,7 @@ func TestRoutes(t *testing.T) {
 
 func TestRunServer(t *testing.T) {
 	storage := &MockStorage{}
-	instance := New(":8080", &storage.storage, 5*time.Second, "test-key", "")
+	instance := New(":8080", &storage.storage, 5*time.Second, "test-key", false, "")
 
 	go func() {
 		defer func() {
@@ -84,3 +105,614 @@ func TestRunServer(t *testing.T) {
 	time.Sleep(1 * time.Second) // Ждем завершения
 	t.Log("Server started and stopped correctly")
 }
+
+func writePrivateKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "private.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return privateKey, path
+}
+
+func TestRouterGzipEncryptionHash(t *testing.T) {
+	const key = "test-key"
+	privateKey, keyPath := writePrivateKey(t)
+
+	tests := []struct {
+		name     string
+		gzip     bool
+		zstd     bool
+		encrypt  bool
+		sign     bool
+		badSign  bool
+		required bool
+		want     int
+	}{
+		{name: "plain", want: http.StatusOK},
+		{name: "gzip", gzip: true, want: http.StatusOK},
+		{name: "signed", sign: true, want: http.StatusOK},
+		{name: "gzip signed", gzip: true, sign: true, want: http.StatusOK},
+		{name: "encrypted signed", encrypt: true, sign: true, want: http.StatusOK},
+		{name: "gzip encrypted signed", gzip: true, encrypt: true, sign: true, want: http.StatusOK},
+		{name: "gzip encrypted bad sign", gzip: true, encrypt: true, sign: true, badSign: true, want: http.StatusBadRequest},
+		{name: "zstd encrypted signed", zstd: true, encrypt: true, sign: true, want: http.StatusOK},
+		{name: "zstd encrypted bad sign", zstd: true, encrypt: true, sign: true, badSign: true, want: http.StatusBadRequest},
+		{name: "unsigned when required", gzip: true, required: true, want: http.StatusBadRequest},
+		{name: "signed when required", gzip: true, sign: true, required: true, want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		for _, url := range []string{"/update/", "/updates/"} {
+			t.Run(test.name+" "+url, func(t *testing.T) {
+				var storage storages.Storage = memstorage.New("", false)
+				cryptoKeyPath := ""
+				if test.encrypt {
+					cryptoKeyPath = keyPath
+				}
+				ts := httptest.NewServer(New(":0", &storage, time.Second, key, test.required, cryptoKeyPath))
+				defer ts.Close()
+
+				value := 12.5
+				metric := contracts.Metrics{ID: "g", MType: consts.Gauge, Value: &value}
+				var payload []byte
+				if url == "/updates/" {
+					payload, _ = json.Marshal([]contracts.Metrics{metric})
+				} else {
+					payload, _ = json.Marshal(metric)
+				}
+
+				if test.encrypt {
+					encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &privateKey.PublicKey, payload)
+					if err != nil {
+						t.Fatalf("Failed to encrypt payload: %v", err)
+					}
+					payload = encrypted
+				}
+
+				sign, _ := hash.Hash(payload, key)
+				if test.badSign {
+					sign, _ = hash.Hash(payload, "another-key")
+				}
+
+				body := payload
+				if test.gzip {
+					buf := bytes.NewBuffer(nil)
+					zb := gzip.NewWriter(buf)
+					zb.Write(payload)
+					zb.Close()
+					body = buf.Bytes()
+				}
+				if test.zstd {
+					zw, _ := zstd.NewWriter(nil)
+					body = zw.EncodeAll(payload, nil)
+					zw.Close()
+				}
+
+				req, _ := http.NewRequest(http.MethodPost, ts.URL+url, bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				if test.gzip {
+					req.Header.Set("Content-Encoding", "gzip")
+				}
+				if test.zstd {
+					req.Header.Set("Content-Encoding", "zstd")
+				}
+				if test.sign {
+					req.Header.Set(hash.HashHeaderKey, sign)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("Failed to send request: %v", err)
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != test.want {
+					t.Fatalf("Expected status code %d, got %d", test.want, resp.StatusCode)
+				}
+
+				respBody, _ := io.ReadAll(resp.Body)
+				respSign, _ := hash.Hash(respBody, key)
+				if resp.Header.Get(hash.HashHeaderKey) != respSign {
+					t.Errorf("Expected response hash '%s', got '%s'", respSign, resp.Header.Get(hash.HashHeaderKey))
+				}
+
+				if test.want != http.StatusOK {
+					return
+				}
+				if got, _ := storage.GetGaugeValueByName("g"); got != value {
+					t.Errorf("Expected gauge value %g, got %g", value, got)
+				}
+			})
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	var storage storages.Storage = memstorage.New("", false)
+	instance := New(":0", &storage, 5*time.Second, "old-key", false, "")
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	send := func(key string) int {
+		payload := []byte(`{"id":"g","type":"gauge","value":1}`)
+		sign, _ := hash.Hash(payload, key)
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/update/", bytes.NewReader(payload))
+		req.Header.Set(hash.HashHeaderKey, sign)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := send("old-key"); code != http.StatusOK {
+		t.Fatalf("Expected status code 200 before reload, got %d", code)
+	}
+
+	_, keyPath := writePrivateKey(t)
+	err := instance.Reload(config.ServerConfig{
+		Address:         ":8080",
+		StoreInterval:   configloader.Seconds(1),
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+		Key:             "new-key",
+		CryptoKey:       keyPath,
+		LogLevel:        "info",
+	})
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	defer logger.SetLevel("debug")
+
+	if instance.currentSettings().storeInterval != time.Second {
+		t.Errorf("Expected storeInterval '1s', got '%v'", instance.currentSettings().storeInterval)
+	}
+	if instance.currentSettings().privateKey == nil {
+		t.Errorf("Expected private key to be loaded")
+	}
+	if code := send("old-key"); code != http.StatusBadRequest {
+		t.Errorf("Expected old key to be rejected, got %d", code)
+	}
+
+	err = instance.Reload(config.ServerConfig{
+		Address:         ":8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+		Key:             "another-key",
+		CryptoKey:       "/not/exists.pem",
+	})
+	if err == nil {
+		t.Fatalf("Expected reload with missing crypto key to fail")
+	}
+	if instance.currentSettings().key != "new-key" {
+		t.Errorf("Expected key to stay 'new-key', got '%s'", instance.currentSettings().key)
+	}
+}
+
+func TestRouterCompression(t *testing.T) {
+	var storage storages.Storage = memstorage.New("", false)
+	storage.UpdateGauge("g", 1)
+	instance, err := NewWithConfig(&storage, config.ServerConfig{Address: ":0", CompressionMinSize: 1})
+	if err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	get := func(acceptEncoding string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/value/", bytes.NewReader([]byte(`{"id":"g","type":"gauge"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if got := get("gzip, br").Header.Get("Content-Encoding"); got != "br" {
+		t.Errorf("Expected 'br' response, got '%s'", got)
+	}
+	if got := get("zstd;q=0.9, gzip").Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Expected 'gzip' response, got '%s'", got)
+	}
+
+	err = instance.Reload(config.ServerConfig{
+		Address:          ":0",
+		FileStoragePath:  filepath.Join(t.TempDir(), "metrics.json"),
+		CompressionTypes: []string{"text/*"},
+	})
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := get("gzip").Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected uncompressed JSON after reload, got '%s'", got)
+	}
+}
+
+func TestRouterNDJSON(t *testing.T) {
+	const key = "test-key"
+	var storage storages.Storage = memstorage.New("", false)
+	instance, err := NewWithConfig(&storage, config.ServerConfig{Address: ":0", Key: key, MaxBodySize: 512})
+	if err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	send := func(body, contentType string) (int, contracts.IngestReport, string) {
+		buf := bytes.NewBuffer(nil)
+		zb := gzip.NewWriter(buf)
+		zb.Write([]byte(body))
+		zb.Close()
+
+		sign, _ := hash.Hash([]byte(body), key)
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/updates/", buf)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(hash.HashHeaderKey, sign)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		respBody, _ := io.ReadAll(resp.Body)
+		var report contracts.IngestReport
+		json.Unmarshal(respBody, &report)
+		return resp.StatusCode, report, string(respBody)
+	}
+
+	body := `{"id":"g","type":"gauge","value":1.5}
+{"id":"broken",
+{"id":"c","type":"counter"}
+
+{"id":"c","type":"counter","delta":3}
+`
+	code, report, _ := send(body, "application/x-ndjson")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", code)
+	}
+	if report.Accepted != 2 || report.Rejected != 2 {
+		t.Errorf("Expected 2 accepted and 2 rejected, got %+v", report)
+	}
+	wantStatuses := map[int]string{1: "accepted", 2: "rejected", 3: "rejected", 5: "accepted"}
+	for _, result := range report.Results {
+		if wantStatuses[result.Line] != result.Status {
+			t.Errorf("Line %d: expected '%s', got '%s' (%s)", result.Line, wantStatuses[result.Line], result.Status, result.Error)
+		}
+		if result.Status == "rejected" && len(result.Error) == 0 {
+			t.Errorf("Line %d: expected rejection reason", result.Line)
+		}
+	}
+	if got, _ := storage.GetGaugeValueByName("g"); got != 1.5 {
+		t.Errorf("Expected gauge value 1.5, got %g", got)
+	}
+	if got, _ := storage.GetCountValueByName("c"); got != 3 {
+		t.Errorf("Expected counter value 3, got %d", got)
+	}
+
+	code, _, respBody := send(`[{"id":"g","type":"gauge","value":2},{"id":"g2","type":"gauge"}]`, "application/json")
+	if code != http.StatusBadRequest || !bytes.Contains([]byte(respBody), []byte("metric 1")) {
+		t.Errorf("Expected 400 naming metric 1, got %d: %s", code, respBody)
+	}
+	if got, _ := storage.GetGaugeValueByName("g"); got != 1.5 {
+		t.Errorf("Expected invalid batch to be rejected as a whole, gauge is %g", got)
+	}
+
+	var large bytes.Buffer
+	for i := 0; i < 20; i++ {
+		large.WriteString(`{"id":"big","type":"counter","delta":1}` + "\n")
+	}
+	code, _, _ = send(large.String(), "application/x-ndjson")
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code 413 for large body, got %d", code)
+	}
+}
+
+func TestRouterNDJSONPartialBody(t *testing.T) {
+	var storage storages.Storage = memstorage.New("", false)
+	instance, err := NewWithConfig(&storage, config.ServerConfig{Address: ":0", MaxBodySize: 512})
+	if err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	var body bytes.Buffer
+	for i := 0; i < 20; i++ {
+		body.WriteString(`{"id":"big","type":"counter","delta":1}` + "\n")
+	}
+	resp, err := http.Post(ts.URL+"/updates/", "application/x-ndjson", &body)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status code 413, got %d", resp.StatusCode)
+	}
+	var report contracts.IngestReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	// Строки, прочитанные до превышения размера, записаны и перечислены в отчете.
+	got, _ := storage.GetCountValueByName("big")
+	if report.Accepted == 0 || report.Accepted >= 20 || int64(report.Accepted) != got {
+		t.Errorf("Expected partial report to match storage, got %+v and counter %d", report, got)
+	}
+}
+
+func TestInternalMetrics(t *testing.T) {
+	// Файл в несуществующем каталоге, чтобы сохранение завершилось ошибкой.
+	var storage storages.Storage = memstorage.New(filepath.Join(t.TempDir(), "missing", "metrics.json"), false)
+	instance := New(":0", &storage, time.Second, "", false, "")
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	for _, url := range []string{"/update/gauge/g/1", "/update/counter/c/2", "/update/gauge/g/bad"} {
+		resp, err := http.Post(ts.URL+url, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Post(ts.URL+"/updates/", "application/json", bytes.NewReader([]byte(`[{"id":"c","type":"counter","delta":1}]`)))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	instance.dump()
+
+	resp, err = http.Get(ts.URL + "/internal/metrics")
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`server_http_requests_total{route="/update/{metricType}/{metricName}/{metricValue}",method="POST",status="200"} 2`,
+		`server_http_requests_total{route="/update/{metricType}/{metricName}/{metricValue}",method="POST",status="400"} 1`,
+		`server_http_request_duration_seconds_count{route="/updates",method="POST",status="200"} 1`,
+		`server_ingested_metrics_total{type="gauge"} 1`,
+		`server_ingested_metrics_total{type="counter"} 2`,
+		`server_storage_operation_duration_seconds_count{backend="memory",operation="update_metrics"} 1`,
+		`server_storage_operation_errors_total{backend="memory",operation="write"} 1`,
+		`server_storage_dump_duration_seconds_count 1`,
+		`server_storage_dump_errors_total 1`,
+		"\ngo_goroutines ",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("Expected '%s' in metrics:\n%s", want, body)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatalf("Failed to write storage file: %v", err)
+	}
+
+	get := func(ts *httptest.Server, url string) (int, map[string]string) {
+		resp, err := http.Get(ts.URL + url)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+		var report struct {
+			Status string `json:"status"`
+			Checks []struct {
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			} `json:"checks"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("Failed to decode report: %v", err)
+		}
+		statuses := map[string]string{"": report.Status}
+		for _, check := range report.Checks {
+			statuses[check.Name] = check.Status
+		}
+		return resp.StatusCode, statuses
+	}
+
+	var storage storages.Storage = memstorage.New(path, true)
+	instance := New(":0", &storage, time.Second, "", false, "")
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	if code, _ := get(ts, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to return 200, got %d", code)
+	}
+	code, statuses := get(ts, "/readyz")
+	if code != http.StatusServiceUnavailable || statuses["restore"] != "fail" {
+		t.Errorf("Expected broken restore to fail readiness, got %d %v", code, statuses)
+	}
+	if statuses["storage"] != "ok" || statuses["file_storage"] != "ok" || statuses["shutdown"] != "ok" {
+		t.Errorf("Expected other checks to pass, got %v", statuses)
+	}
+
+	storage = memstorage.New(filepath.Join(dir, "fresh.json"), true)
+	instance = New(":0", &storage, time.Second, "", false, "")
+	ts = httptest.NewServer(instance)
+	defer ts.Close()
+	if code, statuses := get(ts, "/readyz"); code != http.StatusOK {
+		t.Errorf("Expected missing storage file to be ready, got %d %v", code, statuses)
+	}
+
+	instance.AddReadinessCheck(health.Check{Name: "notifier", Fn: func(context.Context) error {
+		return errors.New("backlog is full")
+	}})
+	if code, statuses := get(ts, "/readyz"); code != http.StatusServiceUnavailable || statuses["notifier"] != "fail" {
+		t.Errorf("Expected added check to fail readiness, got %d %v", code, statuses)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	var storage storages.Storage = memstorage.New("", false)
+	cfg := config.DefaultConfig()
+	cfg.Address = ":0"
+	cfg.StaleAfter = configloader.Seconds(60)
+	instance, err := NewWithConfig(&storage, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	for _, url := range []string{"/update/gauge/g/1", "/update/gauge/g/2", "/update/counter/c/2"} {
+		resp, err := http.Post(ts.URL+url, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	instance.expire(time.Now())
+	if len(storage.GetGauges()) != 1 {
+		t.Fatalf("Expected fresh metrics to stay")
+	}
+	instance.expire(time.Now().Add(2 * time.Minute))
+	if len(storage.GetGauges()) != 0 || len(storage.GetCounters()) != 0 {
+		t.Errorf("Expected stale metrics to be removed, got %v %v", storage.GetGauges(), storage.GetCounters())
+	}
+
+	resp, err := http.Get(ts.URL + "/internal/metrics")
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{"server_expired_samples_total 3", "server_expired_series_total 2"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("Expected '%s' in metrics:\n%s", want, body)
+		}
+	}
+}
+
+func TestRangeEndpoint(t *testing.T) {
+	var storage storages.Storage = memstorage.New("", false)
+	cfg := config.DefaultConfig()
+	cfg.Address = ":0"
+	instance, err := NewWithConfig(&storage, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	start := time.Now()
+	for _, url := range []string{"/update/gauge/load/1", "/update/gauge/load/3"} {
+		resp, err := http.Post(ts.URL+url, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+	}
+	instance.rollup(start.Add(2 * time.Minute))
+
+	get := func(query string) (int, contracts.RangeResponse) {
+		resp, err := http.Get(ts.URL + "/range/gauge/load?" + query)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+		var body contracts.RangeResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	from := start.Add(-time.Minute).Format(time.RFC3339)
+	status, body := get("from=" + from)
+	if status != http.StatusOK || len(body.Points) != 2 || body.Resolution != "" {
+		t.Errorf("Expected raw samples, got %d %+v", status, body)
+	}
+
+	status, body = get("from=" + from + "&step=1m")
+	var count int64
+	var sum float64
+	for _, p := range body.Points {
+		count += p.Count
+		sum += p.Sum
+	}
+	if status != http.StatusOK || body.Resolution != "1m0s" || count != 2 || sum != 4 {
+		t.Errorf("Expected points from the minute tier, got %d %+v", status, body)
+	}
+
+	for _, query := range []string{"step=-1m", "step=1ms&from=0", "from=later", "from=" + start.Add(time.Hour).Format(time.RFC3339)} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", query, status)
+		}
+	}
+	resp, err := http.Get(ts.URL + "/range/histogram/load")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown type, got %d", resp.StatusCode)
+	}
+}
+
+func TestSyncStoreInterval(t *testing.T) {
+	var storage storages.Storage = memstorage.New("", false)
+	instance := New(":0", &storage, 0, "", false, "")
+	if instance.settings.storeInterval != config.DefaultSyncCheckpointInterval {
+		t.Errorf("Expected checkpoints every %v in sync mode, got %v",
+			config.DefaultSyncCheckpointInterval, instance.settings.storeInterval)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.StoreInterval = configloader.Seconds(0)
+	if opts := cfg.MemStorage(); opts.Sync != memstorage.SyncAlways {
+		t.Errorf("Expected every update to be synced, got %+v", opts)
+	}
+}
+
+func TestBoltStorage(t *testing.T) {
+	bolt, err := boltstorage.New(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("Failed to open bolt storage: %v", err)
+	}
+	defer bolt.Close()
+	var storage storages.Storage = bolt
+	cfg := config.DefaultConfig()
+	cfg.Address = ":0"
+	instance, err := NewWithConfig(&storage, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(instance)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update/gauge/load/1.5", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+
+	for url, want := range map[string]string{
+		"/value/gauge/load": "1.5",
+		"/range/gauge/load": `"count":1`,
+		"/readyz?verbose=1": "file_storage",
+		"/internal/metrics": `backend="bolt"`,
+	} {
+		resp, err := http.Get(ts.URL + url)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte(want)) {
+			t.Errorf("%s: expected 200 with %q, got %d %s", url, want, resp.StatusCode, body)
+		}
+	}
+}
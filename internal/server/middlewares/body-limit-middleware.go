@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+)
+
+// BodyLimit ограничивает размер тела запроса. Ставится после распаковки, поэтому
+// ограничивается размер распакованного тела. Чтение сверх limit байт возвращает
+// *http.MaxBytesError. При limit <= 0 ограничения нет.
+func BodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyErrorStatus возвращает код ответа на ошибку чтения тела запроса:
+// 413, если превышен размер тела, иначе 400.
+func BodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	handler := BodyLimit(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), BodyErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{body: "small", want: http.StatusOK},
+		{body: "exactly8", want: http.StatusOK},
+		{body: "much larger body", want: http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.want {
+			t.Errorf("Body '%s': expected status code %d, got %d", test.body, test.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Кодировки содержимого.
+const (
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+	encodingBrotli   = "br"
+	encodingIdentity = "identity"
+)
+
+// RequestEncodings - кодировки тела запроса, которые понимает сервер.
+// Перечисляются в заголовке Accept-Encoding ответа, чтобы клиент мог выбрать сжатие.
+const RequestEncodings = "gzip, zstd, br"
+
+// responseEncodings - кодировки ответа в порядке предпочтения сервера при равном q.
+var responseEncodings = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// DefaultCompressionMinSize - ответы меньше этого размера в байтах не сжимаются.
+const DefaultCompressionMinSize = 256
+
+// DefaultCompressionTypes - типы содержимого ответов, которые сжимаются по умолчанию.
+var DefaultCompressionTypes = []string{"application/json", "application/x-ndjson", "text/*"}
+
+// CompressionOptions - параметры сжатия ответов.
+type CompressionOptions struct {
+	// MinSize - минимальный размер ответа в байтах, с которого он сжимается.
+	MinSize int
+	// ContentTypes - сжимаемые типы содержимого. Тип вида text/* разрешает все подтипы.
+	// Если список пуст, используется DefaultCompressionTypes.
+	ContentTypes []string
+}
+
+// DefaultCompressionOptions возвращает параметры сжатия по умолчанию.
+func DefaultCompressionOptions() CompressionOptions {
+	return CompressionOptions{
+		MinSize:      DefaultCompressionMinSize,
+		ContentTypes: DefaultCompressionTypes,
+	}
+}
+
+// encoder - сжимающий писатель, который можно переиспользовать через Reset.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// decoder - распаковывающий читатель, который можно переиспользовать через Reset.
+type decoder interface {
+	io.Reader
+	Reset(r io.Reader) error
+}
+
+// Кодировщики и декодировщики дорого создавать, поэтому они берутся из пулов.
+var (
+	encoders = map[string]*sync.Pool{
+		encodingGzip: {New: func() any { return gzip.NewWriter(nil) }},
+		encodingZstd: {New: func() any {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return w
+		}},
+		encodingBrotli: {New: func() any { return brotli.NewWriterLevel(nil, brotli.DefaultCompression) }},
+	}
+	decoders = map[string]*sync.Pool{
+		encodingGzip: {New: func() any { return &gzipDecoder{} }},
+		encodingZstd: {New: func() any {
+			r, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			return r
+		}},
+		encodingBrotli: {New: func() any { return brotli.NewReader(nil) }},
+	}
+)
+
+// gzipDecoder нужен только для того, чтобы gzip.Reader подходил под интерфейс decoder.
+type gzipDecoder struct {
+	gzip.Reader
+}
+
+// GzipMiddleware - Compression с параметрами по умолчанию.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return Compression(DefaultCompressionOptions())(next)
+}
+
+// Compression распаковывает тела запросов, сжатые gzip, zstd или br, и сжимает ответы
+// в кодировке, выбранной по заголовку Accept-Encoding клиента. Сжимаются только ответы
+// не меньше opts.MinSize с типом содержимого из opts.ContentTypes.
+// На запрос с неизвестной кодировкой отвечает 415.
+func Compression(opts CompressionOptions) func(http.Handler) http.Handler {
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = DefaultCompressionTypes
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Accept-Encoding", RequestEncodings)
+
+			release, ok := decodeRequest(w, r)
+			if !ok {
+				return
+			}
+			defer release()
+
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == encodingIdentity || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, options: opts}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// decodeRequest подменяет тело запроса распакованным. Возвращенную функцию нужно
+// вызвать после обработки запроса, чтобы вернуть декодировщик в пул.
+// При ошибке ответ записывается в w, а второе значение равно false.
+func decodeRequest(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if len(encoding) == 0 || encoding == encodingIdentity {
+		return func() {}, true
+	}
+
+	pool, ok := decoders[encoding]
+	if !ok {
+		http.Error(w, "unsupported content encoding "+encoding, http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	// Тело распаковывается по мере чтения, поэтому спан заканчивается,
+	// когда тело дочитано или обработка запроса завершена.
+	_, span := tracing.Start(r.Context(), "decompress", trace.WithAttributes(attribute.String("content_encoding", encoding)))
+	d := pool.Get().(decoder)
+	if err := d.Reset(r.Body); err != nil {
+		pool.Put(d)
+		tracing.End(span, err)
+		http.Error(w, "failed to read "+encoding+" body", http.StatusBadRequest)
+		logger.FromContext(r.Context()).Error(err.Error())
+		return nil, false
+	}
+
+	body := r.Body
+	r.Body = io.NopCloser(&tracedReader{Reader: d, span: span})
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return func() {
+		span.End()
+		body.Close()
+		pool.Put(d)
+	}, true
+}
+
+// tracedReader завершает спан, когда чтение из Reader заканчивается или прерывается ошибкой.
+type tracedReader struct {
+	io.Reader
+	span trace.Span
+	read int64
+}
+
+func (r *tracedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if err != nil {
+		r.span.SetAttributes(attribute.Int64("decompressed_bytes", r.read))
+		if errors.Is(err, io.EOF) {
+			r.span.End()
+		} else {
+			tracing.End(r.span, err)
+		}
+	}
+	return n, err
+}
+
+// negotiateEncoding выбирает кодировку ответа по заголовку Accept-Encoding с учетом q.
+// Кодировки, не перечисленные в заголовке, допустимы, только если есть *.
+func negotiateEncoding(header string) string {
+	if len(header) == 0 {
+		return encodingIdentity
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := encodingIdentity, 0.0
+	for _, encoding := range responseEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressResponseWriter откладывает отправку заголовков, пока не наберется
+// opts.MinSize байт ответа или обработчик не завершится, и по ним решает, сжимать ли ответ.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	options  CompressionOptions
+	status   int
+	buf      []byte
+	started  bool
+	encoder  encoder
+}
+
+func (w *compressResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.options.MinSize {
+			return len(b), nil
+		}
+		return len(b), w.start()
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush отправляет накопленное клиенту. Если решение о сжатии еще не принято,
+// ответ уходит без сжатия, если он меньше opts.MinSize.
+func (w *compressResponseWriter) Flush() {
+	if !w.started {
+		w.start()
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close дописывает ответ и возвращает кодировщик в пул.
+func (w *compressResponseWriter) Close() error {
+	var err error
+	if !w.started {
+		err = w.start()
+	}
+	if w.encoder != nil {
+		if closeErr := w.encoder.Close(); err == nil {
+			err = closeErr
+		}
+		w.encoder.Reset(nil)
+		encoders[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+	return err
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start отправляет заголовки и накопленную часть ответа.
+func (w *compressResponseWriter) start() error {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if len(w.buf) != 0 && len(h.Get("Content-Type")) == 0 {
+		// Тип определяется до сжатия, иначе net/http определит его по сжатым данным.
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if len(w.buf) != 0 && len(w.buf) >= w.options.MinSize && w.compressible() {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.encoder = encoders[w.encoding].Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible проверяет, что ответ еще не сжат и его тип содержимого разрешен.
+func (w *compressResponseWriter) compressible() bool {
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	if len(w.Header().Get("Content-Encoding")) != 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.options.ContentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
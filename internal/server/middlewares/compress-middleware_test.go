@@ -0,0 +1,185 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: encodingIdentity},
+		{header: "gzip", want: encodingGzip},
+		{header: "gzip, deflate, br", want: encodingBrotli},
+		{header: "gzip, deflate, br, zstd", want: encodingZstd},
+		{header: "zstd;q=0.5, gzip", want: encodingGzip},
+		{header: "br;q=0.8, gzip;q=0.8", want: encodingBrotli},
+		{header: "*", want: encodingZstd},
+		{header: "*;q=0.5, zstd;q=0", want: encodingBrotli},
+		{header: "gzip;q=0", want: encodingIdentity},
+		{header: "deflate, identity", want: encodingIdentity},
+		{header: "gzip;q=bad, br", want: encodingBrotli},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, negotiateEncoding(test.header), test.header)
+	}
+}
+
+func decodeResponse(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case encodingGzip:
+		gr, err := gzip.NewReader(body)
+		require.NoError(t, err)
+		reader = gr
+	case encodingZstd:
+		zr, err := zstd.NewReader(body)
+		require.NoError(t, err)
+		defer zr.Close()
+		reader = zr
+	case encodingBrotli:
+		reader = brotli.NewReader(body)
+	default:
+		reader = body
+	}
+	decoded, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestCompressionResponses(t *testing.T) {
+	large := strings.Repeat(`{"id":"Alloc","type":"gauge","value":1}`, 20)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		preEncoded     string
+		status         int
+		body           string
+		want           string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", contentType: "application/json", body: large, want: encodingGzip},
+		{name: "zstd", acceptEncoding: "gzip, zstd", contentType: "application/json", body: large, want: encodingZstd},
+		{name: "brotli", acceptEncoding: "br", contentType: "text/html; charset=utf-8", body: large, want: encodingBrotli},
+		{name: "sniffed text", acceptEncoding: "gzip", body: large, want: encodingGzip},
+		{name: "below min size", acceptEncoding: "gzip", contentType: "application/json", body: `{"ok":true}`},
+		{name: "not allowed type", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "already encoded", acceptEncoding: "gzip", contentType: "application/json", preEncoded: "br", body: large, want: "br"},
+		{name: "not acceptable", acceptEncoding: "deflate", contentType: "application/json", body: large},
+		{name: "no content", acceptEncoding: "gzip", status: http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(test.contentType) != 0 {
+					w.Header().Set("Content-Type", test.contentType)
+				}
+				if len(test.preEncoded) != 0 {
+					w.Header().Set("Content-Encoding", test.preEncoded)
+				}
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+				}
+				// Ответ пишется частями, чтобы проверить накопление до MinSize.
+				for _, part := range strings.SplitAfter(test.body, "}") {
+					w.Write([]byte(part))
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			encoding := rec.Header().Get("Content-Encoding")
+			assert.Equal(t, test.want, encoding)
+			assert.Contains(t, rec.Header().Values("Vary"), "Accept-Encoding")
+			if test.status != 0 {
+				assert.Equal(t, test.status, rec.Code)
+			}
+			if len(test.preEncoded) == 0 {
+				assert.Equal(t, test.body, decodeResponse(t, encoding, rec.Body))
+			}
+			if len(test.body) != 0 {
+				assert.NotEmpty(t, rec.Header().Get("Content-Type"))
+				assert.NotContains(t, rec.Header().Get("Content-Type"), "gzip", "type is detected before compression")
+			}
+		})
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	handler := Compression(CompressionOptions{MinSize: 1, ContentTypes: []string{"application/octet-stream"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+			w.Write([]byte("payload"))
+		}))
+
+	for contentType, want := range map[string]string{
+		"application/octet-stream": encodingGzip,
+		"application/json":         "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?type="+contentType, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, want, rec.Header().Get("Content-Encoding"), contentType)
+		assert.Equal(t, "payload", decodeResponse(t, want, rec.Body))
+	}
+}
+
+func TestCompressionDecodesBrotliRequest(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	bw := brotli.NewWriter(buf)
+	bw.Write([]byte("request body"))
+	bw.Close()
+
+	handler := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "request body", string(body))
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+	}))
+
+	// Декодировщики берутся из пула, поэтому запрос повторяется.
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Content-Encoding", "br")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func BenchmarkCompression(b *testing.B) {
+	body := []byte(strings.Repeat(`{"id":"Alloc","type":"gauge","value":1}`, 100))
+	for _, encoding := range responseEncodings {
+		b.Run(encoding, func(b *testing.B) {
+			handler := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
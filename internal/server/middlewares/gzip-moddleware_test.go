@@ -1,11 +1,15 @@
 package middlewares
 
 import (
+	"bytes"
 	"compress/gzip"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestGzipMiddlewareWithoutGzipSupport(t *testing.T) {
@@ -30,9 +34,10 @@ func TestGzipMiddlewareWithoutGzipSupport(t *testing.T) {
 }
 
 func TestGzipMiddlewareWithGzipSupport(t *testing.T) {
+	response := strings.Repeat("gzip response", DefaultCompressionMinSize)
 	handler := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("gzip response"))
+		w.Write([]byte(response))
 	}))
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -56,18 +61,17 @@ func TestGzipMiddlewareWithGzipSupport(t *testing.T) {
 		t.Fatalf("Failed to read uncompressed response: %v", err)
 	}
 
-	if string(uncompressedBody) != "gzip response" {
-		t.Errorf("Expected response body to be 'gzip response', but got '%s'", string(uncompressedBody))
+	if string(uncompressedBody) != response {
+		t.Errorf("Expected response body to be '%s', but got '%s'", response, string(uncompressedBody))
 	}
 }
 
 func TestGzipResponseWriter(t *testing.T) {
 	rec := httptest.NewRecorder()
-	gz := gzip.NewWriter(rec)
-
-	gzw := gzipResponseWriter{
+	gzw := &compressResponseWriter{
 		ResponseWriter: rec,
-		Writer:         gz,
+		encoding:       encodingGzip,
+		options:        CompressionOptions{ContentTypes: DefaultCompressionTypes},
 	}
 
 	_, err := gzw.Write([]byte("test response"))
@@ -75,7 +79,7 @@ func TestGzipResponseWriter(t *testing.T) {
 		t.Fatalf("Failed to write gzip response: %v", err)
 	}
 
-	err = gz.Close()
+	err = gzw.Close()
 	if err != nil {
 		t.Fatalf("Failed to close gzip.Writer: %v", err)
 	}
@@ -95,3 +99,51 @@ func TestGzipResponseWriter(t *testing.T) {
 		t.Errorf("Expected uncompressed body to be 'test response', but got '%s'", string(uncompressedBody))
 	}
 }
+
+func TestGzipMiddlewareDecodesRequestBody(t *testing.T) {
+	gzipped := bytes.NewBuffer(nil)
+	zb := gzip.NewWriter(gzipped)
+	zb.Write([]byte("request body"))
+	zb.Close()
+	zw, _ := zstd.NewWriter(nil)
+	zstdBody := zw.EncodeAll([]byte("request body"), nil)
+	zw.Close()
+
+	tests := []struct {
+		encoding string
+		body     []byte
+		want     int
+	}{
+		{encoding: "", body: []byte("request body"), want: http.StatusOK},
+		{encoding: "gzip", body: gzipped.Bytes(), want: http.StatusOK},
+		{encoding: "zstd", body: zstdBody, want: http.StatusOK},
+		{encoding: "gzip", body: []byte("not gzip"), want: http.StatusBadRequest},
+		{encoding: "deflate", body: []byte("request body"), want: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.encoding, func(t *testing.T) {
+			handler := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil || string(body) != "request body" {
+					t.Errorf("Expected decoded body 'request body', got '%s' (%v)", body, err)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+			if len(test.encoding) != 0 {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.want {
+				t.Errorf("Expected status code %d, got %d", test.want, rec.Code)
+			}
+			if rec.Header().Get("Accept-Encoding") != RequestEncodings {
+				t.Errorf("Expected Accept-Encoding '%s', got '%s'", RequestEncodings, rec.Header().Get("Accept-Encoding"))
+			}
+		})
+	}
+}
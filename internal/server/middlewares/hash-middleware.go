@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"strings"
+
+	hash "github.com/evildead81/metrics-and-alerts/internal/hash"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// hashResponseWriter буферизует ответ, чтобы подписать его тело до отправки заголовков.
+type hashResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *hashResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *hashResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+// HashMiddleware проверяет подпись HMAC-SHA256 тела запросов на запись и подписывает все ответы.
+// Подпись считается по телу после распаковки, но до расшифровки, так же, как это делает агент.
+// Если required выставлен, запросы на обновление метрик (/update/..., /updates/)
+// без заголовка подписи отклоняются.
+// При пустом ключе middleware ничего не делает.
+func HashMiddleware(key string, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(key) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hw := &hashResponseWriter{ResponseWriter: w}
+			if verifyRequest(hw, r, key, required) {
+				next.ServeHTTP(hw, r)
+			}
+
+			sign, err := hash.Hash(hw.body.Bytes(), key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				logger.FromContext(r.Context()).Error(err.Error())
+				return
+			}
+			w.Header().Set(hash.HashHeaderKey, sign)
+			if hw.status == 0 {
+				hw.status = http.StatusOK
+			}
+			w.WriteHeader(hw.status)
+			w.Write(hw.body.Bytes())
+		})
+	}
+}
+
+// verifyRequest проверяет подпись тела запроса на запись и возвращает тело обратно в запрос.
+// При ошибке ответ записывается в w, а функция возвращает false.
+func verifyRequest(w http.ResponseWriter, r *http.Request, key string, required bool) (ok bool) {
+	if !isWriteRequest(r) {
+		return true
+	}
+
+	_, span := tracing.Start(r.Context(), "verify")
+	defer func() {
+		span.SetAttributes(attribute.Bool("verified", ok))
+		span.End()
+	}()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", BodyErrorStatus(err))
+		logger.FromContext(r.Context()).Error(err.Error())
+		return false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	sign := r.Header.Get(hash.HashHeaderKey)
+	if len(sign) == 0 {
+		if required && isUpdateRequest(r) {
+			http.Error(w, "Missing hash header", http.StatusBadRequest)
+			logger.FromContext(r.Context()).Error("Missing hash header")
+			return false
+		}
+		return true
+	}
+
+	expected, err := hash.Hash(body, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.FromContext(r.Context()).Error(err.Error())
+		return false
+	}
+	if !hmac.Equal([]byte(sign), []byte(expected)) {
+		http.Error(w, "Incorrect hash header", http.StatusBadRequest)
+		logger.FromContext(r.Context()).Error("Incorrect hash header")
+		return false
+	}
+
+	return true
+}
+
+// isUpdateRequest сообщает, обновляет ли запрос метрики. Остальные запросы,
+// например POST /value/, читают данные и могут приходить без подписи.
+func isUpdateRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/update/") || r.URL.Path == "/updates/"
+}
+
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	hash "github.com/evildead81/metrics-and-alerts/internal/hash"
+)
+
+func echoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	})
+}
+
+func TestHashMiddlewareWithoutKey(t *testing.T) {
+	handler := HashMiddleware("", true)(echoHandler())
+
+	req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader("payload"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, but got %d", http.StatusAccepted, rec.Code)
+	}
+	if rec.Header().Get(hash.HashHeaderKey) != "" {
+		t.Errorf("Expected no hash header, but got '%s'", rec.Header().Get(hash.HashHeaderKey))
+	}
+}
+
+func TestHashMiddlewareVerifiesRequest(t *testing.T) {
+	const key = "secret"
+	validSign, _ := hash.Hash([]byte("payload"), key)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		sign     string
+		required bool
+		want     int
+	}{
+		{name: "valid signature", method: http.MethodPost, sign: validSign, want: http.StatusAccepted},
+		{name: "invalid signature", method: http.MethodPost, sign: "invalid", want: http.StatusBadRequest},
+		{name: "missing signature", method: http.MethodPost, want: http.StatusAccepted},
+		{name: "missing signature when required", method: http.MethodPost, required: true, want: http.StatusBadRequest},
+		{name: "read request when required", method: http.MethodGet, required: true, want: http.StatusAccepted},
+		{name: "batch update when required", method: http.MethodPost, path: "/updates/", required: true, want: http.StatusBadRequest},
+		{name: "update by params when required", method: http.MethodPost, path: "/update/gauge/a/1", required: true, want: http.StatusBadRequest},
+		{name: "value by JSON when required", method: http.MethodPost, path: "/value/", required: true, want: http.StatusAccepted},
+		{name: "invalid signature on value by JSON", method: http.MethodPost, path: "/value/", sign: "invalid", want: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := HashMiddleware(key, test.required)(echoHandler())
+
+			path := test.path
+			if len(path) == 0 {
+				path = "/update/"
+			}
+			req := httptest.NewRequest(test.method, path, strings.NewReader("payload"))
+			if len(test.sign) != 0 {
+				req.Header.Set(hash.HashHeaderKey, test.sign)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.want {
+				t.Errorf("Expected status %d, but got %d", test.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHashMiddlewareSignsResponse(t *testing.T) {
+	const key = "secret"
+	handler := HashMiddleware(key, false)(echoHandler())
+
+	req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader("payload"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Body.String() != "payload" {
+		t.Errorf("Expected response body to be 'payload', but got '%s'", rec.Body.String())
+	}
+
+	expected, _ := hash.Hash([]byte("payload"), key)
+	if rec.Header().Get(hash.HashHeaderKey) != expected {
+		t.Errorf("Expected hash header '%s', but got '%s'", expected, rec.Header().Get(hash.HashHeaderKey))
+	}
+}
@@ -1,12 +1,19 @@
 package middlewares
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"time"
 
-	"github.com/evildead81/metrics-and-alerts/internal/server/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
 )
 
+// maxRequestIDLength - более длинный идентификатор запроса от клиента заменяется своим.
+const maxRequestIDLength = 128
+
 type (
 	responseData struct {
 		status int
@@ -30,10 +37,28 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// WithLogging пишет в лог запрос и ответ на него. Идентификатор запроса, адрес клиента,
+// идентификатор агента и трассы сохраняются в контексте запроса, и logger.FromContext
+// добавляет их ко всем записям обработчиков. Идентификатор запроса возвращается
+// в заголовке ответа.
 func WithLogging(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		requestID := r.Header.Get(contracts.RequestIDHeader)
+		if len(requestID) == 0 || len(requestID) > maxRequestIDLength {
+			requestID = newRequestID()
+		}
+		w.Header().Set(contracts.RequestIDHeader, requestID)
+		fields := []any{"request_id", requestID, "remote_addr", r.RemoteAddr}
+		if agentID := r.Header.Get(contracts.AgentIDHeader); len(agentID) != 0 {
+			fields = append(fields, "agent_id", agentID)
+		}
+		if traceID := tracing.TraceID(r.Context()); len(traceID) != 0 {
+			fields = append(fields, "trace_id", traceID)
+		}
+		r = r.WithContext(logger.WithFields(r.Context(), fields...))
+
 		responseData := &responseData{
 			status: 0,
 			size:   0,
@@ -46,7 +71,7 @@ func WithLogging(h http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
-		logger.Logger.Infoln(
+		logger.FromContext(r.Context()).Infow("Request handled",
 			"uri", r.RequestURI,
 			"method", r.Method,
 			"status", responseData.status,
@@ -56,3 +81,9 @@ func WithLogging(h http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(logFn)
 }
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -4,6 +4,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestLoggingResponseWriterWrite(t *testing.T) {
@@ -44,3 +49,40 @@ func TestLoggingResponseWriterWriteHeader(t *testing.T) {
 		t.Errorf("Expected status code %d, but got %d", statusCode, responseData.status)
 	}
 }
+
+func TestWithLoggingRequestFields(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	saved := logger.Logger
+	logger.Logger = *zap.New(core).Sugar()
+	defer func() { logger.Logger = saved }()
+
+	handler := WithLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Info("Handling")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	req.Header.Set(contracts.AgentIDHeader, "host-1")
+	req.Header.Set(contracts.RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(contracts.RequestIDHeader); got != "req-1" {
+		t.Errorf("Expected client request ID to be echoed, got %q", got)
+	}
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("Expected handler and request entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		if fields["request_id"] != "req-1" || fields["agent_id"] != "host-1" || fields["remote_addr"] != req.RemoteAddr {
+			t.Errorf("Entry %q lacks request fields: %v", entry.Message, fields)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(rec.Header().Get(contracts.RequestIDHeader)) == 0 {
+		t.Errorf("Expected request ID to be generated")
+	}
+}
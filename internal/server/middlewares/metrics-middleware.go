@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/selfmetrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithMetrics учитывает число и длительность запросов по маршрутам, методам и кодам ответа.
+// Маршрут берется из шаблона chi, поэтому имена метрик в пути не увеличивают число серий.
+// Запросы, не попавшие ни в один маршрут, учитываются с маршрутом unmatched.
+func WithMetrics(reg *selfmetrics.Registry) func(http.Handler) http.Handler {
+	requests := reg.Counter("server_http_requests_total",
+		"HTTP requests by route, method and status.", "route", "method", "status")
+	duration := reg.Histogram("server_http_request_duration_seconds",
+		"HTTP request latency by route, method and status.", nil, "route", "method", "status")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			data := &responseData{}
+			next.ServeHTTP(&loggingResponseWriter{ResponseWriter: w, responseData: data}, r)
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePattern()) != 0 {
+				route = rctx.RoutePattern()
+			}
+			status := data.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			labels := []string{route, r.Method, strconv.Itoa(status)}
+			requests.With(labels...).Inc()
+			duration.With(labels...).Observe(time.Since(start).Seconds())
+		})
+	}
+}
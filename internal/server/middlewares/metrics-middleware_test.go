@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/selfmetrics"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestWithMetrics(t *testing.T) {
+	reg := selfmetrics.NewRegistry()
+	r := chi.NewRouter()
+	r.Use(WithMetrics(reg))
+	r.Get("/value/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1"))
+	})
+	r.Post("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/value/a", nil),
+		httptest.NewRequest(http.MethodGet, "/value/b", nil),
+		httptest.NewRequest(http.MethodPost, "/fail", nil),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var sb strings.Builder
+	reg.WriteText(&sb)
+	for _, want := range []string{
+		`server_http_requests_total{route="/value/{name}",method="GET",status="200"} 2`,
+		`server_http_requests_total{route="/fail",method="POST",status="500"} 1`,
+		`server_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`server_http_request_duration_seconds_count{route="/value/{name}",method="GET",status="200"} 2`,
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("Expected '%s' in metrics:\n%s", want, sb.String())
+		}
+	}
+}
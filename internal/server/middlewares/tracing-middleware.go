@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracing начинает серверный спан запроса, продолжая трассу из заголовка traceparent.
+// Спан называется по шаблону маршрута chi, как и в WithMetrics.
+// Должен стоять первым, чтобы остальные middleware создавали дочерние спаны.
+func WithTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		data := &responseData{}
+		next.ServeHTTP(&loggingResponseWriter{ResponseWriter: w, responseData: data}, r.WithContext(ctx))
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(ctx); rctx != nil && len(rctx.RoutePattern()) != 0 {
+			route = rctx.RoutePattern()
+		}
+		status := data.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.route", route),
+			attribute.Int("http.response.status_code", status),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
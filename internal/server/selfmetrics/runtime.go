@@ -0,0 +1,50 @@
+package selfmetrics
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// RegisterRuntime регистрирует метрики среды выполнения Go: горутины и память.
+// Статистика памяти читается не чаще раза в секунду, так как runtime.ReadMemStats
+// останавливает программу.
+func RegisterRuntime(r *Registry) {
+	var (
+		mutex sync.Mutex
+		stats runtime.MemStats
+		read  time.Time
+	)
+	memStats := func(field func(*runtime.MemStats) uint64) func() float64 {
+		return func() float64 {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if time.Since(read) > time.Second {
+				runtime.ReadMemStats(&stats)
+				read = time.Now()
+			}
+			return float64(field(&stats))
+		}
+	}
+
+	r.GaugeFunc("go_goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.GaugeFunc("go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects.",
+		memStats(func(s *runtime.MemStats) uint64 { return s.HeapAlloc }))
+	r.GaugeFunc("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.",
+		memStats(func(s *runtime.MemStats) uint64 { return s.HeapInuse }))
+	r.GaugeFunc("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.",
+		memStats(func(s *runtime.MemStats) uint64 { return s.Sys }))
+	r.GaugeFunc("go_memstats_gc_cycles", "Number of completed GC cycles.",
+		memStats(func(s *runtime.MemStats) uint64 { return uint64(s.NumGC) }))
+}
+
+// Handler отдает метрики реестра в текстовом формате Prometheus.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	}
+}
@@ -0,0 +1,229 @@
+// Package selfmetrics - метрики работы самого сервера в текстовом формате Prometheus.
+package selfmetrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets - границы гистограмм длительностей в секундах по умолчанию.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry хранит метрики сервера и выводит их в текстовом формате Prometheus.
+type Registry struct {
+	mutex    *sync.Mutex
+	families map[string]*family
+}
+
+// family - метрика с одним именем и набором серий, различающихся значениями меток.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	mutex   *sync.Mutex
+	series  map[string]*series
+	fn      func() float64
+}
+
+// series - значения одной серии. Для счетчика используется только count.
+type series struct {
+	labels []string
+	count  atomic.Int64
+	sum    atomic.Uint64
+	// buckets - число наблюдений по границам, без накопления.
+	buckets []atomic.Int64
+}
+
+// NewRegistry создает пустой реестр.
+func NewRegistry() *Registry {
+	return &Registry{
+		mutex:    &sync.Mutex{},
+		families: make(map[string]*family),
+	}
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, ok := r.families[f.name]; ok {
+		if existing.kind != f.kind {
+			panic("selfmetrics: " + f.name + " is already registered as " + existing.kind)
+		}
+		return existing
+	}
+	f.mutex = &sync.Mutex{}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+	return f
+}
+
+// Counter регистрирует счетчик с метками labels. Повторная регистрация
+// возвращает уже существующий счетчик.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Histogram регистрирует гистограмму с границами buckets и метками labels.
+// При пустом buckets используется DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{r.register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+// GaugeFunc регистрирует gauge без меток, значение которого вычисляется fn при каждом выводе.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// with возвращает серию с указанными значениями меток, создавая ее при первом обращении.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("selfmetrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...), buckets: make([]atomic.Int64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+// CounterVec - счетчик с метками.
+type CounterVec struct {
+	f *family
+}
+
+// Counter - серия счетчика.
+type Counter struct {
+	s *series
+}
+
+// With возвращает серию счетчика с указанными значениями меток в порядке регистрации.
+func (c *CounterVec) With(values ...string) Counter {
+	return Counter{c.f.with(values)}
+}
+
+// Inc увеличивает счетчик на единицу.
+func (c Counter) Inc() {
+	c.s.count.Add(1)
+}
+
+// Add увеличивает счетчик на n.
+func (c Counter) Add(n int64) {
+	c.s.count.Add(n)
+}
+
+// HistogramVec - гистограмма с метками.
+type HistogramVec struct {
+	f *family
+}
+
+// Histogram - серия гистограммы.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// With возвращает серию гистограммы с указанными значениями меток в порядке регистрации.
+func (h *HistogramVec) With(values ...string) Histogram {
+	return Histogram{s: h.f.with(values), buckets: h.f.buckets}
+}
+
+// Observe учитывает одно наблюдение.
+func (h Histogram) Observe(value float64) {
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		h.s.buckets[i].Add(1)
+	}
+	h.s.count.Add(1)
+	for {
+		old := h.s.sum.Load()
+		if h.s.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+value)) {
+			return
+		}
+	}
+}
+
+// WriteText выводит все метрики в текстовом формате Prometheus, отсортированными по имени.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var sb strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		if f.fn != nil {
+			fmt.Fprintf(&sb, "%s %s\n", f.name, formatValue(f.fn()))
+			continue
+		}
+
+		f.mutex.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f.writeSeries(&sb, f.series[key])
+		}
+		f.mutex.Unlock()
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (f *family) writeSeries(sb *strings.Builder, s *series) {
+	if f.kind == "counter" {
+		fmt.Fprintf(sb, "%s%s %d\n", f.name, formatLabels(f.labels, s.labels), s.count.Load())
+		return
+	}
+
+	labels := append(append([]string(nil), f.labels...), "le")
+	var cumulative int64
+	for i, bound := range f.buckets {
+		cumulative += s.buckets[i].Load()
+		values := append(append([]string(nil), s.labels...), formatValue(bound))
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", f.name, formatLabels(labels, values), cumulative)
+	}
+	count := s.count.Load()
+	values := append(append([]string(nil), s.labels...), "+Inf")
+	fmt.Fprintf(sb, "%s_bucket%s %d\n", f.name, formatLabels(labels, values), count)
+	fmt.Fprintf(sb, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labels), formatValue(math.Float64frombits(s.sum.Load())))
+	fmt.Fprintf(sb, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labels), count)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
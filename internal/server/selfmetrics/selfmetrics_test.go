@@ -0,0 +1,78 @@
+package selfmetrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.Counter("requests_total", "Requests.", "route", "status")
+	requests.With("/a", "200").Inc()
+	requests.With("/a", "200").Add(2)
+	requests.With("/b", "500").Inc()
+
+	latency := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.With("/a").Observe(0.05)
+	latency.With("/a").Observe(0.1)
+	latency.With("/a").Observe(0.5)
+	latency.With("/a").Observe(3)
+
+	reg.GaugeFunc("temperature", "Temperature.", func() float64 { return 36.6 })
+
+	var sb strings.Builder
+	if err := reg.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 3
+latency_seconds_bucket{route="/a",le="+Inf"} 4
+latency_seconds_sum{route="/a"} 3.65
+latency_seconds_count{route="/a"} 4
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a",status="200"} 3
+requests_total{route="/b",status="500"} 1
+# HELP temperature Temperature.
+# TYPE temperature gauge
+temperature 36.6
+`
+	if sb.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("requests_total", "Requests.", "route").With("/a").Inc()
+	reg.Counter("requests_total", "Requests.", "route").With("/a").Inc()
+
+	var sb strings.Builder
+	reg.WriteText(&sb)
+	if !strings.Contains(sb.String(), `requests_total{route="/a"} 2`) {
+		t.Errorf("Expected repeated registration to share the counter, got:\n%s", sb.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic when registering a histogram under a counter name")
+		}
+	}()
+	reg.Histogram("requests_total", "Requests.", nil)
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	reg := NewRegistry()
+	RegisterRuntime(reg)
+
+	var sb strings.Builder
+	reg.WriteText(&sb)
+	for _, name := range []string{"go_goroutines ", "go_memstats_heap_alloc_bytes ", "go_memstats_sys_bytes "} {
+		if !strings.Contains(sb.String(), "\n"+name) {
+			t.Errorf("Expected %s in output:\n%s", name, sb.String())
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Package boltstorage - хранилище метрик во встроенной базе bbolt в одном файле.
+// Подходит для небольших установок, которым нужна надежность без отдельного сервера БД:
+// каждое изменение записывается транзакцией, которая сбрасывается на диск до ответа.
+package boltstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Корзины базы. Значения метрик хранятся в gauges и counters по имени,
+// время их обновления - в updated по ключу seriesKey. История лежит во вложенных
+// корзинах samples по seriesKey, свернутая история - в rollups по интервалу уровня
+// и seriesKey. Ключи истории начинаются со времени, поэтому упорядочены по нему.
+var (
+	gaugesBucket   = []byte("gauges")
+	countersBucket = []byte("counters")
+	updatedBucket  = []byte("updated")
+	samplesBucket  = []byte("samples")
+	rollupsBucket  = []byte("rollups")
+)
+
+type BoltStorage struct {
+	db   *bolt.DB
+	path string
+}
+
+// New открывает или создает базу в файле path.
+func New(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugesBucket, countersBucket, updatedBucket, samplesBucket, rollupsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init %s: %w", path, err)
+	}
+	return &BoltStorage{db: db, path: path}, nil
+}
+
+// Close закрывает базу.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// StoragePath возвращает путь к файлу базы.
+func (s *BoltStorage) StoragePath() string {
+	return s.path
+}
+
+func (s *BoltStorage) UpdateCounter(name string, value int64) error {
+	return s.UpdateMetrics([]contracts.Metrics{{ID: name, MType: consts.Counter, Delta: &value}})
+}
+
+func (s *BoltStorage) UpdateGauge(name string, value float64) error {
+	return s.UpdateMetrics([]contracts.Metrics{{ID: name, MType: consts.Gauge, Value: &value}})
+}
+
+// UpdateMetrics обновляет список метрик в одной транзакции. Если хотя бы одна
+// метрика некорректна или не записалась, хранилище не меняется. Одновременные
+// обновления объединяются в общую транзакцию, чтобы делить один сброс на диск.
+func (s *BoltStorage) UpdateMetrics(metrics []contracts.Metrics) error {
+	for _, metric := range metrics {
+		if err := metric.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, metric := range metrics {
+			var value float64
+			switch metric.MType {
+			case consts.Gauge:
+				value = *metric.Value
+				if err := tx.Bucket(gaugesBucket).Put([]byte(metric.ID), encodeFloat(value)); err != nil {
+					return err
+				}
+			case consts.Counter:
+				counters := tx.Bucket(countersBucket)
+				var total int64
+				if v := counters.Get([]byte(metric.ID)); v != nil {
+					total = decodeInt(v)
+				}
+				total += *metric.Delta
+				if err := counters.Put([]byte(metric.ID), encodeInt(total)); err != nil {
+					return err
+				}
+				value = float64(total)
+			}
+			if err := record(tx, metric.MType, metric.ID, value, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateMetricsContext - UpdateMetrics, прерываемый по ctx до начала записи.
+func (s *BoltStorage) UpdateMetricsContext(ctx context.Context, metrics []contracts.Metrics) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.UpdateMetrics(metrics)
+}
+
+// record запоминает время обновления метрики и добавляет значение в историю.
+func record(tx *bolt.Tx, mtype, id string, value float64, now time.Time) error {
+	key := seriesKey(mtype, id)
+	if err := tx.Bucket(updatedBucket).Put(key, encodeInt(now.UnixNano())); err != nil {
+		return err
+	}
+	samples, err := tx.Bucket(samplesBucket).CreateBucketIfNotExists(key)
+	if err != nil {
+		return err
+	}
+	// Порядковый номер в ключе не дает затереть значение с тем же временем.
+	seq, err := samples.NextSequence()
+	if err != nil {
+		return err
+	}
+	return samples.Put(binary.BigEndian.AppendUint64(timeKey(now), seq), encodeFloat(value))
+}
+
+func (s *BoltStorage) GetCounters() map[string]int64 {
+	counters := make(map[string]int64)
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(countersBucket).ForEach(func(k, v []byte) error {
+			counters[string(k)] = decodeInt(v)
+			return nil
+		})
+	})
+	return counters
+}
+
+func (s *BoltStorage) GetGauges() map[string]float64 {
+	gauges := make(map[string]float64)
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(gaugesBucket).ForEach(func(k, v []byte) error {
+			gauges[string(k)] = decodeFloat(v)
+			return nil
+		})
+	})
+	return gauges
+}
+
+func (s *BoltStorage) GetGaugeValueByName(name string) (float64, error) {
+	var value float64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(gaugesBucket).Get([]byte(name))
+		if v == nil {
+			return errors.New("Gauge metric with name " + name + " not found")
+		}
+		value = decodeFloat(v)
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStorage) GetCountValueByName(name string) (int64, error) {
+	var value int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(countersBucket).Get([]byte(name))
+		if v == nil {
+			return errors.New("Counter metric with name " + name + " not found")
+		}
+		value = decodeInt(v)
+		return nil
+	})
+	return value, err
+}
+
+// Restore ничего не делает: данные и так хранятся в файле базы.
+func (s *BoltStorage) Restore() error {
+	return nil
+}
+
+// Write ничего не делает: каждое изменение сбрасывается на диск при записи.
+func (s *BoltStorage) Write() error {
+	return nil
+}
+
+func (s *BoltStorage) Ping() error {
+	return s.PingContext(context.Background())
+}
+
+// PingContext проверяет, что база открыта и читается.
+func (s *BoltStorage) PingContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(gaugesBucket) == nil {
+			return errors.New("bolt storage is not initialized")
+		}
+		return nil
+	})
+}
+
+func seriesKey(mtype, id string) []byte {
+	return []byte(mtype + "/" + id)
+}
+
+// timeKey - начало ключа истории. Время до эпохи Unix не встречается,
+// поэтому порядок ключей совпадает с порядком времени.
+func timeKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+func encodeFloat(v float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+}
+
+func decodeFloat(b []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+func encodeInt(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v))
+}
+
+func decodeInt(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
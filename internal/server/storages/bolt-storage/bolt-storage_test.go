@@ -0,0 +1,128 @@
+package boltstorage
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+)
+
+func newTestStorage(t *testing.T, path string) *BoltStorage {
+	t.Helper()
+	storage, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	return storage
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	storage := newTestStorage(t, path)
+
+	delta, value := int64(3), 0.5
+	storage.UpdateCounter("requests", 2)
+	if err := storage.UpdateMetrics([]contracts.Metrics{
+		{ID: "requests", MType: consts.Counter, Delta: &delta},
+		{ID: "load", MType: consts.Gauge, Value: &value},
+	}); err != nil {
+		t.Fatalf("Failed to update metrics: %v", err)
+	}
+
+	// Пачка с некорректной метрикой не меняет хранилище.
+	if err := storage.UpdateMetrics([]contracts.Metrics{
+		{ID: "requests", MType: consts.Counter, Delta: &delta},
+		{ID: "broken", MType: consts.Gauge},
+	}); err == nil {
+		t.Errorf("Expected invalid batch to be rejected")
+	}
+
+	// Данные переживают повторное открытие базы.
+	storage.Close()
+	storage = newTestStorage(t, path)
+	defer storage.Close()
+	if v, err := storage.GetCountValueByName("requests"); err != nil || v != 5 {
+		t.Errorf("Expected counter 5, got %d, %v", v, err)
+	}
+	if v, err := storage.GetGaugeValueByName("load"); err != nil || v != 0.5 {
+		t.Errorf("Expected gauge 0.5, got %v, %v", v, err)
+	}
+	if _, err := storage.GetGaugeValueByName("missing"); err == nil {
+		t.Errorf("Expected missing gauge to be an error")
+	}
+	if len(storage.GetCounters()) != 1 || len(storage.GetGauges()) != 1 {
+		t.Errorf("Unexpected metrics %v %v", storage.GetCounters(), storage.GetGauges())
+	}
+	if err := storage.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	storage := newTestStorage(t, filepath.Join(t.TempDir(), "metrics.db"))
+	defer storage.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storage.UpdateCounter("requests", 1)
+		}()
+	}
+	wg.Wait()
+	if v, _ := storage.GetCountValueByName("requests"); v != 50 {
+		t.Errorf("Expected every update to be counted, got %d", v)
+	}
+}
+
+func TestHistoryAndExpire(t *testing.T) {
+	storage := newTestStorage(t, filepath.Join(t.TempDir(), "metrics.db"))
+	defer storage.Close()
+	ctx := context.Background()
+
+	start := time.Now()
+	storage.UpdateCounter("jobs", 2)
+	storage.UpdateCounter("jobs", 3)
+	storage.UpdateGauge("cpu_user", 1)
+	samples, err := storage.History(ctx, consts.Counter, "jobs", start, time.Now().Add(time.Second))
+	if err != nil || len(samples) != 2 || samples[0].Value != 2 || samples[1].Value != 5 {
+		t.Fatalf("Expected accumulated counter history [2 5], got %+v, %v", samples, err)
+	}
+
+	written, err := storage.Rollup(ctx, 0, time.Hour, start.Add(-time.Hour), start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Rollup failed: %v", err)
+	}
+	rollups, err := storage.Rollups(ctx, consts.Counter, "jobs", time.Hour, start.Add(-2*time.Hour), start.Add(2*time.Hour))
+	var count int64
+	for _, r := range rollups {
+		count += r.Count
+	}
+	if err != nil || count != 2 {
+		t.Errorf("Expected both samples rolled up, got %+v, %v", rollups, err)
+	}
+
+	retention := storages.Retention{
+		Rules: []storages.RetentionRule{{Pattern: "cpu_*", Keep: time.Hour}},
+		Tiers: []storages.Tier{{Resolution: time.Hour, Keep: time.Hour}},
+	}
+	expired, err := storage.Expire(ctx, retention, time.Now().Add(3*time.Hour))
+	if err != nil || expired.Samples != 1 || expired.Series != 0 || expired.Rollups != written {
+		t.Errorf("Expected cpu_user history and old rollups to expire, got %+v, %v", expired, err)
+	}
+
+	retention.StaleAfter = time.Minute
+	expired, err = storage.Expire(ctx, retention, time.Now().Add(2*time.Minute))
+	if err != nil || expired != (storages.Expired{Samples: 2, Series: 2}) {
+		t.Errorf("Expected stale metrics and their history to expire, got %+v, %v", expired, err)
+	}
+	if len(storage.GetCounters()) != 0 || len(storage.GetGauges()) != 0 {
+		t.Errorf("Expected storage to be empty, got %v %v", storage.GetCounters(), storage.GetGauges())
+	}
+}
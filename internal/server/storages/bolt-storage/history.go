@@ -0,0 +1,170 @@
+package boltstorage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	bolt "go.etcd.io/bbolt"
+)
+
+// History возвращает историю значений метрики с from включительно по to исключительно.
+func (s *BoltStorage) History(ctx context.Context, mtype, id string, from, to time.Time) ([]storages.Sample, error) {
+	samples := make([]storages.Sample, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(samplesBucket).Bucket(seriesKey(mtype, id))
+		if b == nil {
+			return nil
+		}
+		end := timeKey(to)
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k[:8], end) < 0; k, v = c.Next() {
+			samples = append(samples, storages.Sample{Time: keyTime(k), Value: decodeFloat(v)})
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// Expire удаляет метрики, не обновлявшиеся дольше retention.StaleAfter, вместе
+// с их историей, и историю и свернутую историю старше сроков хранения.
+func (s *BoltStorage) Expire(ctx context.Context, retention storages.Retention, now time.Time) (storages.Expired, error) {
+	var expired storages.Expired
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		expired = storages.Expired{}
+		if retention.StaleAfter > 0 {
+			if err := expireStale(tx, now.Add(-retention.StaleAfter), &expired); err != nil {
+				return err
+			}
+		}
+
+		samples := tx.Bucket(samplesBucket)
+		var series [][]byte
+		samples.ForEachBucket(func(k []byte) error {
+			series = append(series, k)
+			return nil
+		})
+		for _, key := range series {
+			mtype, id, _ := strings.Cut(string(key), "/")
+			ttl := retention.HistoryTTL(mtype, id)
+			if ttl <= 0 {
+				continue
+			}
+			n, err := deleteBefore(samples, key, now.Add(-ttl))
+			if err != nil {
+				return err
+			}
+			expired.Samples += n
+		}
+
+		for _, tier := range retention.Tiers {
+			tierBucket := tx.Bucket(rollupsBucket).Bucket(tierKey(tier.Resolution))
+			if tier.Keep <= 0 || tierBucket == nil {
+				continue
+			}
+			var series [][]byte
+			tierBucket.ForEachBucket(func(k []byte) error {
+				series = append(series, k)
+				return nil
+			})
+			for _, key := range series {
+				n, err := deleteBefore(tierBucket, key, now.Add(-tier.Keep))
+				if err != nil {
+					return err
+				}
+				expired.Rollups += n
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return storages.Expired{}, err
+	}
+	return expired, nil
+}
+
+// expireStale удаляет метрики, не обновлявшиеся с cutoff, с их историей и свернутой историей.
+func expireStale(tx *bolt.Tx, cutoff time.Time, expired *storages.Expired) error {
+	updated := tx.Bucket(updatedBucket)
+	var stale [][]byte
+	updated.ForEach(func(k, v []byte) error {
+		if time.Unix(0, decodeInt(v)).Before(cutoff) {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+
+	// Корзины нельзя менять во время их обхода, поэтому списки собираются заранее.
+	var tiers [][]byte
+	tx.Bucket(rollupsBucket).ForEachBucket(func(k []byte) error {
+		tiers = append(tiers, k)
+		return nil
+	})
+
+	for _, key := range stale {
+		mtype, id, _ := strings.Cut(string(key), "/")
+		values := tx.Bucket(gaugesBucket)
+		if mtype == consts.Counter {
+			values = tx.Bucket(countersBucket)
+		}
+		if err := values.Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := updated.Delete(key); err != nil {
+			return err
+		}
+		n, err := deleteBucket(tx.Bucket(samplesBucket), key)
+		if err != nil {
+			return err
+		}
+		expired.Samples += n
+
+		for _, tier := range tiers {
+			n, err := deleteBucket(tx.Bucket(rollupsBucket).Bucket(tier), key)
+			if err != nil {
+				return err
+			}
+			expired.Rollups += n
+		}
+		expired.Series++
+	}
+	return nil
+}
+
+// deleteBefore удаляет из вложенной корзины key записи раньше cutoff и возвращает их число.
+// Опустевшая корзина удаляется.
+func deleteBefore(parent *bolt.Bucket, key []byte, cutoff time.Time) (int64, error) {
+	b := parent.Bucket(key)
+	end := timeKey(cutoff)
+	var old [][]byte
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], end) < 0; k, _ = c.Next() {
+		old = append(old, k)
+	}
+	if len(old) == 0 {
+		return 0, nil
+	}
+	if k, _ := c.Seek(end); k == nil {
+		return deleteBucket(parent, key)
+	}
+	// Ключи удаляются после обхода: удаление во время обхода сдвигает курсор.
+	for _, k := range old {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(old)), nil
+}
+
+// deleteBucket удаляет вложенную корзину key, если она есть, и возвращает число записей в ней.
+func deleteBucket(parent *bolt.Bucket, key []byte) (int64, error) {
+	b := parent.Bucket(key)
+	if b == nil {
+		return 0, nil
+	}
+	n := int64(b.Stats().KeyN)
+	return n, parent.DeleteBucket(key)
+}
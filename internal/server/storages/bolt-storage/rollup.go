@@ -0,0 +1,124 @@
+package boltstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Rollup сворачивает историю уровня source за [from, to) в интервалы resolution.
+func (s *BoltStorage) Rollup(ctx context.Context, source, resolution time.Duration, from, to time.Time) (int64, error) {
+	var written int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		written = 0
+		target, err := tx.Bucket(rollupsBucket).CreateBucketIfNotExists(tierKey(resolution))
+		if err != nil {
+			return err
+		}
+
+		var sourceBucket *bolt.Bucket
+		if source == 0 {
+			sourceBucket = tx.Bucket(samplesBucket)
+		} else if sourceBucket = tx.Bucket(rollupsBucket).Bucket(tierKey(source)); sourceBucket == nil {
+			return nil
+		}
+
+		var series [][]byte
+		sourceBucket.ForEachBucket(func(k []byte) error {
+			series = append(series, k)
+			return nil
+		})
+		for _, key := range series {
+			var rollups []storages.Rollup
+			if source == 0 {
+				var samples []storages.Sample
+				scan(sourceBucket.Bucket(key), from, to, func(k, v []byte) {
+					samples = append(samples, storages.Sample{Time: keyTime(k), Value: decodeFloat(v)})
+				})
+				rollups = storages.Aggregate(samples, resolution)
+			} else {
+				var period []storages.Rollup
+				scan(sourceBucket.Bucket(key), from, to, func(k, v []byte) {
+					period = append(period, decodeRollup(k, v))
+				})
+				rollups = storages.Merge(period, resolution)
+			}
+			if len(rollups) == 0 {
+				continue
+			}
+
+			b, err := target.CreateBucketIfNotExists(key)
+			if err != nil {
+				return err
+			}
+			for _, r := range rollups {
+				if err := b.Put(timeKey(r.Time), encodeRollup(r)); err != nil {
+					return err
+				}
+			}
+			written += int64(len(rollups))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// Rollups возвращает интервалы уровня resolution, начала которых лежат в [from, to).
+func (s *BoltStorage) Rollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time) ([]storages.Rollup, error) {
+	rollups := make([]storages.Rollup, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		tier := tx.Bucket(rollupsBucket).Bucket(tierKey(resolution))
+		if tier == nil {
+			return nil
+		}
+		scan(tier.Bucket(seriesKey(mtype, id)), from, to, func(k, v []byte) {
+			rollups = append(rollups, decodeRollup(k, v))
+		})
+		return nil
+	})
+	return rollups, err
+}
+
+// scan вызывает fn для записей корзины b со временем в [from, to) по порядку.
+func scan(b *bolt.Bucket, from, to time.Time, fn func(k, v []byte)) {
+	if b == nil {
+		return
+	}
+	end := timeKey(to)
+	c := b.Cursor()
+	for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k[:8], end) < 0; k, v = c.Next() {
+		fn(k, v)
+	}
+}
+
+// tierKey - имя корзины уровня свернутой истории.
+func tierKey(resolution time.Duration) []byte {
+	return []byte(resolution.String())
+}
+
+// encodeRollup кодирует агрегаты интервала: min, max, sum и count по 8 байт.
+func encodeRollup(r storages.Rollup) []byte {
+	b := make([]byte, 0, 32)
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(r.Min))
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(r.Max))
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(r.Sum))
+	return binary.BigEndian.AppendUint64(b, uint64(r.Count))
+}
+
+func decodeRollup(k, v []byte) storages.Rollup {
+	return storages.Rollup{
+		Time:  keyTime(k),
+		Min:   decodeFloat(v[0:8]),
+		Max:   decodeFloat(v[8:16]),
+		Sum:   decodeFloat(v[16:24]),
+		Count: decodeInt(v[24:32]),
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/evildead81/metrics-and-alerts/internal/contracts"
 	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
 	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DBStorage struct {
@@ -34,6 +38,26 @@ func (s DBStorage) initDB() error {
 		"CREATE TABLE IF NOT EXISTS counters(" +
 		"id VARCHAR (50) PRIMARY KEY," +
 		"value BIGINT" +
+		");" +
+		"ALTER TABLE gauges ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();" +
+		"ALTER TABLE counters ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();" +
+		"CREATE TABLE IF NOT EXISTS samples(" +
+		"mtype VARCHAR (16) NOT NULL," +
+		"id VARCHAR (50) NOT NULL," +
+		"ts TIMESTAMPTZ NOT NULL," +
+		"value DOUBLE PRECISION NOT NULL" +
+		");" +
+		"CREATE INDEX IF NOT EXISTS samples_series ON samples (mtype, id, ts);" +
+		"CREATE TABLE IF NOT EXISTS rollups(" +
+		"resolution BIGINT NOT NULL," +
+		"mtype VARCHAR (16) NOT NULL," +
+		"id VARCHAR (50) NOT NULL," +
+		"ts TIMESTAMPTZ NOT NULL," +
+		"min DOUBLE PRECISION NOT NULL," +
+		"max DOUBLE PRECISION NOT NULL," +
+		"sum DOUBLE PRECISION NOT NULL," +
+		"count BIGINT NOT NULL," +
+		"PRIMARY KEY (resolution, mtype, id, ts)" +
 		");"
 
 	_, err := s.db.Exec(query)
@@ -45,30 +69,68 @@ func (s DBStorage) initDB() error {
 	return nil
 }
 
+// execer - общее у *sql.DB и *sql.Tx, чтобы пачка метрик писалась в транзакции.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// exec выполняет запрос в отдельном спане трассировки. operation - вид запроса
+// для имени спана, текст запроса записывается в атрибут без значений параметров.
+func exec(ctx context.Context, db execer, operation, query string, args ...any) error {
+	ctx, span := tracing.Start(ctx, "db."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+		))
+	_, err := db.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return err
+}
+
 func (s *DBStorage) UpdateCounter(name string, value int64) error {
+	return updateCounter(context.Background(), s.db, name, value)
+}
+
+func (s *DBStorage) UpdateGauge(name string, value float64) error {
+	return updateGauge(context.Background(), s.db, name, value)
+}
+
+func updateCounter(ctx context.Context, db execer, name string, value int64) error {
+	// Новое значение сразу попадает в историю.
 	query := `
-        INSERT INTO counters (id, value) 
-        VALUES ($1, $2)
-        ON CONFLICT (id) DO UPDATE 
-        SET value = counters.value + EXCLUDED.value;
+        WITH updated AS (
+            INSERT INTO counters (id, value, updated_at)
+            VALUES ($1, $2, now())
+            ON CONFLICT (id) DO UPDATE
+            SET value = counters.value + EXCLUDED.value, updated_at = EXCLUDED.updated_at
+            RETURNING id, value, updated_at
+        )
+        INSERT INTO samples (mtype, id, ts, value)
+        SELECT 'counter', id, updated_at, value FROM updated;
     `
-	_, err := s.db.Exec(query, name, value)
+	err := exec(ctx, db, "update_counter", query, name, value)
 	if err != nil {
 		return fmt.Errorf("failed to update counter: %w", err)
 	}
 	return nil
 }
 
-func (s *DBStorage) UpdateGauge(name string, value float64) error {
+func updateGauge(ctx context.Context, db execer, name string, value float64) error {
 	query := `
-		INSERT INTO gauges (id, value) 
-		VALUES ($1, $2)
-		ON CONFLICT (id) DO UPDATE 
-		SET value = EXCLUDED.value;
+        WITH updated AS (
+            INSERT INTO gauges (id, value, updated_at)
+            VALUES ($1, $2, now())
+            ON CONFLICT (id) DO UPDATE
+            SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+            RETURNING id, value, updated_at
+        )
+        INSERT INTO samples (mtype, id, ts, value)
+        SELECT 'gauge', id, updated_at, value FROM updated;
     `
-	_, err := s.db.Exec(query, name, value)
+	err := exec(ctx, db, "update_gauge", query, name, value)
 	if err != nil {
-		return fmt.Errorf("failed to update counter: %w", err)
+		return fmt.Errorf("failed to update gauge: %w", err)
 	}
 	return nil
 }
@@ -87,7 +149,7 @@ func (s DBStorage) isGaugeExists(name string) bool {
 
 func (s DBStorage) GetCounters() map[string]int64 {
 	counters := make(map[string]int64)
-	rows, err := s.db.Query("SELECT * FROM counters")
+	rows, err := s.db.Query("SELECT id, value FROM counters")
 	if err != nil || rows.Err() != nil {
 		return counters
 	}
@@ -107,7 +169,7 @@ func (s DBStorage) GetCounters() map[string]int64 {
 
 func (s DBStorage) GetGauges() map[string]float64 {
 	gauges := make(map[string]float64)
-	rows, err := s.db.Query("SELECT * FROM gauges")
+	rows, err := s.db.Query("SELECT id, value FROM gauges")
 	if err != nil || rows.Err() != nil {
 		return gauges
 	}
@@ -160,19 +222,33 @@ func (s DBStorage) Write() error {
 func (s DBStorage) Ping() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	if err := s.db.PingContext(ctx); err != nil {
-		return err
-	}
+	return s.PingContext(ctx)
+}
 
-	return nil
+// PingContext проверяет доступность базы данных в пределах ctx.
+func (s DBStorage) PingContext(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
+// UpdateMetrics обновляет список метрик в одной транзакции. Если хотя бы одна
+// метрика некорректна или не записалась, транзакция откатывается.
 func (s DBStorage) UpdateMetrics(metrics []contracts.Metrics) error {
+	return s.UpdateMetricsContext(context.Background(), metrics)
+}
+
+// UpdateMetricsContext - UpdateMetrics в пределах ctx. Каждый запрос
+// транзакции записывается в трассу из ctx отдельным спаном.
+func (s DBStorage) UpdateMetricsContext(ctx context.Context, metrics []contracts.Metrics) (err error) {
 	if len(metrics) == 0 {
 		return nil
 	}
+	for _, metric := range metrics {
+		if err := metric.Validate(); err != nil {
+			return err
+		}
+	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -180,30 +256,20 @@ func (s DBStorage) UpdateMetrics(metrics []contracts.Metrics) error {
 		if err != nil {
 			tx.Rollback()
 		} else {
-			tx.Commit()
+			err = tx.Commit()
 		}
 	}()
 
 	for _, metric := range metrics {
 		switch metric.MType {
 		case consts.Counter:
-			if metric.Delta == nil {
-				return fmt.Errorf("missing delta value for counter: %s", metric.ID)
-			}
-			err = s.UpdateCounter(metric.ID, *metric.Delta)
-			if err != nil {
-				return fmt.Errorf("failed to update counter: %w", err)
+			if err = updateCounter(ctx, tx, metric.ID, *metric.Delta); err != nil {
+				return err
 			}
 		case consts.Gauge:
-			if metric.Value == nil {
-				return fmt.Errorf("missing value for gauge: %s", metric.ID)
-			}
-			err := s.UpdateGauge(metric.ID, *metric.Value)
-			if err != nil {
-				return fmt.Errorf("failed to update gauge: %w", err)
+			if err = updateGauge(ctx, tx, metric.ID, *metric.Value); err != nil {
+				return err
 			}
-		default:
-			return fmt.Errorf("unsupported metric type: %s", metric.MType)
 		}
 	}
 	return nil
@@ -0,0 +1,125 @@
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+)
+
+// History возвращает историю значений метрики с from включительно по to исключительно.
+func (s DBStorage) History(ctx context.Context, mtype, id string, from, to time.Time) ([]storages.Sample, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT ts, value FROM samples WHERE mtype = $1 AND id = $2 AND ts >= $3 AND ts < $4 ORDER BY ts",
+		mtype, id, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	samples := make([]storages.Sample, 0)
+	for rows.Next() {
+		var sample storages.Sample
+		if err := rows.Scan(&sample.Time, &sample.Value); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// Expire удаляет метрики, не обновлявшиеся дольше retention.StaleAfter, вместе
+// с их историей, и историю и свернутую историю старше сроков хранения. Сроки зависят от имени
+// метрики, поэтому история чистится отдельно по каждой метрике.
+func (s DBStorage) Expire(ctx context.Context, retention storages.Retention, now time.Time) (expired storages.Expired, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return expired, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			expired = storages.Expired{}
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if retention.StaleAfter > 0 {
+		cutoff := now.Add(-retention.StaleAfter)
+		for _, table := range []struct{ name, mtype string }{
+			{name: "gauges", mtype: consts.Gauge},
+			{name: "counters", mtype: consts.Counter},
+		} {
+			// Имя таблицы берется из списка выше, а не из входных данных.
+			query := `
+                WITH stale AS (
+                    DELETE FROM ` + table.name + ` WHERE updated_at < $1 RETURNING id
+                ), removed AS (
+                    DELETE FROM samples WHERE mtype = $2 AND id IN (SELECT id FROM stale) RETURNING 1
+                ), rolled AS (
+                    DELETE FROM rollups WHERE mtype = $2 AND id IN (SELECT id FROM stale) RETURNING 1
+                )
+                SELECT (SELECT count(*) FROM stale), (SELECT count(*) FROM removed), (SELECT count(*) FROM rolled);
+            `
+			var series, samples, rollups int64
+			if err = tx.QueryRowContext(ctx, query, cutoff, table.mtype).Scan(&series, &samples, &rollups); err != nil {
+				return expired, fmt.Errorf("failed to expire stale %s: %w", table.name, err)
+			}
+			expired.Series += series
+			expired.Samples += samples
+			expired.Rollups += rollups
+		}
+	}
+
+	type metric struct{ mtype, id string }
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT mtype, id FROM samples")
+	if err != nil {
+		return expired, fmt.Errorf("failed to list history: %w", err)
+	}
+	var metrics []metric
+	for rows.Next() {
+		var m metric
+		if err = rows.Scan(&m.mtype, &m.id); err != nil {
+			rows.Close()
+			return expired, fmt.Errorf("failed to list history: %w", err)
+		}
+		metrics = append(metrics, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return expired, fmt.Errorf("failed to list history: %w", err)
+	}
+
+	for _, m := range metrics {
+		ttl := retention.HistoryTTL(m.mtype, m.id)
+		if ttl <= 0 {
+			continue
+		}
+		result, execErr := tx.ExecContext(ctx,
+			"DELETE FROM samples WHERE mtype = $1 AND id = $2 AND ts < $3", m.mtype, m.id, now.Add(-ttl))
+		if execErr != nil {
+			err = execErr
+			return expired, fmt.Errorf("failed to expire history of %s: %w", m.id, err)
+		}
+		n, _ := result.RowsAffected()
+		expired.Samples += n
+	}
+
+	for _, tier := range retention.Tiers {
+		if tier.Keep <= 0 {
+			continue
+		}
+		result, execErr := tx.ExecContext(ctx,
+			"DELETE FROM rollups WHERE resolution = $1 AND ts < $2", int64(tier.Resolution.Seconds()), now.Add(-tier.Keep))
+		if execErr != nil {
+			err = execErr
+			return expired, fmt.Errorf("failed to expire rollups of %s: %w", tier.Resolution, err)
+		}
+		n, _ := result.RowsAffected()
+		expired.Rollups += n
+	}
+	return expired, nil
+}
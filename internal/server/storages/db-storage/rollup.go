@@ -0,0 +1,69 @@
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+)
+
+// Интервалы уровней хранятся в секундах, поэтому начала интервалов совпадают
+// с storages.Bucket для интервалов, кратных секунде.
+
+// Rollup сворачивает историю уровня source за [from, to) в интервалы resolution.
+func (s DBStorage) Rollup(ctx context.Context, source, resolution time.Duration, from, to time.Time) (int64, error) {
+	var query string
+	args := []any{int64(resolution.Seconds()), from, to}
+	if source == 0 {
+		query = `
+            INSERT INTO rollups (resolution, mtype, id, ts, min, max, sum, count)
+            SELECT $1, mtype, id, to_timestamp(floor(extract(epoch FROM ts) / $1) * $1) AS bucket,
+                   min(value), max(value), sum(value), count(*)
+            FROM samples WHERE ts >= $2 AND ts < $3
+            GROUP BY mtype, id, bucket
+            ON CONFLICT (resolution, mtype, id, ts) DO UPDATE
+            SET min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count;
+        `
+	} else {
+		query = `
+            INSERT INTO rollups (resolution, mtype, id, ts, min, max, sum, count)
+            SELECT $1, mtype, id, to_timestamp(floor(extract(epoch FROM ts) / $1) * $1) AS bucket,
+                   min(min), max(max), sum(sum), sum(count)
+            FROM rollups WHERE resolution = $4 AND ts >= $2 AND ts < $3
+            GROUP BY mtype, id, bucket
+            ON CONFLICT (resolution, mtype, id, ts) DO UPDATE
+            SET min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count;
+        `
+		args = append(args, int64(source.Seconds()))
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up history: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n, nil
+}
+
+// Rollups возвращает интервалы уровня resolution, начала которых лежат в [from, to).
+func (s DBStorage) Rollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time) ([]storages.Rollup, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT ts, min, max, sum, count FROM rollups "+
+			"WHERE resolution = $1 AND mtype = $2 AND id = $3 AND ts >= $4 AND ts < $5 ORDER BY ts",
+		int64(resolution.Seconds()), mtype, id, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rollups: %w", err)
+	}
+	defer rows.Close()
+
+	rollups := make([]storages.Rollup, 0)
+	for rows.Next() {
+		var r storages.Rollup
+		if err := rows.Scan(&r.Time, &r.Min, &r.Max, &r.Sum, &r.Count); err != nil {
+			return nil, fmt.Errorf("failed to read rollups: %w", err)
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
@@ -0,0 +1,182 @@
+package storages
+
+import (
+	"context"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/selfmetrics"
+	"github.com/evildead81/metrics-and-alerts/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedStorage учитывает длительность и ошибки операций хранилища
+// и число принятых метрик по типам. Запись пачки метрик также попадает в трассу.
+type instrumentedStorage struct {
+	Storage
+	backend  string
+	duration *selfmetrics.HistogramVec
+	errors   *selfmetrics.CounterVec
+	ingested *selfmetrics.CounterVec
+}
+
+// Instrument оборачивает хранилище, записывая метрики его работы в reg.
+// backend - метка вида хранилища в метриках, например memory или database.
+func Instrument(storage Storage, reg *selfmetrics.Registry, backend string) Storage {
+	return &instrumentedStorage{
+		Storage: storage,
+		backend: backend,
+		duration: reg.Histogram("server_storage_operation_duration_seconds",
+			"Storage operation latency.", nil, "backend", "operation"),
+		errors: reg.Counter("server_storage_operation_errors_total",
+			"Failed storage operations.", "backend", "operation"),
+		ingested: reg.Counter("server_ingested_metrics_total",
+			"Metrics written to the storage by type.", "type"),
+	}
+}
+
+// observe учитывает длительность операции и, если передана ошибка, саму ошибку.
+func (s *instrumentedStorage) observe(operation string, start time.Time, err error) {
+	s.duration.With(s.backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.errors.With(s.backend, operation).Inc()
+	}
+}
+
+func (s *instrumentedStorage) UpdateCounter(name string, value int64) error {
+	start := time.Now()
+	err := s.Storage.UpdateCounter(name, value)
+	s.observe("update_counter", start, err)
+	if err == nil {
+		s.ingested.With(consts.Counter).Inc()
+	}
+	return err
+}
+
+func (s *instrumentedStorage) UpdateGauge(name string, value float64) error {
+	start := time.Now()
+	err := s.Storage.UpdateGauge(name, value)
+	s.observe("update_gauge", start, err)
+	if err == nil {
+		s.ingested.With(consts.Gauge).Inc()
+	}
+	return err
+}
+
+func (s *instrumentedStorage) UpdateMetrics(metrics []contracts.Metrics) error {
+	return s.UpdateMetricsContext(context.Background(), metrics)
+}
+
+func (s *instrumentedStorage) UpdateMetricsContext(ctx context.Context, metrics []contracts.Metrics) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateMetrics", trace.WithAttributes(
+		attribute.String("storage.backend", s.backend),
+		attribute.Int("metrics.count", len(metrics)),
+	))
+	start := time.Now()
+	err := UpdateMetricsContext(ctx, s.Storage, metrics)
+	s.observe("update_metrics", start, err)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	var gauges, counters int64
+	for _, m := range metrics {
+		switch m.MType {
+		case consts.Gauge:
+			gauges++
+		case consts.Counter:
+			counters++
+		}
+	}
+	s.ingested.With(consts.Gauge).Add(gauges)
+	s.ingested.With(consts.Counter).Add(counters)
+	return nil
+}
+
+// Чтение отдельной метрики возвращает ошибку, если метрики нет, поэтому
+// для него учитывается только длительность.
+
+func (s *instrumentedStorage) GetGaugeValueByName(name string) (float64, error) {
+	start := time.Now()
+	value, err := s.Storage.GetGaugeValueByName(name)
+	s.observe("get_gauge", start, nil)
+	return value, err
+}
+
+func (s *instrumentedStorage) GetCountValueByName(name string) (int64, error) {
+	start := time.Now()
+	value, err := s.Storage.GetCountValueByName(name)
+	s.observe("get_counter", start, nil)
+	return value, err
+}
+
+func (s *instrumentedStorage) GetGauges() map[string]float64 {
+	start := time.Now()
+	defer s.observe("get_gauges", start, nil)
+	return s.Storage.GetGauges()
+}
+
+func (s *instrumentedStorage) GetCounters() map[string]int64 {
+	start := time.Now()
+	defer s.observe("get_counters", start, nil)
+	return s.Storage.GetCounters()
+}
+
+func (s *instrumentedStorage) Restore() error {
+	start := time.Now()
+	err := s.Storage.Restore()
+	s.observe("restore", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Write() error {
+	start := time.Now()
+	err := s.Storage.Write()
+	s.observe("write", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) PingContext(ctx context.Context) error {
+	start := time.Now()
+	err := PingContext(ctx, s.Storage)
+	s.observe("ping", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Ping() error {
+	start := time.Now()
+	err := s.Storage.Ping()
+	s.observe("ping", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) History(ctx context.Context, mtype, id string, from, to time.Time) ([]Sample, error) {
+	start := time.Now()
+	samples, err := History(ctx, s.Storage, mtype, id, from, to)
+	s.observe("history", start, err)
+	return samples, err
+}
+
+func (s *instrumentedStorage) Expire(ctx context.Context, retention Retention, now time.Time) (Expired, error) {
+	start := time.Now()
+	expired, err := Expire(ctx, s.Storage, retention, now)
+	s.observe("expire", start, err)
+	return expired, err
+}
+
+func (s *instrumentedStorage) Rollup(ctx context.Context, source, resolution time.Duration, from, to time.Time) (int64, error) {
+	start := time.Now()
+	n, err := RollupPeriod(ctx, s.Storage, source, resolution, from, to)
+	s.observe("rollup", start, err)
+	return n, err
+}
+
+func (s *instrumentedStorage) Rollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time) ([]Rollup, error) {
+	start := time.Now()
+	rollups, err := Rollups(ctx, s.Storage, mtype, id, resolution, from, to)
+	s.observe("rollups", start, err)
+	return rollups, err
+}
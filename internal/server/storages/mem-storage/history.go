@@ -0,0 +1,133 @@
+package memstorage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+)
+
+// sample - значение истории. Время хранится в наносекундах, чтобы история занимала меньше памяти.
+type sample struct {
+	at    int64
+	value float64
+}
+
+func seriesKey(mtype, id string) string {
+	return mtype + "/" + id
+}
+
+// record запоминает время обновления метрики и добавляет значение в историю.
+// Вызывается под mutex.
+func (t *MemStorage) record(mtype, id string, value float64, now time.Time) {
+	key := seriesKey(mtype, id)
+	t.updated[key] = now
+	t.history[key] = append(t.history[key], sample{at: now.UnixNano(), value: value})
+}
+
+// History возвращает историю значений метрики с from включительно по to исключительно.
+func (t *MemStorage) History(ctx context.Context, mtype, id string, from, to time.Time) ([]storages.Sample, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	samples := t.history[seriesKey(mtype, id)]
+	start := sort.Search(len(samples), func(i int) bool { return samples[i].at >= from.UnixNano() })
+	end := sort.Search(len(samples), func(i int) bool { return samples[i].at >= to.UnixNano() })
+
+	result := make([]storages.Sample, 0, max(end-start, 0))
+	for _, s := range samples[start:max(start, end)] {
+		result = append(result, storages.Sample{Time: time.Unix(0, s.at), Value: s.value})
+	}
+	return result, nil
+}
+
+// Expire удаляет метрики, не обновлявшиеся дольше retention.StaleAfter,
+// и историю и свернутую историю старше сроков хранения.
+func (t *MemStorage) Expire(ctx context.Context, retention storages.Retention, now time.Time) (storages.Expired, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var expired storages.Expired
+	if retention.StaleAfter > 0 {
+		cutoff := now.Add(-retention.StaleAfter)
+		var removed []contracts.Metrics
+		for name := range t.gaugeMetrics {
+			if t.stale(consts.Gauge, name, cutoff) {
+				removed = append(removed, contracts.Metrics{ID: name, MType: consts.Gauge})
+			}
+		}
+		for name := range t.counterMetrics {
+			if t.stale(consts.Counter, name, cutoff) {
+				removed = append(removed, contracts.Metrics{ID: name, MType: consts.Counter})
+			}
+		}
+		// Удаление попадает в журнал, чтобы метрики не вернулись при восстановлении.
+		if t.wal != nil && len(removed) != 0 {
+			if _, err := t.wal.append(removed); err != nil {
+				return expired, err
+			}
+		}
+		for _, m := range removed {
+			if m.MType == consts.Gauge {
+				delete(t.gaugeMetrics, m.ID)
+			} else {
+				delete(t.counterMetrics, m.ID)
+			}
+			samples, rollups := t.forget(m.MType, m.ID)
+			expired.Samples += samples
+			expired.Rollups += rollups
+			expired.Series++
+		}
+	}
+
+	for key, samples := range t.history {
+		mtype, id := splitSeriesKey(key)
+		ttl := retention.HistoryTTL(mtype, id)
+		if ttl <= 0 {
+			continue
+		}
+		cutoff := now.Add(-ttl).UnixNano()
+		n := sort.Search(len(samples), func(i int) bool { return samples[i].at >= cutoff })
+		if n == 0 {
+			continue
+		}
+		expired.Samples += int64(n)
+		if n == len(samples) {
+			delete(t.history, key)
+		} else {
+			// Копия нужна, чтобы удаленная часть не удерживалась в памяти.
+			t.history[key] = append([]sample(nil), samples[n:]...)
+		}
+	}
+	expired.Rollups += t.expireRollups(retention.Tiers, now)
+	return expired, nil
+}
+
+// stale проверяет, что метрика не обновлялась с cutoff.
+func (t *MemStorage) stale(mtype, id string, cutoff time.Time) bool {
+	updated, ok := t.updated[seriesKey(mtype, id)]
+	return ok && updated.Before(cutoff)
+}
+
+// forget удаляет время обновления, историю и свернутую историю метрики
+// и возвращает число удаленных значений и интервалов.
+func (t *MemStorage) forget(mtype, id string) (samples, rollups int64) {
+	key := seriesKey(mtype, id)
+	samples = int64(len(t.history[key]))
+	delete(t.updated, key)
+	delete(t.history, key)
+	for _, tier := range t.rollups {
+		rollups += int64(len(tier[key]))
+		delete(tier, key)
+	}
+	return samples, rollups
+}
+
+func splitSeriesKey(key string) (mtype, id string) {
+	mtype, id, _ = strings.Cut(key, "/")
+	return mtype, id
+}
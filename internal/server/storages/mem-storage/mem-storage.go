@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
 	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
 	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
 )
@@ -17,6 +20,19 @@ type MemStorage struct {
 	counterMetrics map[string]int64
 	storagePath    string
 	mutex          *sync.Mutex
+	// restoreErr - ошибка восстановления при запуске. Отсутствие файла ошибкой не считается.
+	restoreErr error
+	// updated - время последнего обновления метрик, history - их история значений.
+	// Ключи - seriesKey. История хранится только в памяти и не попадает в файл.
+	updated map[string]time.Time
+	history map[string][]sample
+	// rollups - свернутая история по интервалам уровней и ключам seriesKey.
+	rollups map[time.Duration]map[string][]storages.Rollup
+	// wal - журнал изменений между снимками, nil без журнала.
+	wal *wal
+	// writeMutex не дает снимкам пересечься: иначе более старый снимок мог бы
+	// заменить более новый после удаления журнала, который их разделял.
+	writeMutex *sync.Mutex
 	storages.Storage
 }
 
@@ -26,45 +42,178 @@ func New(storagePath string, restore bool) *MemStorage {
 		counterMetrics: make(map[string]int64),
 		storagePath:    storagePath,
 		mutex:          &sync.Mutex{},
+		writeMutex:     &sync.Mutex{},
+		updated:        make(map[string]time.Time),
+		history:        make(map[string][]sample),
+		rollups:        make(map[time.Duration]map[string][]storages.Rollup),
 	}
 
 	if restore {
-		storage.Restore()
+		if err := storage.Restore(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			storage.restoreErr = err
+		}
 	}
 
 	return storage
 }
 
-func (t *MemStorage) UpdateCounter(name string, value int64) error {
-	_, ok := t.counterMetrics[name]
-	t.mutex.Lock()
-	if ok {
-		t.counterMetrics[name] += value
-	} else {
-		t.counterMetrics[name] = value
+// Options - параметры надежности хранилища.
+type Options struct {
+	// Sync - политика журнала изменений: SyncOff, SyncAlways или SyncInterval.
+	Sync string
+	// SyncInterval - интервал сброса журнала на диск при политике SyncInterval.
+	SyncInterval time.Duration
+}
+
+// NewWithOptions создает хранилище, которое кроме снимков в storagePath ведет журнал
+// изменений storagePath.wal. При восстановлении журнал применяется поверх снимка,
+// а после каждого снимка очищается. Без восстановления журнал очищается сразу.
+// Хранилище с журналом нужно закрыть через Close.
+func NewWithOptions(storagePath string, restore bool, opts Options) (*MemStorage, error) {
+	storage := New(storagePath, restore)
+	if opts.Sync == SyncOff || len(opts.Sync) == 0 {
+		return storage, nil
+	}
+	if len(storagePath) == 0 {
+		return nil, errors.New("write-ahead log needs a storage path")
 	}
-	t.mutex.Unlock()
 
+	w, err := openWAL(walPath(storagePath), opts.Sync, opts.SyncInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	if !restore {
+		if err := w.reset(); err != nil {
+			w.close()
+			return nil, fmt.Errorf("failed to reset write-ahead log: %w", err)
+		}
+	}
+	storage.wal = w
+	return storage, nil
+}
+
+// Close сбрасывает на диск и закрывает журнал изменений.
+func (t *MemStorage) Close() error {
+	if t.wal == nil {
+		return nil
+	}
+	return t.wal.close()
+}
+
+// RestoreStatus возвращает ошибку восстановления метрик из файла при запуске.
+func (t *MemStorage) RestoreStatus() error {
+	if t.restoreErr != nil {
+		return fmt.Errorf("restore from %s: %w", t.storagePath, t.restoreErr)
+	}
 	return nil
 }
 
+// StoragePath возвращает путь к файлу, в который сохраняются метрики.
+func (t *MemStorage) StoragePath() string {
+	return t.storagePath
+}
+
+func (t *MemStorage) UpdateCounter(name string, value int64) error {
+	return t.update([]contracts.Metrics{{ID: name, MType: consts.Counter, Delta: &value}})
+}
+
 func (t *MemStorage) UpdateGauge(name string, value float64) error {
+	return t.update([]contracts.Metrics{{ID: name, MType: consts.Gauge, Value: &value}})
+}
+
+// update применяет пачку проверенных метрик, предварительно записав ее в журнал.
+// Если журнал недоступен, хранилище не меняется. При политике SyncAlways
+// update возвращается после сброса записи на диск.
+func (t *MemStorage) update(metrics []contracts.Metrics) error {
 	t.mutex.Lock()
-	t.gaugeMetrics[name] = value
+
+	// Записи журнала хранят значения после обновления, для counter - накопленные.
+	records := make([]contracts.Metrics, len(metrics))
+	totals := make(map[string]int64)
+	for i, m := range metrics {
+		records[i] = contracts.Metrics{ID: m.ID, MType: m.MType, Value: m.Value}
+		if m.MType == consts.Counter {
+			records[i].Value = nil
+			total, ok := totals[m.ID]
+			if !ok {
+				total = t.counterMetrics[m.ID]
+			}
+			total += *m.Delta
+			totals[m.ID] = total
+			records[i].Delta = &total
+		}
+	}
+
+	var seq uint64
+	if t.wal != nil {
+		var err error
+		if seq, err = t.wal.append(records); err != nil {
+			t.mutex.Unlock()
+			return err
+		}
+	}
+	now := time.Now()
+	for _, r := range records {
+		t.apply(r, now, false)
+	}
 	t.mutex.Unlock()
+
+	if t.wal != nil {
+		return t.wal.commit(seq)
+	}
 	return nil
 }
 
+// apply устанавливает значение метрики из записи журнала или удаляет метрику,
+// если значения в записи нет. При replay (восстановлении) история не пополняется:
+// время исходных обновлений неизвестно. Вызывается под mutex.
+func (t *MemStorage) apply(r contracts.Metrics, now time.Time, replay bool) {
+	var value float64
+	switch {
+	case r.MType == consts.Gauge && r.Value != nil:
+		t.gaugeMetrics[r.ID] = *r.Value
+		value = *r.Value
+	case r.MType == consts.Counter && r.Delta != nil:
+		t.counterMetrics[r.ID] = *r.Delta
+		value = float64(*r.Delta)
+	case r.MType == consts.Gauge:
+		delete(t.gaugeMetrics, r.ID)
+		t.forget(consts.Gauge, r.ID)
+		return
+	case r.MType == consts.Counter:
+		delete(t.counterMetrics, r.ID)
+		t.forget(consts.Counter, r.ID)
+		return
+	default:
+		return
+	}
+
+	if replay {
+		t.updated[seriesKey(r.MType, r.ID)] = now
+		return
+	}
+	t.record(r.MType, r.ID, value, now)
+}
+
+// GetCounters возвращает копию счетчиков: очистка устаревших метрик
+// может менять хранилище, пока вызывающий обходит результат.
 func (t MemStorage) GetCounters() map[string]int64 {
-	return t.counterMetrics
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return maps.Clone(t.counterMetrics)
 }
 
+// GetGauges возвращает копию метрик типа gauge.
 func (t MemStorage) GetGauges() map[string]float64 {
-	return t.gaugeMetrics
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return maps.Clone(t.gaugeMetrics)
 }
 
 func (t MemStorage) GetGaugeValueByName(name string) (float64, error) {
+	t.mutex.Lock()
 	value, ok := t.gaugeMetrics[name]
+	t.mutex.Unlock()
 	if !ok {
 		return 0, errors.New("Gauge metric with name " + name + " not found")
 	}
@@ -72,7 +221,9 @@ func (t MemStorage) GetGaugeValueByName(name string) (float64, error) {
 }
 
 func (t MemStorage) GetCountValueByName(name string) (int64, error) {
+	t.mutex.Lock()
 	value, ok := t.counterMetrics[name]
+	t.mutex.Unlock()
 	if !ok {
 		return 0, errors.New("Counter metric with name " + name + " not found")
 	}
@@ -81,16 +232,49 @@ func (t MemStorage) GetCountValueByName(name string) (int64, error) {
 
 func (t MemStorage) printCounters() {
 	for key, value := range t.counterMetrics {
-		fmt.Println("Counter", "Name", key, "Value", value)
+		logger.Logger.Debugw("Counter", "name", key, "value", value)
 	}
 }
 func (t MemStorage) printGauges() {
 	for key, value := range t.gaugeMetrics {
-		fmt.Println("Gauge", "Name", key, "Value", value)
+		logger.Logger.Debugw("Gauge", "name", key, "value", value)
 	}
 }
 
+// Restore восстанавливает метрики из снимка и применяет поверх него журнал изменений.
+// Если нет ни снимка, ни журнала, возвращает ошибку os.ErrNotExist.
 func (t MemStorage) Restore() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	snapshotErr := t.restoreSnapshot(now)
+	if snapshotErr != nil && !errors.Is(snapshotErr, os.ErrNotExist) {
+		return snapshotErr
+	}
+	replayed := false
+	for _, path := range []string{oldWALPath(walPath(t.storagePath)), walPath(t.storagePath)} {
+		_, err := readWAL(path, func(records []contracts.Metrics) {
+			for _, r := range records {
+				t.apply(r, now, true)
+			}
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		replayed = true
+	}
+	if replayed {
+		return nil
+	}
+	return snapshotErr
+}
+
+// restoreSnapshot восстанавливает метрики из снимка. Вызывается под mutex.
+func (t MemStorage) restoreSnapshot(now time.Time) error {
 	content, err := os.ReadFile(t.storagePath)
 	if err != nil {
 		return err
@@ -104,24 +288,31 @@ func (t MemStorage) Restore() error {
 	}
 
 	for _, item := range metrics {
-		if item.MType == consts.Gauge {
-			t.UpdateGauge(item.ID, *item.Value)
+		if item.Validate() != nil {
+			continue
 		}
 		if item.MType == consts.Counter {
-			t.UpdateCounter(item.ID, *item.Delta)
+			item.Value = nil
 		}
+		t.apply(item, now, true)
 	}
 
 	return nil
 }
 
+// Write сохраняет снимок метрик. Снимок записывается во временный файл и заменяет
+// прежний переименованием, поэтому сбой при записи не портит прежний снимок.
+// Журнал изменений, вошедших в снимок, после записи удаляется.
+// Без пути к файлу хранилище работает только в памяти и ничего не сохраняет.
 func (t MemStorage) Write() error {
-	file, err := os.Create(t.storagePath)
-	if err != nil {
-		return err
+	if len(t.storagePath) == 0 {
+		return nil
 	}
-	defer file.Close()
 
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	t.mutex.Lock()
 	var metrics = make([]contracts.Metrics, 0)
 	for name, value := range t.gaugeMetrics {
 		metrics = append(metrics, contracts.Metrics{ID: name, MType: consts.Gauge, Value: &value})
@@ -129,6 +320,13 @@ func (t MemStorage) Write() error {
 	for name, value := range t.counterMetrics {
 		metrics = append(metrics, contracts.Metrics{ID: name, MType: consts.Counter, Delta: &value})
 	}
+	if t.wal != nil {
+		if err := t.wal.rotate(); err != nil {
+			t.mutex.Unlock()
+			return fmt.Errorf("failed to rotate write-ahead log: %w", err)
+		}
+	}
+	t.mutex.Unlock()
 
 	serialized, marshalErr := json.MarshalIndent(metrics, "", "   ")
 
@@ -136,12 +334,13 @@ func (t MemStorage) Write() error {
 		return marshalErr
 	}
 
-	_, writeErr := file.Write(serialized)
-
-	if writeErr != nil {
-		return writeErr
+	if err := writeFileAtomic(t.storagePath, serialized); err != nil {
+		return err
 	}
 
+	if t.wal != nil {
+		return t.wal.removeOld()
+	}
 	return nil
 }
 
@@ -149,24 +348,14 @@ func (t MemStorage) Ping() error {
 	return nil
 }
 
+// UpdateMetrics обновляет список метрик. Если хотя бы одна метрика некорректна,
+// хранилище не меняется.
 func (t MemStorage) UpdateMetrics(metrics []contracts.Metrics) error {
-	if len(metrics) == 0 {
-		return nil
-	}
-
 	for _, v := range metrics {
-		if v.MType == consts.Gauge {
-			err := t.UpdateGauge(v.ID, *v.Value)
-			if err != nil {
-				return err
-			}
-		}
-		if v.MType == consts.Counter {
-			err := t.UpdateCounter(v.ID, *v.Delta)
-			if err != nil {
-				return err
-			}
+		if err := v.Validate(); err != nil {
+			return err
 		}
 	}
-	return nil
+
+	return t.update(metrics)
 }
@@ -1,15 +1,22 @@
 package memstorage
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/evildead81/metrics-and-alerts/internal/contracts"
 	"github.com/evildead81/metrics-and-alerts/internal/server/consts"
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
 )
 
 func TestUpdateCounter(t *testing.T) {
@@ -101,6 +108,43 @@ func TestRestore(t *testing.T) {
 	}
 }
 
+func TestRestoreSkipsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage, err := NewWithOptions(path, false, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	storage.UpdateGauge("load", 0.5)
+	storage.Write()
+	storage.UpdateCounter("requests", 2)
+	storage.Close()
+
+	restored, err := NewWithOptions(path, true, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to restore storage: %v", err)
+	}
+	defer restored.Close()
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for _, m := range []struct{ mtype, id string }{{consts.Gauge, "load"}, {consts.Counter, "requests"}} {
+		samples, _ := restored.History(context.Background(), m.mtype, m.id, from, to)
+		if len(samples) != 0 {
+			t.Errorf("Expected no history samples for restored %s, got %v", m.id, samples)
+		}
+	}
+
+	restored.UpdateCounter("requests", 1)
+	if samples, _ := restored.History(context.Background(), consts.Counter, "requests", from, to); len(samples) != 1 || samples[0].Value != 3 {
+		t.Errorf("Expected one sample after update, got %v", samples)
+	}
+
+	// Восстановленные метрики по-прежнему устаревают, если их не обновляют.
+	expired, err := restored.Expire(context.Background(), storages.Retention{StaleAfter: time.Minute}, time.Now().Add(2*time.Minute))
+	if err != nil || expired.Series != 2 {
+		t.Errorf("Expected restored metrics to expire, got %+v, %v", expired, err)
+	}
+}
+
 func TestWrite(t *testing.T) {
 	filePath := createTestFile([]contracts.Metrics{})
 	defer os.Remove(filePath)
@@ -126,6 +170,12 @@ func TestWrite(t *testing.T) {
 	if !reflect.DeepEqual(metrics, expected) {
 		t.Errorf("Expected metrics %v, but got %v", expected, metrics)
 	}
+
+	inMemory := New("", false)
+	_ = inMemory.UpdateCounter("counter1", 1)
+	if err := inMemory.Write(); err != nil {
+		t.Errorf("Expected storage without a file to skip writing, got %v", err)
+	}
 }
 
 func TestGetCountValueByName(t *testing.T) {
@@ -151,3 +201,411 @@ func int64Pointer(v int64) *int64 {
 func float64Pointer(v float64) *float64 {
 	return &v
 }
+
+func TestUpdateMetricsRejectsMissingValues(t *testing.T) {
+	storage := New("", false)
+	value := 1.5
+	err := storage.UpdateMetrics([]contracts.Metrics{
+		{ID: "g", MType: consts.Gauge, Value: &value},
+		{ID: "c", MType: consts.Counter},
+	})
+	if err == nil {
+		t.Fatalf("Expected error for counter without delta")
+	}
+	if _, err := storage.GetGaugeValueByName("g"); err == nil {
+		t.Errorf("Expected batch with invalid metric not to be applied")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	storage := New("", false)
+	start := time.Now()
+	storage.UpdateCounter("jobs", 2)
+	storage.UpdateCounter("jobs", 3)
+	storage.UpdateGauge("load", 0.5)
+
+	samples, err := storage.History(context.Background(), consts.Counter, "jobs", start, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(samples) != 2 || samples[0].Value != 2 || samples[1].Value != 5 {
+		t.Errorf("Expected accumulated counter history [2 5], got %+v", samples)
+	}
+	if samples, _ := storage.History(context.Background(), consts.Gauge, "load", start.Add(-time.Hour), start); len(samples) != 0 {
+		t.Errorf("Expected no samples before the range end, got %+v", samples)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	storage := New("", false)
+	storage.UpdateGauge("cpu_user", 1)
+	storage.UpdateGauge("cpu_user", 2)
+	storage.UpdateGauge("disk_free", 3)
+	storage.UpdateCounter("requests", 4)
+
+	retention := storages.Retention{
+		Rules:   []storages.RetentionRule{{Pattern: "cpu_*", Keep: time.Hour}},
+		History: 0,
+	}
+	expired, err := storage.Expire(context.Background(), retention, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if expired != (storages.Expired{Samples: 2}) {
+		t.Errorf("Expected only cpu_user history to expire, got %+v", expired)
+	}
+	if _, err := storage.GetGaugeValueByName("cpu_user"); err != nil {
+		t.Errorf("Expected current value to outlive its history: %v", err)
+	}
+
+	retention.StaleAfter = time.Minute
+	storage.UpdateGauge("disk_free", 5)
+	expired, err = storage.Expire(context.Background(), retention, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if expired.Series != 0 {
+		t.Errorf("Expected fresh metrics to stay, got %+v", expired)
+	}
+
+	expired, err = storage.Expire(context.Background(), retention, time.Now().Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if expired != (storages.Expired{Samples: 3, Series: 3}) {
+		t.Errorf("Expected all metrics and their history to expire, got %+v", expired)
+	}
+	if len(storage.GetGauges()) != 0 || len(storage.GetCounters()) != 0 {
+		t.Errorf("Expected storage to be empty, got %v %v", storage.GetGauges(), storage.GetCounters())
+	}
+}
+
+func TestExpireConcurrentReads(t *testing.T) {
+	storage := New("", false)
+	retention := storages.Retention{StaleAfter: time.Minute}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			name := fmt.Sprintf("metric%d", i%10)
+			_ = storage.UpdateGauge(name, float64(i))
+			_ = storage.UpdateCounter(name, 1)
+			_, _ = storage.Expire(context.Background(), retention, time.Now().Add(2*time.Minute))
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		for range storage.GetGauges() {
+		}
+		for range storage.GetCounters() {
+		}
+		_, _ = storage.GetGaugeValueByName("metric0")
+		_, _ = storage.GetCountValueByName("metric0")
+	}
+	wg.Wait()
+}
+
+func TestRetentionHistoryTTL(t *testing.T) {
+	retention := storages.Retention{
+		Rules: []storages.RetentionRule{
+			{Pattern: "cpu_*", Type: consts.Gauge, Keep: time.Hour},
+			{Pattern: "*", Type: consts.Counter, Keep: 0},
+		},
+		History: 24 * time.Hour,
+	}
+	tests := []struct {
+		mtype, id string
+		want      time.Duration
+	}{
+		{mtype: consts.Gauge, id: "cpu_user", want: time.Hour},
+		{mtype: consts.Counter, id: "cpu_user", want: 0},
+		{mtype: consts.Gauge, id: "disk_free", want: 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := retention.HistoryTTL(test.mtype, test.id); got != test.want {
+			t.Errorf("HistoryTTL(%s, %s) = %v, want %v", test.mtype, test.id, got, test.want)
+		}
+	}
+}
+
+func TestRollup(t *testing.T) {
+	storage := New("", false)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, value := range []float64{1, 5, 3, 7} {
+		// По два значения в минуту: 00:00, 00:30, 01:00, 01:30.
+		storage.record(consts.Gauge, "load", value, base.Add(time.Duration(i)*30*time.Second))
+	}
+	ctx := context.Background()
+
+	n, err := storage.Rollup(ctx, 0, time.Minute, base, base.Add(2*time.Minute))
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 minute buckets, got %d, %v", n, err)
+	}
+	if _, err := storage.Rollup(ctx, time.Minute, time.Hour, base, base.Add(time.Hour)); err != nil {
+		t.Fatalf("Rollup failed: %v", err)
+	}
+
+	minutes, _ := storage.Rollups(ctx, consts.Gauge, "load", time.Minute, base, base.Add(time.Hour))
+	want := []storages.Rollup{
+		{Time: base, Min: 1, Max: 5, Sum: 6, Count: 2},
+		{Time: base.Add(time.Minute), Min: 3, Max: 7, Sum: 10, Count: 2},
+	}
+	if len(minutes) != 2 || !minutes[0].Time.Equal(want[0].Time) || minutes[0].Sum != 6 || minutes[1].Min != 3 || minutes[1].Max != 7 {
+		t.Errorf("Expected %+v, got %+v", want, minutes)
+	}
+	hours, _ := storage.Rollups(ctx, consts.Gauge, "load", time.Hour, base, base.Add(time.Hour))
+	if len(hours) != 1 || hours[0].Min != 1 || hours[0].Max != 7 || hours[0].Count != 4 || hours[0].Avg() != 4 {
+		t.Errorf("Expected hour rolled up from minutes, got %+v", hours)
+	}
+
+	// Повторная свертка перезаписывает интервал, а не добавляет к нему.
+	storage.Rollup(ctx, 0, time.Minute, base, base.Add(time.Minute))
+	if minutes, _ := storage.Rollups(ctx, consts.Gauge, "load", time.Minute, base, base.Add(time.Hour)); len(minutes) != 2 || minutes[0].Count != 2 {
+		t.Errorf("Expected rollup to be idempotent, got %+v", minutes)
+	}
+
+	tiers := []storages.Tier{{Resolution: time.Minute, Keep: time.Hour}, {Resolution: time.Hour}}
+	expired, err := storage.Expire(ctx, storages.Retention{Tiers: tiers}, base.Add(time.Hour+30*time.Second))
+	if err != nil || expired.Rollups != 1 {
+		t.Errorf("Expected the first minute bucket to expire, got %+v, %v", expired, err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	storage := New("", false)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		storage.record(consts.Gauge, "load", float64(i), base.Add(time.Duration(i)*30*time.Second))
+	}
+	ctx := context.Background()
+	storage.Rollup(ctx, 0, time.Minute, base, base.Add(2*time.Minute))
+	tiers := []storages.Tier{{Resolution: time.Minute}, {Resolution: time.Hour}}
+
+	tests := []struct {
+		name           string
+		step           time.Duration
+		wantResolution time.Duration
+		wantPoints     int
+	}{
+		{name: "raw samples", step: 0, wantResolution: 0, wantPoints: 4},
+		{name: "raw aggregated", step: 30 * time.Second, wantResolution: 0, wantPoints: 4},
+		{name: "minute tier", step: 2 * time.Minute, wantResolution: time.Minute, wantPoints: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points, resolution, err := storages.Range(ctx, storage, tiers, consts.Gauge, "load", base, base.Add(10*time.Minute), test.step)
+			if err != nil {
+				t.Fatalf("Range failed: %v", err)
+			}
+			if resolution != test.wantResolution || len(points) != test.wantPoints {
+				t.Errorf("Expected %d points from %v, got %+v from %v", test.wantPoints, test.wantResolution, points, resolution)
+			}
+		})
+	}
+
+	if storages.ChooseTier(tiers, 59*time.Second) != 0 || storages.ChooseTier(tiers, 24*time.Hour) != time.Hour {
+		t.Errorf("Expected the coarsest tier not exceeding the step")
+	}
+	if got := storages.Bucket(base.Add(90*time.Second), time.Minute); !got.Equal(base.Add(time.Minute)) {
+		t.Errorf("Expected bucket start %v, got %v", base.Add(time.Minute), got)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	open := func() *MemStorage {
+		storage, err := NewWithOptions(path, true, Options{Sync: SyncAlways})
+		if err != nil {
+			t.Fatalf("Failed to open storage: %v", err)
+		}
+		if err := storage.RestoreStatus(); err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+		t.Cleanup(func() { storage.Close() })
+		return storage
+	}
+
+	// Обновления без снимка переживают сбой благодаря журналу.
+	storage := open()
+	storage.UpdateCounter("requests", 2)
+	storage.UpdateMetrics([]contracts.Metrics{
+		{ID: "requests", MType: consts.Counter, Delta: int64Pointer(3)},
+		{ID: "load", MType: consts.Gauge, Value: float64Pointer(0.5)},
+	})
+	storage = open()
+	if v, _ := storage.GetCountValueByName("requests"); v != 5 {
+		t.Errorf("Expected counter 5 after replay, got %d", v)
+	}
+
+	// Снимок очищает журнал, а журнал поверх снимка не удваивает counter.
+	if err := storage.Write(); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if info, err := os.Stat(path + walSuffix); err != nil || info.Size() != 0 {
+		t.Errorf("Expected WAL to be truncated after checkpoint, got %v, %v", info, err)
+	}
+	storage.UpdateCounter("requests", 1)
+	storage = open()
+	if v, _ := storage.GetCountValueByName("requests"); v != 6 {
+		t.Errorf("Expected counter 6 after snapshot and replay, got %d", v)
+	}
+	if v, _ := storage.GetGaugeValueByName("load"); v != 0.5 {
+		t.Errorf("Expected gauge from snapshot, got %v", v)
+	}
+
+	// Повторное применение журнала, уже вошедшего в снимок, ничего не меняет.
+	storage.Write()
+	storage.UpdateCounter("requests", 4)
+	os.WriteFile(path+oldWALSuffix, mustRead(t, path+walSuffix), 0644)
+	storage = open()
+	if v, _ := storage.GetCountValueByName("requests"); v != 10 {
+		t.Errorf("Expected replay to be idempotent, got %d", v)
+	}
+
+	// Удаленные по сроку метрики не возвращаются при восстановлении.
+	storage.Expire(context.Background(), storages.Retention{StaleAfter: time.Minute}, time.Now().Add(time.Hour))
+	storage = open()
+	if len(storage.GetCounters()) != 0 || len(storage.GetGauges()) != 0 {
+		t.Errorf("Expected expired metrics to stay deleted, got %v %v", storage.GetCounters(), storage.GetGauges())
+	}
+}
+
+func TestWALDamage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage, err := NewWithOptions(path, true, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	storage.UpdateGauge("load", 1)
+	storage.Close()
+
+	// Оборванная последняя запись - обычный след сбоя, она отбрасывается.
+	appendBytes(t, path+walSuffix, []byte(`0badc0de [{"id":"lo`))
+	storage, err = NewWithOptions(path, true, Options{Sync: SyncAlways})
+	if err != nil || storage.RestoreStatus() != nil {
+		t.Fatalf("Expected torn record to be ignored, got %v, %v", err, storage.RestoreStatus())
+	}
+	storage.UpdateGauge("load", 2)
+	storage.Close()
+	restored := New(path, true)
+	if v, _ := restored.GetGaugeValueByName("load"); v != 2 || restored.RestoreStatus() != nil {
+		t.Errorf("Expected records after the torn one to replay, got %v, %v", v, restored.RestoreStatus())
+	}
+
+	// Поврежденная запись в середине журнала - ошибка восстановления.
+	content := mustRead(t, path+walSuffix)
+	content[10] ^= 0xff
+	os.WriteFile(path+walSuffix, content, 0644)
+	if err := New(path, true).RestoreStatus(); !errors.Is(err, ErrCorruptWAL) {
+		t.Errorf("Expected ErrCorruptWAL, got %v", err)
+	}
+
+	// Открытие журнала не обрезает записи после повреждения, а откладывает файл целиком.
+	storage, err = NewWithOptions(path, true, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer storage.Close()
+	if err := storage.RestoreStatus(); !errors.Is(err, ErrCorruptWAL) {
+		t.Errorf("Expected the restore failure to be reported, got %v", err)
+	}
+	aside, _ := filepath.Glob(path + walSuffix + ".corrupt-*")
+	if len(aside) != 1 || !bytes.Equal(mustRead(t, aside[0]), content) {
+		t.Errorf("Expected the corrupt WAL to be kept intact, got %v", aside)
+	}
+	if info, err := os.Stat(path + walSuffix); err != nil || info.Size() != 0 {
+		t.Errorf("Expected a fresh WAL, got %v, %v", info, err)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return content
+}
+
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+	file.Write(data)
+}
+
+func TestWALGroupCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage, err := NewWithOptions(path, false, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := storage.UpdateCounter("requests", 1); err != nil {
+				t.Errorf("Update failed: %v", err)
+			}
+		}()
+		if i == 25 {
+			// Снимок посреди обновлений не теряет записи, дописанные во время него.
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				storage.Write()
+			}()
+		}
+	}
+	wg.Wait()
+
+	// Каждое вернувшееся обновление уже на диске, поэтому журнал читается без Close.
+	restored := New(path, true)
+	if v, _ := restored.GetCountValueByName("requests"); v != 50 {
+		t.Errorf("Expected all synced updates to be restored, got %d", v)
+	}
+	if err := storage.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage, err := NewWithOptions(path, false, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer storage.Close()
+
+	// Снимки идут одновременно друг с другом и с обновлениями.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := storage.Write(); err != nil {
+					t.Errorf("Write failed: %v", err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		storage.UpdateCounter("requests", 1)
+	}
+	wg.Wait()
+
+	restored := New(path, true)
+	if err := restored.RestoreStatus(); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if v, _ := restored.GetCountValueByName("requests"); v != 200 {
+		t.Errorf("Expected no updates lost between snapshots, got %d", v)
+	}
+}
@@ -0,0 +1,100 @@
+package memstorage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/server/storages"
+)
+
+// Rollup сворачивает историю уровня source за [from, to) в интервалы resolution.
+func (t *MemStorage) Rollup(ctx context.Context, source, resolution time.Duration, from, to time.Time) (int64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var written int64
+	if source == 0 {
+		for key, samples := range t.history {
+			start := sort.Search(len(samples), func(i int) bool { return samples[i].at >= from.UnixNano() })
+			end := sort.Search(len(samples), func(i int) bool { return samples[i].at >= to.UnixNano() })
+			period := make([]storages.Sample, 0, max(end-start, 0))
+			for _, s := range samples[start:max(start, end)] {
+				period = append(period, storages.Sample{Time: time.Unix(0, s.at), Value: s.value})
+			}
+			written += t.storeRollups(resolution, key, storages.Aggregate(period, resolution))
+		}
+		return written, nil
+	}
+
+	for key, rollups := range t.rollups[source] {
+		written += t.storeRollups(resolution, key, storages.Merge(between(rollups, from, to), resolution))
+	}
+	return written, nil
+}
+
+// storeRollups записывает интервалы метрики key, заменяя уже записанные с тем же началом.
+// Вызывается под mutex.
+func (t *MemStorage) storeRollups(resolution time.Duration, key string, rollups []storages.Rollup) int64 {
+	if len(rollups) == 0 {
+		return 0
+	}
+	tier, ok := t.rollups[resolution]
+	if !ok {
+		tier = make(map[string][]storages.Rollup)
+		t.rollups[resolution] = tier
+	}
+
+	stored := tier[key]
+	for _, r := range rollups {
+		i := sort.Search(len(stored), func(i int) bool { return !stored[i].Time.Before(r.Time) })
+		if i < len(stored) && stored[i].Time.Equal(r.Time) {
+			stored[i] = r
+			continue
+		}
+		stored = append(stored, storages.Rollup{})
+		copy(stored[i+1:], stored[i:])
+		stored[i] = r
+	}
+	tier[key] = stored
+	return int64(len(rollups))
+}
+
+// Rollups возвращает интервалы уровня resolution, начала которых лежат в [from, to).
+func (t *MemStorage) Rollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time) ([]storages.Rollup, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return append([]storages.Rollup{}, between(t.rollups[resolution][seriesKey(mtype, id)], from, to)...), nil
+}
+
+// between возвращает часть упорядоченных интервалов, начала которых лежат в [from, to).
+func between(rollups []storages.Rollup, from, to time.Time) []storages.Rollup {
+	start := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Time.Before(from) })
+	end := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Time.Before(to) })
+	return rollups[start:max(start, end)]
+}
+
+// expireRollups удаляет интервалы уровней старше их сроков хранения. Вызывается под mutex.
+func (t *MemStorage) expireRollups(tiers []storages.Tier, now time.Time) int64 {
+	var expired int64
+	for _, tier := range tiers {
+		if tier.Keep <= 0 {
+			continue
+		}
+		cutoff := now.Add(-tier.Keep)
+		for key, rollups := range t.rollups[tier.Resolution] {
+			n := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Time.Before(cutoff) })
+			if n == 0 {
+				continue
+			}
+			expired += int64(n)
+			if n == len(rollups) {
+				delete(t.rollups[tier.Resolution], key)
+			} else {
+				t.rollups[tier.Resolution][key] = append([]storages.Rollup(nil), rollups[n:]...)
+			}
+		}
+	}
+	return expired
+}
@@ -0,0 +1,385 @@
+package memstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+	"github.com/evildead81/metrics-and-alerts/internal/logger"
+)
+
+// Политики сброса журнала изменений на диск.
+const (
+	// SyncOff отключает журнал: данные сохраняются только снимками.
+	SyncOff = "off"
+	// SyncAlways сбрасывает журнал на диск перед ответом на каждое обновление.
+	// Одновременные обновления сбрасываются на диск вместе.
+	SyncAlways = "always"
+	// SyncInterval сбрасывает журнал на диск раз в Options.SyncInterval.
+	// При сбое теряются обновления не больше чем за этот интервал.
+	SyncInterval = "interval"
+)
+
+// Суффиксы файлов журнала рядом с файлом снимка. Журнал .wal.old - часть журнала,
+// которая отделена при создании снимка и удаляется, когда снимок записан.
+const (
+	walSuffix    = ".wal"
+	oldWALSuffix = ".wal.old"
+)
+
+// ErrCorruptWAL - запись журнала повреждена не в конце файла, поэтому не обрыв
+// последней записи при сбое, а порча данных.
+var ErrCorruptWAL = errors.New("corrupt write-ahead log")
+
+// Записи журнала - пачки метрик, по одной на строку, в виде "<crc32> <json>".
+// В записи хранятся значения метрик после обновления, для counter - накопленные,
+// поэтому повторное применение записи, уже попавшей в снимок, ничего не меняет.
+// Метрика без значения означает ее удаление.
+
+// wal - журнал изменений хранилища.
+//
+// Записи нумеруются по порядку. Сброс на диск делает один из ожидающих его
+// вызовов сразу за все записи, дописанные к этому моменту, остальные ждут
+// его результата - так одновременные обновления делят один fsync.
+type wal struct {
+	mutex sync.Mutex
+	// synced оповещает ожидающих о завершении сброса на диск.
+	synced *sync.Cond
+	path   string
+	file   *os.File
+	// always - ждать сброса на диск каждой записи.
+	always bool
+	// written - номер последней дописанной записи, flushed - последней сброшенной на диск.
+	written uint64
+	flushed uint64
+	// syncing - сброс на диск идет без mutex, файл в это время не заменяется.
+	syncing bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// openWAL открывает журнал для дописывания, отрезая оборванную последнюю запись.
+// Поврежденный журнал откладывается в сторону, см. prepareWAL.
+// При политике SyncInterval запускается периодический сброс журнала на диск.
+func openWAL(path string, policy string, interval time.Duration) (*wal, error) {
+	for _, p := range []string{oldWALPath(path), path} {
+		if err := prepareWAL(p); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{path: path, file: file, always: policy == SyncAlways, done: make(chan struct{})}
+	w.synced = sync.NewCond(&w.mutex)
+	if policy == SyncInterval {
+		w.wg.Add(1)
+		go w.run(interval)
+	}
+	return w, nil
+}
+
+func (w *wal) run(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mutex.Lock()
+			written := w.written
+			w.mutex.Unlock()
+			if err := w.waitFlushed(written); err != nil {
+				logger.Logger.Errorw("Failed to sync WAL", "error", err.Error())
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// waitFlushed возвращается, когда запись seq и все предыдущие сброшены на диск.
+func (w *wal) waitFlushed(seq uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for w.flushed < seq {
+		if w.syncing {
+			w.synced.Wait()
+			continue
+		}
+		w.syncing = true
+		target, file := w.written, w.file
+		w.mutex.Unlock()
+		err := file.Sync()
+		w.mutex.Lock()
+		w.syncing = false
+		w.synced.Broadcast()
+		if err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+		w.flushed = max(w.flushed, target)
+	}
+	return nil
+}
+
+// append дописывает пачку метрик в журнал и возвращает номер записи.
+// Вызывается под mutex хранилища, поэтому порядок записей совпадает с порядком обновлений.
+func (w *wal) append(metrics []contracts.Metrics) (uint64, error) {
+	line, err := encodeRecord(metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.file.Write(line); err != nil {
+		return 0, fmt.Errorf("failed to write WAL: %w", err)
+	}
+	w.written++
+	return w.written, nil
+}
+
+// commit ждет сброса записи seq на диск при политике SyncAlways.
+// Вызывается без mutex хранилища, чтобы обновления успевали собраться в один сброс.
+func (w *wal) commit(seq uint64) error {
+	if !w.always {
+		return nil
+	}
+	return w.waitFlushed(seq)
+}
+
+// lockFile захватывает mutex, дождавшись конца текущего сброса на диск,
+// чтобы файл журнала можно было заменить или обрезать.
+func (w *wal) lockFile() {
+	w.mutex.Lock()
+	for w.syncing {
+		w.synced.Wait()
+	}
+}
+
+// rotate отделяет накопленный журнал в файл .wal.old перед созданием снимка.
+// Если .wal.old остался от неудачного снимка, журнал дописывается к нему.
+func (w *wal) rotate() error {
+	w.lockFile()
+	defer w.mutex.Unlock()
+
+	old := oldWALPath(w.path)
+	if _, err := os.Stat(old); errors.Is(err, os.ErrNotExist) {
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+		if err := os.Rename(w.path, old); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w.file.Close()
+		w.file, w.flushed = file, w.written
+		return syncDir(filepath.Dir(w.path))
+	}
+
+	if err := appendFile(old, w.path); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.flushed = w.written
+	return nil
+}
+
+// removeOld удаляет журнал, отделенный rotate, после того как снимок записан.
+func (w *wal) removeOld() error {
+	if err := os.Remove(oldWALPath(w.path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// reset очищает журнал, когда хранилище запускается без восстановления.
+func (w *wal) reset() error {
+	w.lockFile()
+	defer w.mutex.Unlock()
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	return w.removeOld()
+}
+
+func (w *wal) close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mutex.Lock()
+	written := w.written
+	w.mutex.Unlock()
+	err := w.waitFlushed(written)
+
+	w.lockFile()
+	defer w.mutex.Unlock()
+	return errors.Join(err, w.file.Close())
+}
+
+func walPath(storagePath string) string {
+	return storagePath + walSuffix
+}
+
+func oldWALPath(path string) string {
+	return path[:len(path)-len(walSuffix)] + oldWALSuffix
+}
+
+func encodeRecord(metrics []contracts.Metrics) ([]byte, error) {
+	payload, err := json.Marshal(metrics)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, 0, len(payload)+10)
+	line = fmt.Appendf(line, "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeRecord(line []byte) ([]contracts.Metrics, error) {
+	sum, payload, ok := bytes.Cut(line, []byte(" "))
+	if !ok || len(sum) != 8 {
+		return nil, ErrCorruptWAL
+	}
+	crc, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || uint32(crc) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrCorruptWAL
+	}
+	var metrics []contracts.Metrics
+	if err := json.Unmarshal(payload, &metrics); err != nil {
+		return nil, ErrCorruptWAL
+	}
+	return metrics, nil
+}
+
+// readWAL вызывает apply для каждой записи журнала по порядку и возвращает
+// размер его целой части. Оборванная последняя запись пропускается,
+// поврежденная запись в середине дает ErrCorruptWAL.
+func readWAL(path string, apply func([]contracts.Metrics)) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Запись без перевода строки оборвана при сбое.
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+		metrics, decodeErr := decodeRecord(line[:len(line)-1])
+		if decodeErr != nil {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				return size, nil
+			}
+			return size, fmt.Errorf("%s at offset %d: %w", path, size, decodeErr)
+		}
+		if apply != nil {
+			apply(metrics)
+		}
+		size += int64(len(line))
+	}
+}
+
+// prepareWAL готовит файл журнала к дописыванию. Оборванная последняя запись
+// отрезается. Журнал, поврежденный в середине, переименовывается в
+// path.corrupt-<время>, чтобы записи после повреждения можно было восстановить
+// вручную, а не потерять при обрезке.
+func prepareWAL(path string) error {
+	size, err := readWAL(path, nil)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case errors.Is(err, ErrCorruptWAL):
+		aside := path + ".corrupt-" + time.Now().Format("2006-01-02T15-04-05.000")
+		if err := os.Rename(path, aside); err != nil {
+			return fmt.Errorf("failed to move corrupt WAL aside: %w", err)
+		}
+		logger.Logger.Errorw("Corrupt write-ahead log moved aside", "path", aside, "valid_bytes", size)
+		return syncDir(filepath.Dir(path))
+	case err != nil:
+		return err
+	}
+	return os.Truncate(path, size)
+}
+
+// appendFile дописывает содержимое файла src в dst и сбрасывает dst на диск.
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return errors.Join(out.Sync(), out.Close())
+}
+
+// writeFileAtomic записывает файл так, что при сбое на диске остается
+// либо старое, либо новое содержимое целиком.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименования в нем пережили сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,80 @@
+package storages
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// Sample - значение метрики в момент обновления. Для counter это накопленное значение.
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// HistoryReader - хранилище, которое хранит историю значений метрик.
+type HistoryReader interface {
+	// History возвращает значения метрики с from включительно по to исключительно,
+	// упорядоченные по времени.
+	History(ctx context.Context, mtype, id string, from, to time.Time) ([]Sample, error)
+}
+
+// RetentionRule - срок хранения истории метрик, имена которых подходят под шаблон.
+type RetentionRule struct {
+	// Pattern - шаблон имени метрики в синтаксисе path.Match, например "cpu_*".
+	Pattern string
+	// Type - тип метрики. Пустое значение подходит к обоим типам.
+	Type string
+	// Keep - сколько хранить историю. 0 означает хранить бессрочно.
+	Keep time.Duration
+}
+
+// Matches проверяет, что правило относится к метрике.
+func (r RetentionRule) Matches(mtype, id string) bool {
+	if len(r.Type) != 0 && r.Type != mtype {
+		return false
+	}
+	matched, _ := path.Match(r.Pattern, id)
+	return matched
+}
+
+// Retention - сроки хранения истории и текущих значений метрик.
+type Retention struct {
+	// Rules - сроки хранения истории по шаблонам. Применяется первое подошедшее правило.
+	Rules []RetentionRule
+	// History - срок хранения истории метрик, не подошедших ни под одно правило.
+	// 0 означает хранить бессрочно.
+	History time.Duration
+	// StaleAfter - метрика, которая не обновлялась дольше, удаляется вместе с историей.
+	// 0 отключает удаление.
+	StaleAfter time.Duration
+	// Tiers - уровни свернутой истории со своими сроками хранения.
+	Tiers []Tier
+}
+
+// HistoryTTL возвращает срок хранения истории метрики или 0, если она хранится бессрочно.
+func (r Retention) HistoryTTL(mtype, id string) time.Duration {
+	for _, rule := range r.Rules {
+		if rule.Matches(mtype, id) {
+			return rule.Keep
+		}
+	}
+	return r.History
+}
+
+// Expired - сколько данных удалено при очистке хранилища.
+type Expired struct {
+	// Samples - удаленные значения истории, в том числе история удаленных метрик.
+	Samples int64
+	// Series - удаленные метрики, которые не обновлялись дольше StaleAfter.
+	Series int64
+	// Rollups - удаленные интервалы свернутой истории.
+	Rollups int64
+}
+
+// Expirer - хранилище, из которого можно удалить устаревшие данные.
+type Expirer interface {
+	// Expire удаляет историю и свернутую историю старше сроков хранения и метрики,
+	// не обновлявшиеся дольше retention.StaleAfter, считая от now.
+	Expire(ctx context.Context, retention Retention, now time.Time) (Expired, error)
+}
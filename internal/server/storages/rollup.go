@@ -0,0 +1,159 @@
+package storages
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Rollup - значения метрики за интервал, свернутые в агрегаты.
+type Rollup struct {
+	// Time - начало интервала.
+	Time  time.Time
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count int64
+}
+
+// Avg возвращает среднее значение за интервал.
+func (r Rollup) Avg() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Sum / float64(r.Count)
+}
+
+// Add учитывает в агрегатах другой агрегат того же интервала.
+func (r *Rollup) Add(other Rollup) {
+	if r.Count == 0 {
+		r.Min, r.Max = other.Min, other.Max
+	} else {
+		r.Min = math.Min(r.Min, other.Min)
+		r.Max = math.Max(r.Max, other.Max)
+	}
+	r.Sum += other.Sum
+	r.Count += other.Count
+}
+
+// Tier - уровень хранения истории: значения, свернутые в интервалы Resolution,
+// которые хранятся Keep. Keep 0 означает хранить бессрочно.
+type Tier struct {
+	Resolution time.Duration
+	Keep       time.Duration
+}
+
+// RollupStorage - хранилище, которое хранит свернутую историю значений метрик.
+type RollupStorage interface {
+	// Rollup сворачивает данные уровня source за [from, to) в интервалы resolution
+	// и возвращает число записанных интервалов. source 0 означает исходные значения.
+	// Повторная свертка того же периода перезаписывает интервалы.
+	Rollup(ctx context.Context, source, resolution time.Duration, from, to time.Time) (int64, error)
+	// Rollups возвращает интервалы уровня resolution, начала которых лежат в [from, to).
+	Rollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time) ([]Rollup, error)
+}
+
+// ErrNoRollups - хранилище не хранит свернутую историю.
+var ErrNoRollups = errors.New("storage does not keep metric rollups")
+
+// RollupPeriod сворачивает историю за [from, to), если хранилище это поддерживает,
+// и возвращает ErrNoRollups иначе.
+func RollupPeriod(ctx context.Context, storage Storage, source, resolution time.Duration, from, to time.Time) (int64, error) {
+	if r, ok := storage.(RollupStorage); ok {
+		return r.Rollup(ctx, source, resolution, from, to)
+	}
+	return 0, ErrNoRollups
+}
+
+// Rollups возвращает свернутую историю метрики, если хранилище ее хранит,
+// и ErrNoRollups иначе.
+func Rollups(ctx context.Context, storage Storage, mtype, id string, resolution time.Duration, from, to time.Time) ([]Rollup, error) {
+	if r, ok := storage.(RollupStorage); ok {
+		return r.Rollups(ctx, mtype, id, resolution, from, to)
+	}
+	return nil, ErrNoRollups
+}
+
+// Bucket возвращает начало интервала длиной resolution, в который попадает t.
+// Интервалы отсчитываются от начала эпохи Unix.
+func Bucket(t time.Time, resolution time.Duration) time.Time {
+	ns := t.UnixNano()
+	return time.Unix(0, ns-mod(ns, int64(resolution)))
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// Aggregate сворачивает упорядоченные по времени значения в интервалы step.
+func Aggregate(samples []Sample, step time.Duration) []Rollup {
+	rollups := make([]Rollup, 0)
+	for _, s := range samples {
+		rollups = appendRollup(rollups, Rollup{Time: Bucket(s.Time, step), Min: s.Value, Max: s.Value, Sum: s.Value, Count: 1})
+	}
+	return rollups
+}
+
+// Merge сворачивает упорядоченные по времени интервалы в более крупные интервалы step.
+func Merge(rollups []Rollup, step time.Duration) []Rollup {
+	merged := make([]Rollup, 0)
+	for _, r := range rollups {
+		r.Time = Bucket(r.Time, step)
+		merged = appendRollup(merged, r)
+	}
+	return merged
+}
+
+func appendRollup(rollups []Rollup, r Rollup) []Rollup {
+	if n := len(rollups); n != 0 && rollups[n-1].Time.Equal(r.Time) {
+		rollups[n-1].Add(r)
+		return rollups
+	}
+	return append(rollups, r)
+}
+
+// ChooseTier возвращает самый крупный уровень из tiers, интервал которого
+// не больше step, или 0, если подходят только исходные значения.
+func ChooseTier(tiers []Tier, step time.Duration) time.Duration {
+	var resolution time.Duration
+	for _, tier := range tiers {
+		if tier.Resolution <= step && tier.Resolution > resolution {
+			resolution = tier.Resolution
+		}
+	}
+	return resolution
+}
+
+// Range возвращает значения метрики за [from, to), свернутые в интервалы step.
+// Данные берутся с самого крупного уровня, который позволяет такой шаг.
+// При step 0 каждое исходное значение возвращается отдельным интервалом.
+// Возвращает также интервал выбранного уровня, 0 для исходных значений.
+func Range(ctx context.Context, storage Storage, tiers []Tier, mtype, id string, from, to time.Time, step time.Duration) ([]Rollup, time.Duration, error) {
+	resolution := ChooseTier(tiers, step)
+	if resolution == 0 {
+		samples, err := History(ctx, storage, mtype, id, from, to)
+		if err != nil {
+			return nil, 0, err
+		}
+		if step == 0 {
+			rollups := make([]Rollup, len(samples))
+			for i, s := range samples {
+				rollups[i] = Rollup{Time: s.Time, Min: s.Value, Max: s.Value, Sum: s.Value, Count: 1}
+			}
+			return rollups, 0, nil
+		}
+		return Aggregate(samples, step), 0, nil
+	}
+
+	// Интервал уровня, в который попадает from, начинается раньше from, но нужен целиком.
+	rollups, err := Rollups(ctx, storage, mtype, id, resolution, Bucket(from, resolution), to)
+	if err != nil {
+		return nil, 0, err
+	}
+	return Merge(rollups, step), resolution, nil
+}
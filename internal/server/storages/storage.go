@@ -1,6 +1,12 @@
 package storages
 
-import "github.com/evildead81/metrics-and-alerts/internal/contracts"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/evildead81/metrics-and-alerts/internal/contracts"
+)
 
 // Storage - интерфейс хранилища, с которым работают обработчики запросов.
 type Storage interface {
@@ -25,3 +31,52 @@ type Storage interface {
 	// UpdateMetrics обновляет список метрик.
 	UpdateMetrics(metrics []contracts.Metrics) error
 }
+
+// ContextPinger - хранилище, проверку доступности которого можно ограничить контекстом.
+type ContextPinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingContext проверяет доступность хранилища в пределах ctx, если хранилище
+// это поддерживает, и вызывает Ping в остальных случаях.
+func PingContext(ctx context.Context, storage Storage) error {
+	if p, ok := storage.(ContextPinger); ok {
+		return p.PingContext(ctx)
+	}
+	return storage.Ping()
+}
+
+// ContextUpdater - хранилище, запись пачки метрик в которое можно ограничить контекстом.
+// Контекст также несет родительский спан трассировки.
+type ContextUpdater interface {
+	UpdateMetricsContext(ctx context.Context, metrics []contracts.Metrics) error
+}
+
+// UpdateMetricsContext записывает пачку метрик в пределах ctx, если хранилище
+// это поддерживает, и вызывает UpdateMetrics в остальных случаях.
+func UpdateMetricsContext(ctx context.Context, storage Storage, metrics []contracts.Metrics) error {
+	if u, ok := storage.(ContextUpdater); ok {
+		return u.UpdateMetricsContext(ctx, metrics)
+	}
+	return storage.UpdateMetrics(metrics)
+}
+
+// ErrNoHistory - хранилище не хранит историю значений метрик.
+var ErrNoHistory = errors.New("storage does not keep metric history")
+
+// History возвращает историю значений метрики, если хранилище ее хранит, и ErrNoHistory иначе.
+func History(ctx context.Context, storage Storage, mtype, id string, from, to time.Time) ([]Sample, error) {
+	if h, ok := storage.(HistoryReader); ok {
+		return h.History(ctx, mtype, id, from, to)
+	}
+	return nil, ErrNoHistory
+}
+
+// Expire удаляет устаревшие данные, если хранилище это поддерживает.
+// Иначе ничего не делает.
+func Expire(ctx context.Context, storage Storage, retention Retention, now time.Time) (Expired, error) {
+	if e, ok := storage.(Expirer); ok {
+		return e.Expire(ctx, retention, now)
+	}
+	return Expired{}, nil
+}
@@ -0,0 +1,154 @@
+// Package tracing - трассировка запросов OpenTelemetry от агента до хранилища сервера.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName - имя, под которым модуль создает спаны.
+const instrumentationName = "github.com/evildead81/metrics-and-alerts"
+
+// Экспортеры спанов.
+const (
+	// ExporterNone отключает экспорт. Заголовок traceparent при этом все равно передается дальше.
+	ExporterNone = "none"
+	// ExporterOTLP отправляет спаны коллектору по OTLP/HTTP.
+	ExporterOTLP = "otlp"
+	// ExporterFile пишет спаны в файл по одному JSON-объекту, для отладки без коллектора.
+	ExporterFile = "file"
+)
+
+// propagator переносит контекст трассировки в заголовке W3C traceparent.
+var propagator = propagation.TraceContext{}
+
+// Config - параметры трассировки.
+type Config struct {
+	// ServiceName - имя сервиса в спанах.
+	ServiceName string
+	// Exporter - none (по умолчанию), otlp или file.
+	Exporter string
+	// Endpoint - URL коллектора OTLP/HTTP, например http://localhost:4318.
+	Endpoint string
+	// File - файл для экспортера file.
+	File string
+	// SampleRatio - доля записываемых трасс от 0 до 1. Если трасса пришла
+	// с решением о записи от вызывающей стороны, используется оно.
+	SampleRatio float64
+}
+
+// Validate проверяет параметры трассировки.
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Exporter {
+	case "", ExporterNone:
+	case ExporterOTLP:
+		if u, err := url.Parse(c.Endpoint); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("trace endpoint %q must be a URL", c.Endpoint))
+		}
+	case ExporterFile:
+		if len(c.File) == 0 {
+			errs = append(errs, errors.New("trace file is empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown trace exporter %q", c.Exporter))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, errors.New("trace sample ratio must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// Setup устанавливает глобальный провайдер спанов по cfg. Возвращенную функцию
+// нужно вызвать при остановке процесса, чтобы отправить накопленные спаны.
+func Setup(cfg Config) (func(ctx context.Context) error, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		e, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	case ExporterFile:
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter, file = e, f
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// Start начинает спан с именем name, дочерний к спану из ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End завершает спан, отмечая его ошибочным, если err не nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject записывает контекст трассировки из ctx в заголовки исходящего запроса.
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract возвращает ctx с контекстом трассировки из заголовков входящего запроса.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// TraceID возвращает идентификатор трассы из ctx или пустую строку, если трассы нет.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []Config{
+		{},
+		{Exporter: ExporterNone, SampleRatio: 1},
+		{Exporter: ExporterOTLP, Endpoint: "http://localhost:4318"},
+		{Exporter: ExporterFile, File: "traces.json", SampleRatio: 0.5},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", cfg, err)
+		}
+	}
+
+	invalid := []Config{
+		{Exporter: "jaeger"},
+		{Exporter: ExporterOTLP, Endpoint: "localhost:4318"},
+		{Exporter: ExporterFile},
+		{SampleRatio: 1.5},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", cfg)
+		}
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	saved := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(saved)
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(Config{ServiceName: "test", Exporter: ExporterFile, File: path, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	End(child, errors.New("boom"))
+	parent.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	for _, want := range []string{`"Name":"parent"`, `"Name":"child"`, `"Description":"boom"`, TraceID(ctx)} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected trace file to contain %s", want)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+
+	header := http.Header{}
+	Inject(trace.ContextWithSpanContext(context.Background(), sc), header)
+	if got := header.Get("traceparent"); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Unexpected traceparent %q", got)
+	}
+
+	ctx := Extract(context.Background(), header)
+	if TraceID(ctx) != traceID.String() {
+		t.Errorf("Expected extracted trace %s, got %q", traceID, TraceID(ctx))
+	}
+	if len(TraceID(context.Background())) != 0 {
+		t.Errorf("Expected empty trace ID without a trace")
+	}
+}